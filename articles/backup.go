@@ -0,0 +1,401 @@
+package articles
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/gothinkster/golang-gin-realworld-example-app/common"
+	"github.com/gothinkster/golang-gin-realworld-example-app/users"
+	"gorm.io/gorm"
+)
+
+// backupBatchSize is how many rows are loaded per query while streaming a backup.
+const backupBatchSize = 500
+
+// Backup records reference users by users.UserModel id rather than ArticleUserModel id,
+// so an archive does not depend on how article authors happen to be numbered.
+
+type BackupUser struct {
+	ID           uint      `json:"id"`
+	Username     string    `json:"username"`
+	Email        string    `json:"email"`
+	Bio          string    `json:"bio"`
+	Image        *string   `json:"image"`
+	PasswordHash string    `json:"passwordHash"`
+	Admin        bool      `json:"admin"`
+	Disabled     bool      `json:"disabled"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+type BackupArticle struct {
+	ID             uint      `json:"id"`
+	Slug           string    `json:"slug"`
+	Title          string    `json:"title"`
+	Description    string    `json:"description"`
+	Body           string    `json:"body"`
+	AuthorID       uint      `json:"authorId"`
+	Tags           []string  `json:"tagList"`
+	Draft          bool      `json:"draft"`
+	CommentsLocked bool      `json:"commentsLocked"`
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+type BackupComment struct {
+	ID        uint      `json:"id"`
+	ArticleID uint      `json:"articleId"`
+	AuthorID  uint      `json:"authorId"`
+	Body      string    `json:"body"`
+	Deleted   bool      `json:"deleted"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type BackupTag struct {
+	ID     uint   `json:"id"`
+	Tag    string `json:"tag"`
+	Seeded bool   `json:"seeded"`
+}
+
+type BackupFavorite struct {
+	ArticleID uint      `json:"articleId"`
+	UserID    uint      `json:"userId"`
+	Private   bool      `json:"private"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type BackupFollow struct {
+	FollowingID  uint      `json:"followingId"`
+	FollowedByID uint      `json:"followedById"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// backupStreamer writes every record of one entity to an NDJSON encoder.
+type backupStreamer struct {
+	name   string
+	stream func(db *gorm.DB, enc *json.Encoder) error
+}
+
+// backupStreamers lists the archive entries in dependency order, RestoreBackup reads them in the same order.
+var backupStreamers = []backupStreamer{
+	{"users.ndjson", streamBackupUsers},
+	{"tags.ndjson", streamBackupTags},
+	{"articles.ndjson", streamBackupArticles},
+	{"comments.ndjson", streamBackupComments},
+	{"favorites.ndjson", streamBackupFavorites},
+	{"follows.ndjson", streamBackupFollows},
+}
+
+// StreamBackup writes a zip archive with one NDJSON file per entity to w. Rows are read
+// in batches and written as they are read, so the archive is never held in memory.
+func StreamBackup(w io.Writer) error {
+	db := common.MustGetDB()
+	archive := zip.NewWriter(w)
+	for _, streamer := range backupStreamers {
+		entry, err := archive.Create(streamer.name)
+		if err != nil {
+			return err
+		}
+		if err := streamer.stream(db, json.NewEncoder(entry)); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+func streamBackupUsers(db *gorm.DB, enc *json.Encoder) error {
+	var batch []users.UserModel
+	return db.Order("id").FindInBatches(&batch, backupBatchSize, func(tx *gorm.DB, _ int) error {
+		for _, u := range batch {
+			record := BackupUser{u.ID, u.Username, u.Email, u.Bio, u.Image, u.PasswordHash, u.Admin, u.Disabled, u.CreatedAt}
+			if err := enc.Encode(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
+
+func streamBackupTags(db *gorm.DB, enc *json.Encoder) error {
+	var batch []TagModel
+	return db.Order("id").FindInBatches(&batch, backupBatchSize, func(tx *gorm.DB, _ int) error {
+		for _, t := range batch {
+			if err := enc.Encode(BackupTag{t.ID, t.Tag, t.Seeded}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
+
+func streamBackupArticles(db *gorm.DB, enc *json.Encoder) error {
+	var batch []ArticleModel
+	return db.Preload("Author").Preload("Tags").Order("id").FindInBatches(&batch, backupBatchSize, func(tx *gorm.DB, _ int) error {
+		for _, a := range batch {
+			tags := make([]string, 0, len(a.Tags))
+			for _, t := range a.Tags {
+				tags = append(tags, t.Tag)
+			}
+			record := BackupArticle{a.ID, a.Slug, a.Title, a.Description, a.Body, a.Author.UserModelID, tags, a.Draft, a.CommentsLocked, a.CreatedAt, a.UpdatedAt}
+			if err := enc.Encode(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
+
+func streamBackupComments(db *gorm.DB, enc *json.Encoder) error {
+	var batch []CommentModel
+	return db.Preload("Author").Order("id").FindInBatches(&batch, backupBatchSize, func(tx *gorm.DB, _ int) error {
+		for _, c := range batch {
+			record := BackupComment{c.ID, c.ArticleID, c.Author.UserModelID, c.Body, c.Deleted, c.CreatedAt, c.UpdatedAt}
+			if err := enc.Encode(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
+
+func streamBackupFavorites(db *gorm.DB, enc *json.Encoder) error {
+	var batch []FavoriteModel
+	return db.Preload("FavoriteBy").Order("id").FindInBatches(&batch, backupBatchSize, func(tx *gorm.DB, _ int) error {
+		for _, f := range batch {
+			if err := enc.Encode(BackupFavorite{f.FavoriteID, f.FavoriteBy.UserModelID, f.Private, f.CreatedAt}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
+
+func streamBackupFollows(db *gorm.DB, enc *json.Encoder) error {
+	var batch []users.FollowModel
+	return db.Order("id").FindInBatches(&batch, backupBatchSize, func(tx *gorm.DB, _ int) error {
+		for _, f := range batch {
+			if err := enc.Encode(BackupFollow{f.FollowingID, f.FollowedByID, f.CreatedAt}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
+
+// RestoreBackup reads an archive written by StreamBackup and recreates every entity in a
+// single transaction. Records get fresh ids, references between them are remapped, and any
+// failure rolls the whole import back.
+func RestoreBackup(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+	entries := map[string]*zip.File{}
+	for _, file := range archive.File {
+		entries[file.Name] = file
+	}
+	for _, streamer := range backupStreamers {
+		if entries[streamer.name] == nil {
+			return fmt.Errorf("backup archive is missing %s", streamer.name)
+		}
+	}
+
+	return common.MustGetDB().Transaction(func(tx *gorm.DB) error {
+		restore := backupRestore{
+			tx:           tx,
+			userIDs:      map[uint]uint{},
+			articleIDs:   map[uint]uint{},
+			articleUsers: map[uint]uint{},
+		}
+		if err := readBackupEntry(entries["users.ndjson"], restore.user); err != nil {
+			return err
+		}
+		if err := readBackupEntry(entries["tags.ndjson"], restore.tag); err != nil {
+			return err
+		}
+		if err := readBackupEntry(entries["articles.ndjson"], restore.article); err != nil {
+			return err
+		}
+		if err := readBackupEntry(entries["comments.ndjson"], restore.comment); err != nil {
+			return err
+		}
+		if err := readBackupEntry(entries["favorites.ndjson"], restore.favorite); err != nil {
+			return err
+		}
+		return readBackupEntry(entries["follows.ndjson"], restore.follow)
+	})
+}
+
+// readBackupEntry decodes the NDJSON records of one archive entry and passes each to fn.
+func readBackupEntry[T any](file *zip.File, fn func(T) error) error {
+	reader, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	dec := json.NewDecoder(reader)
+	for dec.More() {
+		var record T
+		if err := dec.Decode(&record); err != nil {
+			return fmt.Errorf("%s: %w", file.Name, err)
+		}
+		if err := fn(record); err != nil {
+			return fmt.Errorf("%s: %w", file.Name, err)
+		}
+	}
+	return nil
+}
+
+// backupRestore maps the ids found in an archive to the ids of the rows created for them.
+type backupRestore struct {
+	tx           *gorm.DB
+	userIDs      map[uint]uint
+	articleIDs   map[uint]uint
+	articleUsers map[uint]uint // users.UserModel id -> ArticleUserModel id
+}
+
+func (restore *backupRestore) userID(backupID uint) (uint, error) {
+	id, ok := restore.userIDs[backupID]
+	if !ok {
+		return 0, fmt.Errorf("unknown user %d", backupID)
+	}
+	return id, nil
+}
+
+func (restore *backupRestore) articleID(backupID uint) (uint, error) {
+	id, ok := restore.articleIDs[backupID]
+	if !ok {
+		return 0, fmt.Errorf("unknown article %d", backupID)
+	}
+	return id, nil
+}
+
+func (restore *backupRestore) articleUserID(backupUserID uint) (uint, error) {
+	userID, err := restore.userID(backupUserID)
+	if err != nil {
+		return 0, err
+	}
+	if id, ok := restore.articleUsers[userID]; ok {
+		return id, nil
+	}
+	var articleUser ArticleUserModel
+	if err := restore.tx.Where(&ArticleUserModel{UserModelID: userID}).FirstOrCreate(&articleUser).Error; err != nil {
+		return 0, err
+	}
+	restore.articleUsers[userID] = articleUser.ID
+	return articleUser.ID, nil
+}
+
+func (restore *backupRestore) user(record BackupUser) error {
+	user := users.UserModel{
+		Username:     record.Username,
+		Email:        record.Email,
+		Bio:          record.Bio,
+		Image:        record.Image,
+		PasswordHash: record.PasswordHash,
+		Admin:        record.Admin,
+		Disabled:     record.Disabled,
+		CreatedAt:    record.CreatedAt,
+	}
+	if err := restore.tx.Create(&user).Error; err != nil {
+		return err
+	}
+	restore.userIDs[record.ID] = user.ID
+	return nil
+}
+
+func (restore *backupRestore) tag(record BackupTag) error {
+	var tag TagModel
+	return restore.tx.Where(TagModel{Tag: record.Tag}).Assign(TagModel{Seeded: record.Seeded}).FirstOrCreate(&tag).Error
+}
+
+func (restore *backupRestore) article(record BackupArticle) error {
+	authorID, err := restore.articleUserID(record.AuthorID)
+	if err != nil {
+		return err
+	}
+	var tags []TagModel
+	if len(record.Tags) > 0 {
+		if err := restore.tx.Where("tag IN ?", record.Tags).Find(&tags).Error; err != nil {
+			return err
+		}
+		if len(tags) != len(record.Tags) {
+			return fmt.Errorf("article %d references unknown tags", record.ID)
+		}
+	}
+	article := ArticleModel{
+		Model:          gorm.Model{CreatedAt: record.CreatedAt, UpdatedAt: record.UpdatedAt},
+		Slug:           record.Slug,
+		Title:          record.Title,
+		Description:    record.Description,
+		Body:           record.Body,
+		AuthorID:       authorID,
+		Tags:           tags,
+		Draft:          record.Draft,
+		CommentsLocked: record.CommentsLocked,
+	}
+	if err := restore.tx.Create(&article).Error; err != nil {
+		return err
+	}
+	restore.articleIDs[record.ID] = article.ID
+	return nil
+}
+
+func (restore *backupRestore) comment(record BackupComment) error {
+	articleID, err := restore.articleID(record.ArticleID)
+	if err != nil {
+		return err
+	}
+	authorID, err := restore.articleUserID(record.AuthorID)
+	if err != nil {
+		return err
+	}
+	return restore.tx.Create(&CommentModel{
+		Model:     gorm.Model{CreatedAt: record.CreatedAt, UpdatedAt: record.UpdatedAt},
+		ArticleID: articleID,
+		AuthorID:  authorID,
+		Body:      record.Body,
+		Deleted:   record.Deleted,
+	}).Error
+}
+
+func (restore *backupRestore) favorite(record BackupFavorite) error {
+	articleID, err := restore.articleID(record.ArticleID)
+	if err != nil {
+		return err
+	}
+	favoriteByID, err := restore.articleUserID(record.UserID)
+	if err != nil {
+		return err
+	}
+	return restore.tx.Create(&FavoriteModel{
+		Model:        gorm.Model{CreatedAt: record.CreatedAt},
+		FavoriteID:   articleID,
+		FavoriteByID: favoriteByID,
+		Private:      record.Private,
+	}).Error
+}
+
+func (restore *backupRestore) follow(record BackupFollow) error {
+	followingID, err := restore.userID(record.FollowingID)
+	if err != nil {
+		return err
+	}
+	followedByID, err := restore.userID(record.FollowedByID)
+	if err != nil {
+		return err
+	}
+	return restore.tx.Create(&users.FollowModel{
+		Model:        gorm.Model{CreatedAt: record.CreatedAt},
+		FollowingID:  followingID,
+		FollowedByID: followedByID,
+	}).Error
+}
@@ -8,5 +8,11 @@ routers.go: router binding and core logic
 serializers.go: definition the schema of return data
 
 validators.go: definition the validator of form data
+
+backup.go: streaming export and import of the whole site for admins
+
+export.go: streaming CSV export of an author's articles
+
+sitemap.go: streaming sitemap.xml of the published articles
 */
 package articles
@@ -0,0 +1,54 @@
+package articles
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+
+	"github.com/gothinkster/golang-gin-realworld-example-app/common"
+	"gorm.io/gorm"
+)
+
+// articlesCSVHeader is the first row of StreamArticlesCSV.
+var articlesCSVHeader = []string{"slug", "title", "favoritesCount", "commentsCount", "createdAt"}
+
+// StreamArticlesCSV writes the articles of the user authorID to w as CSV, oldest first. Rows
+// are read in batches and written as they are read, the csv writer quotes titles that
+// contain commas, quotes or line breaks.
+func StreamArticlesCSV(w io.Writer, authorID uint) error {
+	db := common.MustGetDB()
+	out := csv.NewWriter(w)
+	if err := out.Write(articlesCSVHeader); err != nil {
+		return err
+	}
+	var batch []ArticleModel
+	err := db.Where("author_id IN (?)", db.Model(&ArticleUserModel{}).Select("id").Where("user_model_id = ?", authorID)).
+		Order("id").
+		FindInBatches(&batch, backupBatchSize, func(tx *gorm.DB, _ int) error {
+			ids := make([]uint, 0, len(batch))
+			for _, article := range batch {
+				ids = append(ids, article.ID)
+			}
+			favoriteCounts := BatchGetFavoriteCounts(ids)
+			commentCounts := BatchGetCommentCounts(ids)
+			for _, article := range batch {
+				err := out.Write([]string{
+					article.Slug,
+					article.Title,
+					strconv.FormatUint(uint64(favoriteCounts[article.ID]), 10),
+					strconv.FormatUint(uint64(commentCounts[article.ID]), 10),
+					article.CreatedAt.UTC().Format("2006-01-02T15:04:05.999Z"),
+				})
+				if err != nil {
+					return err
+				}
+			}
+			out.Flush()
+			return out.Error()
+		}).Error
+	if err != nil {
+		return err
+	}
+	out.Flush()
+	return out.Error()
+}
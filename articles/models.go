@@ -1,23 +1,36 @@
 package articles
 
 import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"os"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/gosimple/slug"
 	"github.com/gothinkster/golang-gin-realworld-example-app/common"
 	"github.com/gothinkster/golang-gin-realworld-example-app/users"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type ArticleModel struct {
 	gorm.Model
-	Slug        string `gorm:"uniqueIndex"`
-	Title       string
-	Description string `gorm:"size:2048"`
-	Body        string `gorm:"size:2048"`
-	Author      ArticleUserModel
-	AuthorID    uint
-	Tags        []TagModel     `gorm:"many2many:article_tags;"`
-	Comments    []CommentModel `gorm:"ForeignKey:ArticleID"`
+	Slug           string `gorm:"uniqueIndex"`
+	Title          string
+	Description    string `gorm:"size:2048"`
+	Body           string `gorm:"size:2048"`
+	Author         ArticleUserModel
+	AuthorID       uint
+	Tags           []TagModel     `gorm:"many2many:article_tags;"`
+	Comments       []CommentModel `gorm:"ForeignKey:ArticleID"`
+	CommentsLocked bool           `gorm:"not null;default:false"`
+	// Draft articles are left out of the public listings, feeds and latest article until published.
+	Draft bool `gorm:"not null;default:false"`
 }
 
 type ArticleUserModel struct {
@@ -34,12 +47,66 @@ type FavoriteModel struct {
 	FavoriteID   uint
 	FavoriteBy   ArticleUserModel
 	FavoriteByID uint
+	// Private hides the favorite from other users' ?favorited= filter, it still counts.
+	Private bool `gorm:"not null;default:false"`
+}
+
+// BackfillFavoritePrivacy makes the favorites stored while the private column was nullable
+// public. Run it before migrating FavoriteModel, which cannot make the column NOT NULL over NULLs.
+func BackfillFavoritePrivacy(db *gorm.DB) error {
+	if !db.Migrator().HasColumn(&FavoriteModel{}, "private") {
+		return nil
+	}
+	return db.Model(&FavoriteModel{}).Unscoped().Where("private IS NULL").Update("private", false).Error
 }
 
 type TagModel struct {
 	gorm.Model
 	Tag           string         `gorm:"uniqueIndex"`
 	ArticleModels []ArticleModel `gorm:"many2many:article_tags;"`
+
+	// Seeded marks the tags an admin created with CreateTags, the allowlist of TAG_MODE=allowlist.
+	Seeded bool `gorm:"not null;default:false"`
+}
+
+// TagSubscriptionModel subscribes a user to a tag, bringing its articles into their feed
+// next to the ones of the authors they follow.
+type TagSubscriptionModel struct {
+	gorm.Model
+	UserID uint `gorm:"index"`
+	TagID  uint
+}
+
+// ArticleRevisionModel records the content of an article after one edit, Version counts the edits from 1.
+type ArticleRevisionModel struct {
+	gorm.Model
+	ArticleID   uint `gorm:"index"`
+	Version     int
+	Title       string
+	Description string `gorm:"size:2048"`
+	Body        string `gorm:"size:2048"`
+}
+
+// ErrRevisionNotFound is returned by RevertArticle for a version the article never had.
+var ErrRevisionNotFound = errors.New("revision not found")
+
+// ReadModel marks an article as read by a user.
+type ReadModel struct {
+	gorm.Model
+	UserID    uint `gorm:"uniqueIndex:idx_read_user_article"`
+	ArticleID uint `gorm:"uniqueIndex:idx_read_user_article"`
+	ReadAt    time.Time
+}
+
+// SlugHistoryModel remembers a slug an article used to have, so old links can be redirected.
+type SlugHistoryModel struct {
+	gorm.Model
+	ArticleID uint   `gorm:"index"`
+	Slug      string `gorm:"uniqueIndex"`
+}
+
+func (SlugHistoryModel) TableName() string {
+	return "slug_history"
 }
 
 type CommentModel struct {
@@ -48,7 +115,19 @@ type CommentModel struct {
 	ArticleID uint
 	Author    ArticleUserModel
 	AuthorID  uint
-	Body      string `gorm:"size:2048"`
+	Body      string             `gorm:"size:2048"`
+	Deleted   bool               `gorm:"not null;default:false"`
+	Flags     []CommentFlagModel `gorm:"foreignKey:CommentID"`
+}
+
+// CommentFlagModel is a user's report that a comment needs moderation, open until resolved.
+// A user flags a comment at most once, flagging it again updates the reason and reopens it.
+type CommentFlagModel struct {
+	gorm.Model
+	CommentID uint   `gorm:"uniqueIndex:idx_flag_comment_user"`
+	UserID    uint   `gorm:"uniqueIndex:idx_flag_comment_user"`
+	Reason    string `gorm:"size:255"`
+	Resolved  bool   `gorm:"not null;default:false"`
 }
 
 func GetArticleUserModel(userModel users.UserModel) ArticleUserModel {
@@ -56,7 +135,7 @@ func GetArticleUserModel(userModel users.UserModel) ArticleUserModel {
 	if userModel.ID == 0 {
 		return articleUserModel
 	}
-	db := common.GetDB()
+	db := common.MustGetDB()
 	db.Where(&ArticleUserModel{
 		UserModelID: userModel.ID,
 	}).FirstOrCreate(&articleUserModel)
@@ -64,17 +143,43 @@ func GetArticleUserModel(userModel users.UserModel) ArticleUserModel {
 	return articleUserModel
 }
 
+// favoritesCount counts the favorites of the article, leaving out those of deleted users
+// like BatchGetFavoriteCounts.
 func (article ArticleModel) favoritesCount() uint {
-	db := common.GetDB()
+	db := common.MustGetDB()
+	var count int64
+	db.Model(&FavoriteModel{}).
+		Joins("JOIN article_user_models ON article_user_models.id = favorite_models.favorite_by_id AND article_user_models.deleted_at IS NULL").
+		Where("favorite_models.favorite_id = ?", article.ID).
+		Count(&count)
+	return uint(count)
+}
+
+// commentsCount counts the comments of the article, tombstones excluded.
+func (article ArticleModel) commentsCount() uint {
+	db := common.MustGetDB()
 	var count int64
-	db.Model(&FavoriteModel{}).Where(FavoriteModel{
-		FavoriteID: article.ID,
-	}).Count(&count)
+	db.Model(&CommentModel{}).Where("article_id = ? AND deleted = ?", article.ID, false).Count(&count)
 	return uint(count)
 }
 
+// AuditFavorites counts the favorite rows of an article and the distinct users behind them,
+// the two only differ when duplicate favorite rows crept in.
+func AuditFavorites(articleID uint) (rows, distinct int) {
+	db := common.MustGetDB()
+	var audit struct {
+		RowCount  int
+		UserCount int
+	}
+	db.Model(&FavoriteModel{}).
+		Select("COUNT(*) AS row_count, COUNT(DISTINCT favorite_by_id) AS user_count").
+		Where("favorite_id = ?", articleID).
+		Scan(&audit)
+	return audit.RowCount, audit.UserCount
+}
+
 func (article ArticleModel) isFavoriteBy(user ArticleUserModel) bool {
-	db := common.GetDB()
+	db := common.MustGetDB()
 	var favorite FavoriteModel
 	db.Where(FavoriteModel{
 		FavoriteID:   article.ID,
@@ -83,12 +188,13 @@ func (article ArticleModel) isFavoriteBy(user ArticleUserModel) bool {
 	return favorite.ID != 0
 }
 
-// BatchGetFavoriteCounts returns a map of article ID to favorite count
+// BatchGetFavoriteCounts returns a map of article ID to favorite count.
+// Favorites of soft-deleted articles or soft-deleted favoriting users are not counted.
 func BatchGetFavoriteCounts(articleIDs []uint) map[uint]uint {
 	if len(articleIDs) == 0 {
 		return make(map[uint]uint)
 	}
-	db := common.GetDB()
+	db := common.MustGetDB()
 
 	type result struct {
 		FavoriteID uint
@@ -96,9 +202,11 @@ func BatchGetFavoriteCounts(articleIDs []uint) map[uint]uint {
 	}
 	var results []result
 	db.Model(&FavoriteModel{}).
-		Select("favorite_id, COUNT(*) as count").
-		Where("favorite_id IN ?", articleIDs).
-		Group("favorite_id").
+		Select("favorite_models.favorite_id, COUNT(*) as count").
+		Joins("JOIN article_models ON article_models.id = favorite_models.favorite_id AND article_models.deleted_at IS NULL").
+		Joins("JOIN article_user_models ON article_user_models.id = favorite_models.favorite_by_id AND article_user_models.deleted_at IS NULL").
+		Where("favorite_models.favorite_id IN ?", articleIDs).
+		Group("favorite_models.favorite_id").
 		Find(&results)
 
 	countMap := make(map[uint]uint)
@@ -108,12 +216,57 @@ func BatchGetFavoriteCounts(articleIDs []uint) map[uint]uint {
 	return countMap
 }
 
+// AuthorArticleFavoriteCounts maps the slug of every article of the user authorID to its
+// favorite count, 0 for articles nobody favorited.
+func AuthorArticleFavoriteCounts(authorID uint) map[string]uint {
+	db := common.MustGetDB()
+	var results []struct {
+		Slug  string
+		Count uint
+	}
+	db.Model(&ArticleModel{}).
+		Select("article_models.slug, COUNT(article_user_models.id) AS count").
+		Joins("LEFT JOIN favorite_models ON favorite_models.favorite_id = article_models.id AND favorite_models.deleted_at IS NULL").
+		Joins("LEFT JOIN article_user_models ON article_user_models.id = favorite_models.favorite_by_id AND article_user_models.deleted_at IS NULL").
+		Where("article_models.author_id IN (?)", db.Model(&ArticleUserModel{}).Select("id").Where("user_model_id = ?", authorID)).
+		Group("article_models.id, article_models.slug").
+		Find(&results)
+	counts := make(map[string]uint, len(results))
+	for _, r := range results {
+		counts[r.Slug] = r.Count
+	}
+	return counts
+}
+
+// BatchGetCommentCounts returns a map of article ID to the number of its comments, tombstones excluded.
+// Articles without comments are absent from the map and read as zero.
+func BatchGetCommentCounts(articleIDs []uint) map[uint]uint {
+	counts := make(map[uint]uint)
+	if len(articleIDs) == 0 {
+		return counts
+	}
+	db := common.MustGetDB()
+	var results []struct {
+		ArticleID uint
+		Count     uint
+	}
+	db.Model(&CommentModel{}).
+		Select("article_id, COUNT(*) AS count").
+		Where("article_id IN ? AND deleted = ?", articleIDs, false).
+		Group("article_id").
+		Find(&results)
+	for _, r := range results {
+		counts[r.ArticleID] = r.Count
+	}
+	return counts
+}
+
 // BatchGetFavoriteStatus returns a map of article ID to whether the user favorited it
 func BatchGetFavoriteStatus(articleIDs []uint, userID uint) map[uint]bool {
 	if len(articleIDs) == 0 || userID == 0 {
 		return make(map[uint]bool)
 	}
-	db := common.GetDB()
+	db := common.MustGetDB()
 
 	var favorites []FavoriteModel
 	db.Where("favorite_id IN ? AND favorite_by_id = ?", articleIDs, userID).Find(&favorites)
@@ -125,149 +278,653 @@ func BatchGetFavoriteStatus(articleIDs []uint, userID uint) map[uint]bool {
 	return statusMap
 }
 
+// BatchGetCommentedStatus returns a map of article ID to whether the user, an ArticleUserModel id,
+// commented on it. Tombstoned comments don't count.
+func BatchGetCommentedStatus(articleIDs []uint, userID uint) map[uint]bool {
+	statusMap := make(map[uint]bool)
+	if len(articleIDs) == 0 || userID == 0 {
+		return statusMap
+	}
+	db := common.MustGetDB()
+
+	var commented []uint
+	db.Model(&CommentModel{}).
+		Where("article_id IN ? AND author_id = ? AND deleted = ?", articleIDs, userID, false).
+		Group("article_id").
+		Pluck("article_id", &commented)
+	for _, id := range commented {
+		statusMap[id] = true
+	}
+	return statusMap
+}
+
+// FavoriteMatrix reports, for each of userIDs (users.UserModel ids), whether they favorited the article.
+func FavoriteMatrix(articleID uint, userIDs []uint) map[uint]bool {
+	matrix := make(map[uint]bool, len(userIDs))
+	for _, id := range userIDs {
+		matrix[id] = false
+	}
+	if len(userIDs) == 0 {
+		return matrix
+	}
+	db := common.MustGetDB()
+
+	var favorited []uint
+	db.Model(&FavoriteModel{}).
+		Joins("JOIN article_user_models ON article_user_models.id = favorite_models.favorite_by_id").
+		Where("favorite_models.favorite_id = ? AND article_user_models.user_model_id IN ?", articleID, userIDs).
+		Pluck("article_user_models.user_model_id", &favorited)
+	for _, id := range favorited {
+		matrix[id] = true
+	}
+	return matrix
+}
+
+// BatchGetFavoriteSummary combines BatchGetFavoriteCounts and BatchGetFavoriteStatus in a
+// single query. The viewer is given by users.UserModel id, so no ArticleUserModel lookup is needed.
+func BatchGetFavoriteSummary(articleIDs []uint, userModelID uint) (map[uint]uint, map[uint]bool) {
+	countMap := make(map[uint]uint)
+	statusMap := make(map[uint]bool)
+	if len(articleIDs) == 0 {
+		return countMap, statusMap
+	}
+	db := common.MustGetDB()
+
+	type result struct {
+		FavoriteID uint
+		Count      uint
+		Favorited  bool
+	}
+	var results []result
+	db.Model(&FavoriteModel{}).
+		Select("favorite_models.favorite_id, COUNT(*) as count, MAX(CASE WHEN article_user_models.user_model_id = ? THEN 1 ELSE 0 END) = 1 as favorited", userModelID).
+		Joins("JOIN article_models ON article_models.id = favorite_models.favorite_id AND article_models.deleted_at IS NULL").
+		Joins("JOIN article_user_models ON article_user_models.id = favorite_models.favorite_by_id AND article_user_models.deleted_at IS NULL").
+		Where("favorite_models.favorite_id IN ?", articleIDs).
+		Group("favorite_models.favorite_id").
+		Find(&results)
+
+	for _, r := range results {
+		countMap[r.FavoriteID] = r.Count
+		if userModelID != 0 && r.Favorited {
+			statusMap[r.FavoriteID] = true
+		}
+	}
+	return countMap, statusMap
+}
+
 func (article ArticleModel) favoriteBy(user ArticleUserModel) error {
-	db := common.GetDB()
-	var favorite FavoriteModel
-	err := db.FirstOrCreate(&favorite, &FavoriteModel{
+	_, err := article.favoriteByCreated(user, false)
+	return err
+}
+
+// favoriteByCreated favorites the article, public or private, and reports whether a new
+// favorite row was created (false means the user had already favorited it, in which case
+// only its privacy is updated).
+func (article ArticleModel) favoriteByCreated(user ArticleUserModel, private bool) (bool, error) {
+	db := common.MustGetDB()
+	condition := FavoriteModel{
 		FavoriteID:   article.ID,
 		FavoriteByID: user.ID,
-	}).Error
-	return err
+	}
+	var favorite FavoriteModel
+	err := db.Where(condition).First(&favorite).Error
+	if err == nil {
+		if favorite.Private == private {
+			return false, nil
+		}
+		return false, db.Model(&favorite).Update("private", private).Error
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, err
+	}
+	favorite = condition
+	favorite.Private = private
+	if err := db.Create(&favorite).Error; err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
 func (article ArticleModel) unFavoriteBy(user ArticleUserModel) error {
-	db := common.GetDB()
+	db := common.MustGetDB()
 	err := db.Where("favorite_id = ? AND favorite_by_id = ?", article.ID, user.ID).Delete(&FavoriteModel{}).Error
 	return err
 }
 
+func (article ArticleModel) isReadBy(userID uint) bool {
+	if userID == 0 {
+		return false
+	}
+	db := common.MustGetDB()
+	var count int64
+	db.Model(&ReadModel{}).Where("user_id = ? AND article_id = ?", userID, article.ID).Count(&count)
+	return count > 0
+}
+
+// BatchGetReadStatus returns a map of article ID to whether the user has read it
+func BatchGetReadStatus(articleIDs []uint, userID uint) map[uint]bool {
+	statusMap := make(map[uint]bool)
+	if len(articleIDs) == 0 || userID == 0 {
+		return statusMap
+	}
+	db := common.MustGetDB()
+	var readIDs []uint
+	db.Model(&ReadModel{}).Where("user_id = ? AND article_id IN ?", userID, articleIDs).Pluck("article_id", &readIDs)
+	for _, id := range readIDs {
+		statusMap[id] = true
+	}
+	return statusMap
+}
+
+// markReadBy records that the user read the article, reading it again moves read_at forward.
+func (article ArticleModel) markReadBy(userID uint) error {
+	db := common.MustGetDB()
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "article_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"read_at", "updated_at"}),
+	}).Create(&ReadModel{UserID: userID, ArticleID: article.ID, ReadAt: time.Now()}).Error
+}
+
+// notReadBy is a condition on article_models keeping the articles the user given as its argument has not read.
+const notReadBy = "NOT EXISTS (SELECT 1 FROM read_models WHERE read_models.article_id = article_models.id AND read_models.user_id = ? AND read_models.deleted_at IS NULL)"
+
+// FeedUnread returns a page of the viewer's feed without the articles they have read, with the total count.
+func FeedUnread(viewerID uint, limit, offset int) ([]ArticleModel, int, error) {
+	db := common.MustGetDB()
+	models := make([]ArticleModel, 0)
+	viewer := ArticleUserModel{UserModelID: viewerID}
+	query := viewer.feedQuery(db, FeedFilter{}).Where(notReadBy, viewerID)
+	var count int64
+	if err := query.Session(&gorm.Session{}).Count(&count).Error; err != nil {
+		return models, 0, err
+	}
+	err := query.Preload("Author.UserModel").Preload("Tags").
+		Order("article_models.updated_at desc").
+		Offset(offset).Limit(limit).
+		Find(&models).Error
+	return models, int(count), err
+}
+
+// ReadHistory returns a page of the articles the user marked read, the most recently read
+// first, with the total count.
+func ReadHistory(userID uint, limit, offset int) ([]ArticleModel, int, error) {
+	db := common.MustGetDB()
+	models := make([]ArticleModel, 0)
+	query := db.Model(&ArticleModel{}).
+		Joins("JOIN read_models ON read_models.article_id = article_models.id AND read_models.deleted_at IS NULL").
+		Where("read_models.user_id = ?", userID).
+		Where("article_models.draft = ?", false)
+	var count int64
+	if err := query.Session(&gorm.Session{}).Count(&count).Error; err != nil {
+		return models, 0, err
+	}
+	err := query.Preload("Author.UserModel").Preload("Tags").
+		Order("read_models.read_at desc, article_models.id desc").
+		Offset(offset).Limit(limit).
+		Find(&models).Error
+	return models, int(count), err
+}
+
+// MarkFeedRead marks every article of the user's feed as read and returns how many were unread.
+func MarkFeedRead(userID uint) (int, error) {
+	db := common.MustGetDB()
+	var unreadIDs []uint
+	viewer := ArticleUserModel{UserModelID: userID}
+	err := viewer.feedQuery(db, FeedFilter{}).
+		Where(notReadBy, userID).
+		Pluck("article_models.id", &unreadIDs).Error
+	if err != nil || len(unreadIDs) == 0 {
+		return 0, err
+	}
+	now := time.Now()
+	reads := make([]ReadModel, len(unreadIDs))
+	for i, id := range unreadIDs {
+		reads[i] = ReadModel{UserID: userID, ArticleID: id, ReadAt: now}
+	}
+	err = db.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(reads, 100).Error
+	return len(unreadIDs), err
+}
+
+// ClearFavorites removes every favorite of a user in one transaction and returns how many went.
+// Favorite counts are computed from the rows, so they drop along with them.
+func ClearFavorites(userID uint) (int, error) {
+	db := common.MustGetDB()
+	var removed int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("favorite_by_id IN (?)", tx.Model(&ArticleUserModel{}).Select("id").Where("user_model_id = ?", userID)).
+			Delete(&FavoriteModel{})
+		removed = result.RowsAffected
+		return result.Error
+	})
+	return int(removed), err
+}
+
+// DeleteAuthorArticlesByTag soft deletes the articles of the user authorID carrying tag, with
+// their favorites and comments, in one transaction and returns how many articles went.
+func DeleteAuthorArticlesByTag(authorID uint, tag string) (int, error) {
+	db := common.MustGetDB()
+	var deleted int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var ids []uint
+		err := tx.Model(&ArticleModel{}).
+			Where("author_id IN (?)", tx.Model(&ArticleUserModel{}).Select("id").Where("user_model_id = ?", authorID)).
+			Where("id IN (?)", articleIDsByTag(tx, tag)).
+			Pluck("id", &ids).Error
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+		if err := tx.Where("favorite_id IN ?", ids).Delete(&FavoriteModel{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("article_id IN ?", ids).Delete(&CommentModel{}).Error; err != nil {
+			return err
+		}
+		result := tx.Where("id IN ?", ids).Delete(&ArticleModel{})
+		deleted = result.RowsAffected
+		return result.Error
+	})
+	return int(deleted), err
+}
+
 func SaveOne(data interface{}) error {
-	db := common.GetDB()
+	db := common.MustGetDB()
 	err := db.Save(data).Error
 	return err
 }
 
 func FindOneArticle(condition interface{}) (ArticleModel, error) {
-	db := common.GetDB()
+	return findOneArticle(common.MustGetDB(), condition)
+}
+
+// findOneArticle is FindOneArticle on a given session, such as common.TracingDB.
+func findOneArticle(db *gorm.DB, condition interface{}) (ArticleModel, error) {
 	var model ArticleModel
 	err := db.Preload("Author.UserModel").Preload("Tags").Where(condition).First(&model).Error
 	return model, err
 }
 
 func FindOneComment(condition *CommentModel) (CommentModel, error) {
-	db := common.GetDB()
+	db := common.MustGetDB()
 	var model CommentModel
-	err := db.Preload("Author.UserModel").Preload("Article").Where(condition).First(&model).Error
+	err := db.Preload("Author.UserModel").Preload("Article.Author").Where(condition).First(&model).Error
 	return model, err
 }
 
+// FindCommentsByAuthor returns a page of the comments a user wrote, newest first, with their
+// articles loaded and the total count. Comments on deleted articles are left out.
+func FindCommentsByAuthor(userID uint, limit, offset int) ([]CommentModel, int, error) {
+	db := common.MustGetDB()
+	models := make([]CommentModel, 0)
+	query := db.Model(&CommentModel{}).
+		Joins("JOIN article_models ON article_models.id = comment_models.article_id AND article_models.deleted_at IS NULL").
+		Where("comment_models.author_id IN (?)", db.Model(&ArticleUserModel{}).Select("id").Where("user_model_id = ?", userID))
+	var count int64
+	if err := query.Session(&gorm.Session{}).Count(&count).Error; err != nil {
+		return models, 0, err
+	}
+	err := query.Preload("Author.UserModel").Preload("Article").
+		Order("comment_models.created_at desc, comment_models.id desc").
+		Offset(offset).Limit(limit).
+		Find(&models).Error
+	return models, int(count), err
+}
+
+// FindAllComments returns a page of every comment, newest first, with authors and articles
+// loaded and the total count, for moderators. Comments on deleted articles are left out.
+func FindAllComments(limit, offset int) ([]CommentModel, int, error) {
+	db := common.MustGetDB()
+	models := make([]CommentModel, 0)
+	query := db.Model(&CommentModel{}).
+		Joins("JOIN article_models ON article_models.id = comment_models.article_id AND article_models.deleted_at IS NULL")
+	var count int64
+	if err := query.Session(&gorm.Session{}).Count(&count).Error; err != nil {
+		return models, 0, err
+	}
+	err := query.Preload("Author.UserModel").Preload("Article").
+		Order("comment_models.created_at desc, comment_models.id desc").
+		Offset(offset).Limit(limit).
+		Find(&models).Error
+	return models, int(count), err
+}
+
+// FindMentions returns the articles whose body mentions @username, newest first. It is a
+// plain substring match, so a mention of a longer username sharing the prefix matches too.
+func FindMentions(username string, limit, offset int) ([]ArticleModel, int, error) {
+	db := common.MustGetDB()
+	models := make([]ArticleModel, 0)
+	query := db.Model(&ArticleModel{}).
+		Where("article_models.body LIKE ? ESCAPE '\\'", "%@"+common.EscapeLike(username)+"%").
+		Where("article_models.draft = ?", false)
+	var count int64
+	if err := query.Session(&gorm.Session{}).Count(&count).Error; err != nil {
+		return models, 0, err
+	}
+	err := query.Preload("Author.UserModel").Preload("Tags").
+		Order("article_models.created_at desc, article_models.id desc").
+		Offset(offset).Limit(limit).
+		Find(&models).Error
+	return models, int(count), err
+}
+
+// BatchCountArticlesByAuthor counts the articles of each username in one grouped query,
+// unknown usernames and authors without articles map to 0.
+func BatchCountArticlesByAuthor(usernames []string) map[string]int {
+	counts := make(map[string]int, len(usernames))
+	for _, username := range usernames {
+		counts[username] = 0
+	}
+	if len(usernames) == 0 {
+		return counts
+	}
+	db := common.MustGetDB()
+	var rows []struct {
+		Username string
+		Count    int
+	}
+	db.Model(&ArticleModel{}).
+		Select("user_models.username AS username, COUNT(*) AS count").
+		Joins("JOIN article_user_models ON article_user_models.id = article_models.author_id").
+		Joins("JOIN user_models ON user_models.id = article_user_models.user_model_id").
+		Where("user_models.username IN ?", usernames).
+		Where("article_models.draft = ?", false).
+		Group("user_models.username").
+		Scan(&rows)
+	for _, row := range rows {
+		counts[row.Username] = row.Count
+	}
+	return counts
+}
+
+// FlagComment records that the user wants the comment moderated.
+func FlagComment(commentID, userID uint, reason string) error {
+	db := common.MustGetDB()
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "comment_id"}, {Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"reason", "resolved", "updated_at"}),
+	}).Create(&CommentFlagModel{CommentID: commentID, UserID: userID, Reason: reason}).Error
+}
+
+// FindFlaggedCommentsForAuthor is the moderation queue of an author: the comments on their
+// articles with open flags, newest first, with those flags loaded.
+func FindFlaggedCommentsForAuthor(authorID uint) ([]CommentModel, error) {
+	db := common.MustGetDB()
+	models := make([]CommentModel, 0)
+	openFlags := db.Model(&CommentFlagModel{}).Select("comment_id").Where("resolved = ?", false)
+	err := db.Model(&CommentModel{}).
+		Joins("JOIN article_models ON article_models.id = comment_models.article_id AND article_models.deleted_at IS NULL").
+		Where("article_models.author_id IN (?)", db.Model(&ArticleUserModel{}).Select("id").Where("user_model_id = ?", authorID)).
+		Where("comment_models.id IN (?)", openFlags).
+		Preload("Author.UserModel").Preload("Article").
+		Preload("Flags", "resolved = ?", false).
+		Order("comment_models.created_at desc, comment_models.id desc").
+		Find(&models).Error
+	return models, err
+}
+
 func (self *ArticleModel) getComments() error {
-	db := common.GetDB()
+	db := common.MustGetDB()
 	err := db.Preload("Author.UserModel").Model(self).Association("Comments").Find(&self.Comments)
 	return err
 }
 
+// tagsCache keeps the full tag list in memory for TAGS_CACHE_TTL, since tags change
+// far less often than they are listed.
+type tagsCache struct {
+	sync.Mutex
+	tags    []TagModel
+	expires time.Time
+}
+
+var allTagsCache tagsCache
+
+func (cache *tagsCache) get() ([]TagModel, bool) {
+	cache.Lock()
+	defer cache.Unlock()
+	if cache.tags == nil || time.Now().After(cache.expires) {
+		return nil, false
+	}
+	return cache.tags, true
+}
+
+func (cache *tagsCache) set(tags []TagModel, ttl time.Duration) {
+	cache.Lock()
+	defer cache.Unlock()
+	cache.tags = tags
+	cache.expires = time.Now().Add(ttl)
+}
+
+func (cache *tagsCache) invalidate() {
+	cache.Lock()
+	defer cache.Unlock()
+	cache.tags = nil
+}
+
+func tagsCacheTTL() time.Duration {
+	return common.GetEnvDuration("TAGS_CACHE_TTL", time.Minute)
+}
+
+// GetCommentsPaged returns a page of an article's comments ordered by creation time,
+// "asc" (chronological, the default) or "desc", with the total count. A negative limit means no limit.
+func GetCommentsPaged(articleID uint, limit, offset int, order string) ([]CommentModel, int, error) {
+	db := common.MustGetDB()
+	models := make([]CommentModel, 0)
+	direction := "asc"
+	if order == "desc" {
+		direction = "desc"
+	}
+	query := db.Model(&CommentModel{}).Where("article_id = ?", articleID)
+	var count int64
+	if err := query.Session(&gorm.Session{}).Count(&count).Error; err != nil {
+		return models, 0, err
+	}
+	err := query.Preload("Author.UserModel").
+		Order("created_at " + direction).Order("id " + direction).
+		Offset(offset).Limit(limit).
+		Find(&models).Error
+	return models, int(count), err
+}
+
 func getAllTags() ([]TagModel, error) {
-	db := common.GetDB()
-	var models []TagModel
+	ttl := tagsCacheTTL()
+	if ttl > 0 {
+		if tags, ok := allTagsCache.get(); ok {
+			return tags, nil
+		}
+	}
+	db := common.MustGetDB()
+	models := make([]TagModel, 0)
 	err := db.Find(&models).Error
+	if err == nil && ttl > 0 {
+		allTagsCache.set(models, ttl)
+	}
 	return models, err
 }
 
-func FindManyArticle(tag, author, limit, offset, favorited string) ([]ArticleModel, int, error) {
-	db := common.GetDB()
-	var models []ArticleModel
-	var count int
+// FindArticlesForAudit returns a page of all articles, newest first, with the total count.
+// With includeDeleted the soft-deleted ones are listed too.
+func FindArticlesForAudit(includeDeleted bool, limit, offset int) ([]ArticleModel, int, error) {
+	db := common.MustGetDB()
+	models := make([]ArticleModel, 0)
+	query := db.Model(&ArticleModel{})
+	if includeDeleted {
+		query = query.Unscoped()
+	}
+	var count int64
+	if err := query.Session(&gorm.Session{}).Count(&count).Error; err != nil {
+		return models, 0, err
+	}
+	err := query.Preload("Author.UserModel").Preload("Tags").
+		Order("article_models.created_at desc, article_models.id desc").
+		Offset(offset).Limit(limit).
+		Find(&models).Error
+	return models, int(count), err
+}
 
-	offset_int, errOffset := strconv.Atoi(offset)
-	if errOffset != nil {
-		offset_int = 0
+// FindLatestArticle returns the newest published article that has not been deleted.
+func FindLatestArticle() (ArticleModel, error) {
+	db := common.MustGetDB()
+	var model ArticleModel
+	err := db.Preload("Author.UserModel").Preload("Tags").
+		Where("article_models.draft = ?", false).
+		Order("article_models.created_at desc, article_models.id desc").
+		First(&model).Error
+	return model, err
+}
+
+// FindArticlesBySlugs loads the articles with the given slugs in the requested order,
+// silently skipping slugs that don't exist.
+func FindArticlesBySlugs(slugs []string) ([]ArticleModel, error) {
+	models := make([]ArticleModel, 0)
+	if len(slugs) == 0 {
+		return models, nil
+	}
+	db := common.MustGetDB()
+	var found []ArticleModel
+	if err := db.Preload("Author.UserModel").Preload("Tags").Where("slug IN ? AND draft = ?", slugs, false).Find(&found).Error; err != nil {
+		return models, err
+	}
+	bySlug := make(map[string]ArticleModel)
+	for _, model := range found {
+		bySlug[model.Slug] = model
 	}
+	for _, slug := range slugs {
+		if model, ok := bySlug[slug]; ok {
+			models = append(models, model)
+			delete(bySlug, slug)
+		}
+	}
+	return models, nil
+}
 
-	limit_int, errLimit := strconv.Atoi(limit)
-	if errLimit != nil {
-		limit_int = 20
+// Sort orders accepted by the article list.
+const (
+	ArticleSortRecent   = ""
+	ArticleSortComments = "comments"
+	ArticleSortHot      = "hot"
+)
+
+// hotGravity is how fast favorites lose weight with age in findHotPage, HOT_GRAVITY, default 1.8.
+func hotGravity() float64 {
+	return common.GetEnvFloat("HOT_GRAVITY", 1.8)
+}
+
+// favoriteCountsQuery is a subquery of favorite_id and favorite_count per article, leaving
+// out the favorites of deleted users like BatchGetFavoriteCounts. Join it with LEFT JOIN.
+func favoriteCountsQuery(db *gorm.DB) *gorm.DB {
+	return db.Model(&FavoriteModel{}).
+		Select("favorite_models.favorite_id, COUNT(*) AS favorite_count").
+		Joins("JOIN article_user_models ON article_user_models.id = favorite_models.favorite_by_id AND article_user_models.deleted_at IS NULL").
+		Group("favorite_models.favorite_id")
+}
+
+// findHotPage orders the articles matching query hottest first by
+// favoritesCount / (age in hours + 2)^HOT_GRAVITY, so a recent article with a few favorites can
+// outrank an old popular one. Equal scores keep the newest first. Ranking happens in SQL so only
+// the requested page is loaded.
+func findHotPage(tx, query *gorm.DB, limit, offset int) ([]ArticleModel, error) {
+	hours := common.HoursSinceExpr(tx, "article_models.created_at")
+	score := fmt.Sprintf("1.0 * COALESCE(favorites.favorite_count, 0) / pow((CASE WHEN %[1]s > 0 THEN %[1]s ELSE 0 END) + 2, %[2]s)",
+		hours, strconv.FormatFloat(hotGravity(), 'f', -1, 64))
+	var ids []uint
+	err := query.Joins("LEFT JOIN (?) AS favorites ON favorites.favorite_id = article_models.id", favoriteCountsQuery(tx)).
+		Order(score+" DESC, article_models.created_at DESC, article_models.id DESC").
+		Offset(offset).Limit(limit).
+		Pluck("article_models.id", &ids).Error
+	if err != nil || len(ids) == 0 {
+		return make([]ArticleModel, 0), err
 	}
+	return loadArticlesInOrder(tx, ids)
+}
 
-	tx := db.Begin()
-	if tag != "" {
-		var tagModel TagModel
-		tx.Where(TagModel{Tag: tag}).First(&tagModel)
-		if tagModel.ID != 0 {
-			// Get article IDs via association
-			var tempModels []ArticleModel
-			if err := tx.Model(&tagModel).Offset(offset_int).Limit(limit_int).Association("ArticleModels").Find(&tempModels); err != nil {
-				tx.Rollback()
-				return models, count, err
-			}
-			count = int(tx.Model(&tagModel).Association("ArticleModels").Count())
-			// Fetch articles with preloaded associations in single query, ordered by updated_at desc
-			if len(tempModels) > 0 {
-				var ids []uint
-				for _, m := range tempModels {
-					ids = append(ids, m.ID)
-				}
-				tx.Preload("Author.UserModel").Preload("Tags").Where("id IN ?", ids).Order("updated_at desc").Find(&models)
-			}
-		}
-	} else if author != "" {
-		var userModel users.UserModel
-		tx.Where(users.UserModel{Username: author}).First(&userModel)
-		articleUserModel := GetArticleUserModel(userModel)
-
-		if articleUserModel.ID != 0 {
-			count = int(tx.Model(&articleUserModel).Association("ArticleModels").Count())
-			// Get article IDs via association
-			var tempModels []ArticleModel
-			if err := tx.Model(&articleUserModel).Offset(offset_int).Limit(limit_int).Association("ArticleModels").Find(&tempModels); err != nil {
-				tx.Rollback()
-				return models, count, err
-			}
-			// Fetch articles with preloaded associations in single query, ordered by updated_at desc
-			if len(tempModels) > 0 {
-				var ids []uint
-				for _, m := range tempModels {
-					ids = append(ids, m.ID)
-				}
-				tx.Preload("Author.UserModel").Preload("Tags").Where("id IN ?", ids).Order("updated_at desc").Find(&models)
-			}
-		}
-	} else if favorited != "" {
-		var userModel users.UserModel
-		tx.Where(users.UserModel{Username: favorited}).First(&userModel)
-		articleUserModel := GetArticleUserModel(userModel)
-		if articleUserModel.ID != 0 {
-			var favoriteModels []FavoriteModel
-			tx.Where(FavoriteModel{
-				FavoriteByID: articleUserModel.ID,
-			}).Offset(offset_int).Limit(limit_int).Find(&favoriteModels)
-
-			count = int(tx.Model(&articleUserModel).Association("FavoriteModels").Count())
-			// Batch fetch articles to avoid N+1 query
-			if len(favoriteModels) > 0 {
-				var ids []uint
-				for _, favorite := range favoriteModels {
-					ids = append(ids, favorite.FavoriteID)
-				}
-				tx.Preload("Author.UserModel").Preload("Tags").Where("id IN ?", ids).Order("updated_at desc").Find(&models)
-			}
+// loadArticlesInOrder loads the articles with the given ids, with authors and tags, in the
+// order of ids. Ids that no longer match an article are skipped.
+func loadArticlesInOrder(db *gorm.DB, ids []uint) ([]ArticleModel, error) {
+	models := make([]ArticleModel, 0, len(ids))
+	var loaded []ArticleModel
+	if err := db.Preload("Author.UserModel").Preload("Tags").Where("id IN ?", ids).Find(&loaded).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]ArticleModel, len(loaded))
+	for _, article := range loaded {
+		byID[article.ID] = article
+	}
+	for _, id := range ids {
+		if article, ok := byID[id]; ok {
+			models = append(models, article)
 		}
-	} else {
-		var count64 int64
-		tx.Model(&ArticleModel{}).Count(&count64)
-		count = int(count64)
-		tx.Offset(offset_int).Limit(limit_int).Preload("Author.UserModel").Preload("Tags").Find(&models)
 	}
+	return models, nil
+}
 
-	err := tx.Commit().Error
-	return models, count, err
+// ArticleListFilter holds the conditions of an article list query. Every non-empty
+// condition narrows the result, so filters compose with each other and with Sort.
+type ArticleListFilter struct {
+	Tag       string
+	Author    string
+	Favorited string
+	Sort      string
+	Limit     int
+	Offset    int
+	// ViewerID is the authenticated user id, 0 for anonymous viewers.
+	ViewerID   uint
+	ExcludeOwn bool
+	// MinFavorites keeps only the articles favorited at least this many times, 0 disables it.
+	MinFavorites int
+	// Search keeps the articles containing the term in their title, description or body and,
+	// with the default sort, ranks title matches first.
+	Search string
 }
 
-func (self *ArticleUserModel) GetArticleFeed(limit, offset string) ([]ArticleModel, int, error) {
-	db := common.GetDB()
-	models := make([]ArticleModel, 0)
-	var count int
+// searchColumnWeights is how much a search hit in each column adds to an article's relevance.
+var searchColumnWeights = []struct {
+	column string
+	weight int
+}{
+	{"article_models.title", 4},
+	{"article_models.description", 2},
+	{"article_models.body", 1},
+}
+
+// searchCondition keeps the articles containing term in any of the searched columns, ignoring case.
+func searchCondition(term string) clause.Expr {
+	pattern := "%" + common.EscapeLike(strings.ToLower(term)) + "%"
+	conditions := make([]string, 0, len(searchColumnWeights))
+	vars := make([]interface{}, 0, len(searchColumnWeights))
+	for _, c := range searchColumnWeights {
+		conditions = append(conditions, "LOWER("+c.column+") LIKE ? ESCAPE '\\'")
+		vars = append(vars, pattern)
+	}
+	return clause.Expr{SQL: "(" + strings.Join(conditions, " OR ") + ")", Vars: vars}
+}
+
+// selectSearchRelevance adds a relevance column to query, the summed weight of the columns
+// containing term, with plain CASE expressions so SQLite and Postgres both run it.
+func selectSearchRelevance(query *gorm.DB, term string) *gorm.DB {
+	pattern := "%" + common.EscapeLike(strings.ToLower(term)) + "%"
+	cases := make([]string, 0, len(searchColumnWeights))
+	vars := make([]interface{}, 0, len(searchColumnWeights))
+	for _, c := range searchColumnWeights {
+		cases = append(cases, fmt.Sprintf("CASE WHEN LOWER(%s) LIKE ? ESCAPE '\\' THEN %d ELSE 0 END", c.column, c.weight))
+		vars = append(vars, pattern)
+	}
+	return query.Select("article_models.*, ("+strings.Join(cases, " + ")+") AS relevance", vars...)
+}
+
+// NewArticleListFilter builds a filter from raw query values, falling back to
+// limit 20 and offset 0 when they are not numbers.
+func NewArticleListFilter(tag, author, limit, offset, favorited string) ArticleListFilter {
+	limit_int, offset_int := parseLimitOffset(limit, offset)
+	return ArticleListFilter{
+		Tag:       tag,
+		Author:    author,
+		Favorited: favorited,
+		Limit:     limit_int,
+		Offset:    offset_int,
+	}
+}
 
+func parseLimitOffset(limit, offset string) (int, int) {
 	offset_int, errOffset := strconv.Atoi(offset)
 	if errOffset != nil {
 		offset_int = 0
@@ -276,46 +933,1026 @@ func (self *ArticleUserModel) GetArticleFeed(limit, offset string) ([]ArticleMod
 	if errLimit != nil {
 		limit_int = 20
 	}
+	return limit_int, offset_int
+}
 
-	tx := db.Begin()
-	followings := self.UserModel.GetFollowings()
-
-	// Batch get ArticleUserModel IDs to avoid N+1 query
-	if len(followings) > 0 {
-		var followingUserIDs []uint
-		for _, following := range followings {
-			followingUserIDs = append(followingUserIDs, following.ID)
-		}
+// articleUserIDsByUsername is a subquery selecting the ArticleUserModel ids of a username.
+func articleUserIDsByUsername(db *gorm.DB, username string) *gorm.DB {
+	return db.Model(&ArticleUserModel{}).
+		Select("article_user_models.id").
+		Joins("JOIN user_models ON user_models.id = article_user_models.user_model_id").
+		Where("user_models.username = ?", username)
+}
 
-		var articleUserModels []ArticleUserModel
-		tx.Where("user_model_id IN ?", followingUserIDs).Find(&articleUserModels)
+// articleIDsByTag is a subquery selecting the ids of the articles carrying a tag.
+func articleIDsByTag(db *gorm.DB, tag string) *gorm.DB {
+	return db.Table("article_tags").
+		Select("article_tags.article_model_id").
+		Joins("JOIN tag_models ON tag_models.id = article_tags.tag_model_id AND tag_models.deleted_at IS NULL").
+		Where("tag_models.tag = ?", tag)
+}
 
-		var authorIDs []uint
-		for _, aum := range articleUserModels {
-			authorIDs = append(authorIDs, aum.ID)
-		}
+func FindManyArticle(tag, author, limit, offset, favorited string) ([]ArticleModel, int, error) {
+	return FindManyArticleWithFilter(NewArticleListFilter(tag, author, limit, offset, favorited))
+}
 
-		if len(authorIDs) > 0 {
-			var count64 int64
-			tx.Model(&ArticleModel{}).Where("author_id IN ?", authorIDs).Count(&count64)
-			count = int(count64)
-			tx.Preload("Author.UserModel").Preload("Tags").Where("author_id IN ?", authorIDs).Order("updated_at desc").Offset(offset_int).Limit(limit_int).Find(&models)
-		}
-	}
+// ErrInvalidCursor is returned by FindArticlesByCursor for a cursor it did not issue.
+var ErrInvalidCursor = errors.New("invalid cursor")
 
-	err := tx.Commit().Error
-	return models, count, err
+// encodeArticleCursor makes the opaque cursor pointing after article, URL safe.
+func encodeArticleCursor(article ArticleModel) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(article.UpdatedAt.Format(time.RFC3339Nano) + "," + strconv.FormatUint(uint64(article.ID), 10)))
 }
 
-func (model *ArticleModel) setTags(tags []string) error {
-	if len(tags) == 0 {
-		model.Tags = []TagModel{}
-		return nil
+func decodeArticleCursor(cursor string) (time.Time, uint, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, ErrInvalidCursor
 	}
-
-	db := common.GetDB()
-
-	// Batch fetch existing tags
+	updatedAt, id, ok := strings.Cut(string(raw), ",")
+	if !ok {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+	// Parsing keeps the stored offset, so the time compares equal to the stored column
+	at, err := time.Parse(time.RFC3339Nano, updatedAt)
+	if err != nil {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+	articleID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+	return at, uint(articleID), nil
+}
+
+// FindArticlesByCursor pages through the articles by most recent update, the id breaking ties.
+// An empty cursor starts from the top and nextCursor is empty on the last page. Unlike offsets,
+// cursors don't drift when articles are created between two pages.
+func FindArticlesByCursor(cursor string, limit int) ([]ArticleModel, string, error) {
+	db := common.MustGetDB()
+	models := make([]ArticleModel, 0)
+	query := db.Model(&ArticleModel{}).Where("article_models.draft = ?", false)
+	if cursor != "" {
+		updatedAt, id, err := decodeArticleCursor(cursor)
+		if err != nil {
+			return models, "", err
+		}
+		query = query.Where("article_models.updated_at < ? OR (article_models.updated_at = ? AND article_models.id < ?)", updatedAt, updatedAt, id)
+	}
+	// Fetch one extra article to know whether there is a next page
+	err := query.Preload("Author.UserModel").Preload("Tags").
+		Order("article_models.updated_at desc, article_models.id desc").
+		Limit(limit + 1).
+		Find(&models).Error
+	if err != nil || len(models) <= limit {
+		return models, "", err
+	}
+	models = models[:limit]
+	return models, encodeArticleCursor(models[limit-1]), nil
+}
+
+func FindManyArticleWithFilter(filter ArticleListFilter) ([]ArticleModel, int, error) {
+	db := common.MustGetDB()
+	models := make([]ArticleModel, 0)
+	var count int
+
+	tx := db.Begin()
+	query := tx.Model(&ArticleModel{}).Where("article_models.draft = ?", false)
+	if filter.Tag != "" {
+		query = query.Where("article_models.id IN (?)", articleIDsByTag(tx, filter.Tag))
+	}
+	if filter.Author != "" {
+		query = query.Where("article_models.author_id IN (?)", articleUserIDsByUsername(tx, filter.Author))
+	}
+	if filter.Favorited != "" {
+		// Private favorites only show up for their owner.
+		query = query.Where("article_models.id IN (?)", tx.Model(&FavoriteModel{}).
+			Select("favorite_models.favorite_id").
+			Where("favorite_models.favorite_by_id IN (?)", articleUserIDsByUsername(tx, filter.Favorited)).
+			Where("favorite_models.private = ? OR favorite_models.favorite_by_id IN (?)", false,
+				tx.Model(&ArticleUserModel{}).Select("id").Where("user_model_id = ?", filter.ViewerID)))
+	}
+	if filter.MinFavorites > 0 {
+		query = query.Where("article_models.id IN (?)", tx.Model(&FavoriteModel{}).
+			Select("favorite_models.favorite_id").
+			Group("favorite_models.favorite_id").
+			Having("COUNT(*) >= ?", filter.MinFavorites))
+	}
+	if filter.Search != "" {
+		query = query.Where(searchCondition(filter.Search))
+	}
+	if filter.ExcludeOwn && filter.ViewerID != 0 {
+		query = query.Where("article_models.author_id NOT IN (?)", tx.Model(&ArticleUserModel{}).
+			Select("id").
+			Where("user_model_id = ?", filter.ViewerID))
+	}
+
+	var count64 int64
+	if err := query.Session(&gorm.Session{}).Count(&count64).Error; err != nil {
+		tx.Rollback()
+		return models, count, err
+	}
+	count = int(count64)
+
+	if filter.Sort == ArticleSortHot {
+		hot, err := findHotPage(tx, query, filter.Limit, filter.Offset)
+		if err != nil {
+			tx.Rollback()
+			return models, count, err
+		}
+		err = tx.Commit().Error
+		return hot, count, err
+	}
+
+	switch filter.Sort {
+	case ArticleSortComments:
+		// Counted like BatchGetCommentCounts, so the order matches the commentsCount shown
+		// A single expression, later Order calls would replace it
+		byComments := "(SELECT COUNT(*) FROM comment_models WHERE comment_models.article_id = article_models.id" +
+			" AND comment_models.deleted = ? AND comment_models.deleted_at IS NULL) DESC," +
+			" article_models.created_at DESC, article_models.id DESC"
+		query = query.Order(clause.OrderBy{Expression: clause.Expr{SQL: byComments, Vars: []interface{}{false}}})
+	default:
+		if filter.Search != "" {
+			query = selectSearchRelevance(query, filter.Search).Order("relevance DESC")
+		}
+		query = query.Order("article_models.updated_at desc")
+	}
+	err := query.
+		Offset(filter.Offset).Limit(filter.Limit).
+		Preload("Author.UserModel").Preload("Tags").
+		Find(&models).Error
+	if err != nil {
+		tx.Rollback()
+		return models, count, err
+	}
+
+	err = tx.Commit().Error
+	return models, count, err
+}
+
+// FeedFilter narrows the feed of followed authors. Zero values mean no condition.
+type FeedFilter struct {
+	Tag    string
+	After  time.Time
+	Before time.Time
+	Limit  int
+	Offset int
+}
+
+func (self *ArticleUserModel) GetArticleFeed(limit, offset string) ([]ArticleModel, int, error) {
+	limit_int, offset_int := parseLimitOffset(limit, offset)
+	return self.GetArticleFeedWithFilter(FeedFilter{Limit: limit_int, Offset: offset_int})
+}
+
+// followedAuthorIDs is a subquery selecting the ArticleUserModel ids of the authors a user follows.
+func followedAuthorIDs(db *gorm.DB, userID uint) *gorm.DB {
+	return db.Model(&ArticleUserModel{}).
+		Select("article_user_models.id").
+		Where("article_user_models.user_model_id IN (?)", db.Model(&users.FollowModel{}).
+			Select("following_id").
+			Where("followed_by_id = ?", userID))
+}
+
+// subscribedTagArticleIDs is a subquery selecting the ids of the articles carrying a tag the user subscribed to.
+func subscribedTagArticleIDs(db *gorm.DB, userID uint) *gorm.DB {
+	return db.Table("article_tags").
+		Select("article_tags.article_model_id").
+		Where("article_tags.tag_model_id IN (?)", db.Model(&TagSubscriptionModel{}).
+			Select("tag_id").
+			Where("user_id = ?", userID))
+}
+
+// GetCombinedFeed returns a page of the feed of viewerID, the articles of followed authors
+// and of subscribed tags, each listed once, with the total count.
+func GetCombinedFeed(viewerID uint, limit, offset int) ([]ArticleModel, int, error) {
+	viewer := ArticleUserModel{UserModelID: viewerID}
+	return viewer.GetArticleFeedWithFilter(FeedFilter{Limit: limit, Offset: offset})
+}
+
+func FindOneTag(tag string) (TagModel, error) {
+	db := common.MustGetDB()
+	var model TagModel
+	err := db.Where("tag = ?", tag).First(&model).Error
+	return model, err
+}
+
+// SubscribeTag subscribes the user to tag, subscribing twice keeps a single subscription.
+func SubscribeTag(userID uint, tag TagModel) error {
+	db := common.MustGetDB()
+	var subscription TagSubscriptionModel
+	return db.FirstOrCreate(&subscription, &TagSubscriptionModel{UserID: userID, TagID: tag.ID}).Error
+}
+
+func UnsubscribeTag(userID uint, tag TagModel) error {
+	db := common.MustGetDB()
+	return db.Where("user_id = ? AND tag_id = ?", userID, tag.ID).Delete(&TagSubscriptionModel{}).Error
+}
+
+// ListTagSubscriptions returns the tags the user subscribed to, alphabetically.
+func ListTagSubscriptions(userID uint) ([]string, error) {
+	db := common.MustGetDB()
+	tags := make([]string, 0)
+	err := db.Model(&TagModel{}).
+		Where("id IN (?)", db.Model(&TagSubscriptionModel{}).Select("tag_id").Where("user_id = ?", userID)).
+		Order("tag").
+		Pluck("tag", &tags).Error
+	return tags, err
+}
+
+// LatestPerAuthor returns the newest article of every author viewerID follows, newest first.
+func LatestPerAuthor(viewerID uint) ([]ArticleModel, error) {
+	db := common.MustGetDB()
+	models := make([]ArticleModel, 0)
+	err := db.Preload("Author.UserModel").Preload("Tags").
+		Where("article_models.author_id IN (?)", followedAuthorIDs(db, viewerID)).
+		Where(`article_models.id = (SELECT newest.id FROM article_models AS newest
+			WHERE newest.author_id = article_models.author_id AND newest.deleted_at IS NULL AND newest.draft = ?
+			ORDER BY newest.created_at DESC, newest.id DESC LIMIT 1)`, false).
+		Order("article_models.created_at DESC").
+		Find(&models).Error
+	return models, err
+}
+
+// feedQuery selects the articles of the authors self follows and, from other authors, the
+// articles carrying a tag self subscribed to, narrowed by filter.
+func (self *ArticleUserModel) feedQuery(db *gorm.DB, filter FeedFilter) *gorm.DB {
+	query := db.Model(&ArticleModel{}).
+		Where("article_models.author_id IN (?) OR (article_models.id IN (?) AND article_models.author_id NOT IN (?))",
+			followedAuthorIDs(db, self.UserModelID),
+			subscribedTagArticleIDs(db, self.UserModelID),
+			db.Model(&ArticleUserModel{}).Select("id").Where("user_model_id = ?", self.UserModelID)).
+		Where("article_models.draft = ?", false)
+	if filter.Tag != "" {
+		query = query.Where("article_models.id IN (?)", articleIDsByTag(db, filter.Tag))
+	}
+	if !filter.After.IsZero() {
+		query = query.Where("article_models.created_at > ?", filter.After)
+	}
+	if !filter.Before.IsZero() {
+		query = query.Where("article_models.created_at < ?", filter.Before)
+	}
+	return query
+}
+
+// CountArticleFeed counts the feed articles matching filter without loading them.
+func (self *ArticleUserModel) CountArticleFeed(filter FeedFilter) (int, error) {
+	db := common.MustGetDB()
+	var count int64
+	err := self.feedQuery(db, filter).Count(&count).Error
+	return int(count), err
+}
+
+func (self *ArticleUserModel) GetArticleFeedWithFilter(filter FeedFilter) ([]ArticleModel, int, error) {
+	db := common.MustGetDB()
+	models := make([]ArticleModel, 0)
+	var count int
+
+	tx := db.Begin()
+	query := self.feedQuery(tx, filter)
+
+	var count64 int64
+	if err := query.Session(&gorm.Session{}).Count(&count64).Error; err != nil {
+		tx.Rollback()
+		return models, count, err
+	}
+	count = int(count64)
+
+	err := query.Preload("Author.UserModel").Preload("Tags").
+		Order("article_models.updated_at desc").
+		Offset(filter.Offset).Limit(filter.Limit).
+		Find(&models).Error
+	if err != nil {
+		tx.Rollback()
+		return models, count, err
+	}
+
+	err = tx.Commit().Error
+	return models, count, err
+}
+
+// TagCount is a tag with the number of matching rows, used by the ranked tag queries.
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// FavoriteTagsRanked ranks the tags of the articles a user favorited by how often they appear.
+//
+//	interests, err := FavoriteTagsRanked(userModel.ID, 10)
+func FavoriteTagsRanked(userID uint, limit int) ([]TagCount, error) {
+	db := common.MustGetDB()
+	results := make([]TagCount, 0)
+	err := db.Model(&FavoriteModel{}).
+		Select("tag_models.tag AS tag, COUNT(*) AS count").
+		Joins("JOIN article_user_models ON article_user_models.id = favorite_models.favorite_by_id").
+		Joins("JOIN article_models ON article_models.id = favorite_models.favorite_id AND article_models.deleted_at IS NULL").
+		Joins("JOIN article_tags ON article_tags.article_model_id = article_models.id").
+		Joins("JOIN tag_models ON tag_models.id = article_tags.tag_model_id AND tag_models.deleted_at IS NULL").
+		Where("article_user_models.user_model_id = ?", userID).
+		Group("tag_models.tag").
+		Order("count DESC, tag_models.tag ASC").
+		Limit(limit).
+		Scan(&results).Error
+	return results, err
+}
+
+// NetworkFavorites returns the articles favorited by the users viewerID follows, the ones
+// favorited by most of them first.
+func NetworkFavorites(viewerID uint, limit int) ([]ArticleModel, error) {
+	db := common.MustGetDB()
+	models := make([]ArticleModel, 0)
+	var ranked []struct {
+		FavoriteID uint
+		Fans       int
+	}
+	err := db.Model(&FavoriteModel{}).
+		Select("favorite_models.favorite_id AS favorite_id, COUNT(DISTINCT article_user_models.user_model_id) AS fans").
+		Joins("JOIN article_user_models ON article_user_models.id = favorite_models.favorite_by_id").
+		Joins("JOIN article_models ON article_models.id = favorite_models.favorite_id AND article_models.deleted_at IS NULL").
+		Where("article_models.draft = ? AND favorite_models.private = ?", false, false).
+		Where("article_user_models.user_model_id IN (?)", db.Model(&users.FollowModel{}).
+			Select("following_id").
+			Where("followed_by_id = ?", viewerID)).
+		Group("favorite_models.favorite_id").
+		Order("fans DESC, favorite_models.favorite_id DESC").
+		Limit(limit).
+		Scan(&ranked).Error
+	if err != nil || len(ranked) == 0 {
+		return models, err
+	}
+	ids := make([]uint, len(ranked))
+	for i, row := range ranked {
+		ids[i] = row.FavoriteID
+	}
+	found, err := loadArticlesInOrder(db, ids)
+	if err != nil {
+		return models, err
+	}
+	return found, nil
+}
+
+// ForYou recommends articles sharing tags with the ones viewerID favorited, leaving out the
+// viewer's own and already favorited articles. Articles sharing more of those tags come
+// first, then the newest.
+func ForYou(viewerID uint, limit int) ([]ArticleModel, error) {
+	db := common.MustGetDB()
+	models := make([]ArticleModel, 0)
+	viewerArticleUserIDs := db.Model(&ArticleUserModel{}).Select("id").Where("user_model_id = ?", viewerID)
+	favoritedIDs := db.Model(&FavoriteModel{}).Select("favorite_id").Where("favorite_by_id IN (?)", viewerArticleUserIDs)
+	interestTagIDs := db.Table("article_tags").Select("tag_model_id").Where("article_model_id IN (?)", favoritedIDs)
+	var ranked []struct {
+		ArticleID uint
+		Overlap   int
+	}
+	err := db.Model(&ArticleModel{}).
+		Select("article_models.id AS article_id, COUNT(DISTINCT article_tags.tag_model_id) AS overlap").
+		Joins("JOIN article_tags ON article_tags.article_model_id = article_models.id").
+		Joins("JOIN tag_models ON tag_models.id = article_tags.tag_model_id AND tag_models.deleted_at IS NULL").
+		Where("article_tags.tag_model_id IN (?)", interestTagIDs).
+		Where("article_models.id NOT IN (?)", favoritedIDs).
+		Where("article_models.author_id NOT IN (?)", viewerArticleUserIDs).
+		Where("article_models.draft = ?", false).
+		Group("article_models.id").
+		Order("overlap DESC, article_models.created_at DESC, article_models.id DESC").
+		Limit(limit).
+		Scan(&ranked).Error
+	if err != nil || len(ranked) == 0 {
+		return models, err
+	}
+	ids := make([]uint, len(ranked))
+	for i, row := range ranked {
+		ids[i] = row.ArticleID
+	}
+	found, err := loadArticlesInOrder(db, ids)
+	if err != nil {
+		return models, err
+	}
+	return found, nil
+}
+
+// AuthorTagBreakdown counts the articles of authorID per tag, most used tag first.
+func AuthorTagBreakdown(authorID uint) ([]TagCount, error) {
+	db := common.MustGetDB()
+	results := make([]TagCount, 0)
+	err := db.Table("article_tags").
+		Select("tag_models.tag AS tag, COUNT(*) AS count").
+		Joins("JOIN article_models ON article_models.id = article_tags.article_model_id AND article_models.deleted_at IS NULL").
+		Joins("JOIN tag_models ON tag_models.id = article_tags.tag_model_id AND tag_models.deleted_at IS NULL").
+		Where("article_models.author_id IN (?)", db.Model(&ArticleUserModel{}).Select("id").Where("user_model_id = ?", authorID)).
+		Where("article_models.draft = ?", false).
+		Group("tag_models.tag").
+		Order("count DESC, tag_models.tag ASC").
+		Scan(&results).Error
+	return results, err
+}
+
+// Cadence describes how regularly an author publishes, in days.
+type Cadence struct {
+	AverageDaysBetween float64 `json:"averageDaysBetween"`
+	LongestGapDays     float64 `json:"longestGapDays"`
+	// CurrentStreakDays counts the consecutive UTC days with an article up to today,
+	// or up to yesterday while today has none yet.
+	CurrentStreakDays int `json:"currentStreakDays"`
+}
+
+// PublishingCadence computes the Cadence of the user authorID from the creation times of
+// their articles. Without two articles there is no gap, so those figures stay 0.
+func PublishingCadence(authorID uint) (Cadence, error) {
+	db := common.MustGetDB()
+	var cadence Cadence
+	var createdAts []time.Time
+	err := db.Model(&ArticleModel{}).
+		Where("author_id IN (?)", db.Model(&ArticleUserModel{}).Select("id").Where("user_model_id = ?", authorID)).
+		Where("draft = ?", false).
+		Order("created_at").
+		Pluck("created_at", &createdAts).Error
+	if err != nil || len(createdAts) == 0 {
+		return cadence, err
+	}
+
+	if len(createdAts) > 1 {
+		for i := 1; i < len(createdAts); i++ {
+			cadence.LongestGapDays = math.Max(cadence.LongestGapDays, createdAts[i].Sub(createdAts[i-1]).Hours()/24)
+		}
+		cadence.AverageDaysBetween = createdAts[len(createdAts)-1].Sub(createdAts[0]).Hours() / 24 / float64(len(createdAts)-1)
+	}
+
+	published := make(map[string]bool, len(createdAts))
+	for _, createdAt := range createdAts {
+		published[createdAt.UTC().Format("2006-01-02")] = true
+	}
+	day := time.Now().UTC()
+	if !published[day.Format("2006-01-02")] {
+		day = day.AddDate(0, 0, -1)
+	}
+	for published[day.Format("2006-01-02")] {
+		cadence.CurrentStreakDays++
+		day = day.AddDate(0, 0, -1)
+	}
+	return cadence, nil
+}
+
+// TrendingTags ranks tags by how many articles carrying them were published in the last days.
+func TrendingTags(days, limit int) ([]TagCount, error) {
+	db := common.MustGetDB()
+	results := make([]TagCount, 0)
+	err := db.Table("article_tags").
+		Select("tag_models.tag AS tag, COUNT(*) AS count").
+		Joins("JOIN tag_models ON tag_models.id = article_tags.tag_model_id AND tag_models.deleted_at IS NULL").
+		Joins("JOIN article_models ON article_models.id = article_tags.article_model_id AND article_models.deleted_at IS NULL").
+		Where("article_models.created_at >= ?", time.Now().AddDate(0, 0, -days)).
+		Where("article_models.draft = ?", false).
+		Group("tag_models.tag").
+		Order("count DESC, tag_models.tag ASC").
+		Limit(limit).
+		Scan(&results).Error
+	return results, err
+}
+
+// TopArticlePerTag returns the most favorited article of every tag, the newest one when
+// several tie, including at no favorites.
+func TopArticlePerTag() (map[string]ArticleModel, error) {
+	top := make(map[string]ArticleModel)
+	ranked, err := TopArticlesPerTag(1)
+	for tag, articles := range ranked {
+		top[tag] = articles[0]
+	}
+	return top, err
+}
+
+// TopArticlesPerTag returns up to perTag published articles of every tag, ranked like TopArticlePerTag.
+func TopArticlesPerTag(perTag int) (map[string][]ArticleModel, error) {
+	db := common.MustGetDB()
+	top := make(map[string][]ArticleModel)
+	ranking := db.Table("article_tags").
+		Select("tag_models.tag AS tag, article_models.id AS article_id, "+
+			"ROW_NUMBER() OVER (PARTITION BY tag_models.id ORDER BY "+
+			"(SELECT COUNT(*) FROM favorite_models WHERE favorite_models.favorite_id = article_models.id AND favorite_models.deleted_at IS NULL) DESC, "+
+			"article_models.created_at DESC, article_models.id DESC) AS tag_rank").
+		Joins("JOIN tag_models ON tag_models.id = article_tags.tag_model_id AND tag_models.deleted_at IS NULL").
+		Joins("JOIN article_models ON article_models.id = article_tags.article_model_id AND article_models.deleted_at IS NULL").
+		Where("article_models.draft = ?", false)
+	var rows []struct {
+		Tag       string
+		ArticleID uint
+	}
+	err := db.Table("(?) AS ranked", ranking).
+		Select("tag, article_id").
+		Where("tag_rank <= ?", perTag).
+		Order("tag, tag_rank").
+		Scan(&rows).Error
+	if err != nil || len(rows) == 0 {
+		return top, err
+	}
+	ids := make([]uint, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, row.ArticleID)
+	}
+	articles, err := loadArticlesInOrder(db, ids)
+	if err != nil {
+		return top, err
+	}
+	byID := make(map[uint]ArticleModel, len(articles))
+	for _, article := range articles {
+		byID[article.ID] = article
+	}
+	for _, row := range rows {
+		if article, ok := byID[row.ArticleID]; ok {
+			top[row.Tag] = append(top[row.Tag], article)
+		}
+	}
+	return top, nil
+}
+
+// TopArticles returns a page of the published articles ranked by favorites, the newest first
+// among equals, with the total count of published articles. Like BatchGetFavoriteCounts, it
+// does not count the favorites of deleted users.
+func TopArticles(limit, offset int) ([]ArticleModel, int, error) {
+	db := common.MustGetDB()
+	models := make([]ArticleModel, 0)
+	query := db.Model(&ArticleModel{}).Where("article_models.draft = ?", false)
+	var count int64
+	if err := query.Session(&gorm.Session{}).Count(&count).Error; err != nil {
+		return models, 0, err
+	}
+	var ids []uint
+	err := query.Joins("LEFT JOIN (?) AS favorites ON favorites.favorite_id = article_models.id", favoriteCountsQuery(db)).
+		Order("COALESCE(favorites.favorite_count, 0) DESC, article_models.created_at DESC, article_models.id DESC").
+		Offset(offset).Limit(limit).
+		Pluck("article_models.id", &ids).Error
+	if err != nil || len(ids) == 0 {
+		return models, int(count), err
+	}
+	models, err = loadArticlesInOrder(db, ids)
+	return models, int(count), err
+}
+
+// RelatedTags ranks the tags found on articles carrying tag by how often they appear with it.
+func RelatedTags(tag string, limit int) ([]TagCount, error) {
+	db := common.MustGetDB()
+	results := make([]TagCount, 0)
+	err := db.Table("article_tags AS given").
+		Select("related_tags.tag AS tag, COUNT(*) AS count").
+		Joins("JOIN tag_models AS given_tags ON given_tags.id = given.tag_model_id AND given_tags.deleted_at IS NULL").
+		Joins("JOIN article_tags AS related ON related.article_model_id = given.article_model_id AND related.tag_model_id <> given.tag_model_id").
+		Joins("JOIN tag_models AS related_tags ON related_tags.id = related.tag_model_id AND related_tags.deleted_at IS NULL").
+		Joins("JOIN article_models ON article_models.id = given.article_model_id AND article_models.deleted_at IS NULL").
+		Where("given_tags.tag = ?", tag).
+		Where("article_models.draft = ?", false).
+		Group("related_tags.tag").
+		Order("count DESC, related_tags.tag ASC").
+		Limit(limit).
+		Scan(&results).Error
+	return results, err
+}
+
+// maxTagsForArticlesIDs bounds how many articles TagsForArticles looks at in one call.
+const maxTagsForArticlesIDs = 100
+
+// TagsForArticles returns the sorted distinct tags carried by the given articles,
+// looking at no more than maxTagsForArticlesIDs of them.
+func TagsForArticles(ids []uint) ([]string, error) {
+	tags := make([]string, 0)
+	if len(ids) == 0 {
+		return tags, nil
+	}
+	if len(ids) > maxTagsForArticlesIDs {
+		ids = ids[:maxTagsForArticlesIDs]
+	}
+	db := common.MustGetDB()
+	err := db.Model(&TagModel{}).
+		Distinct("tag_models.tag").
+		Joins("JOIN article_tags ON article_tags.tag_model_id = tag_models.id").
+		Joins("JOIN article_models ON article_models.id = article_tags.article_model_id AND article_models.deleted_at IS NULL").
+		Where("article_tags.article_model_id IN ?", ids).
+		Where("article_models.draft = ?", false).
+		Order("tag_models.tag ASC").
+		Pluck("tag_models.tag", &tags).Error
+	return tags, err
+}
+
+// ArchiveBucket is the number of articles created in one year-month, formatted as "2006-01".
+type ArchiveBucket struct {
+	Month string `json:"month"`
+	Count int    `json:"count"`
+}
+
+// ArticleArchiveCounts counts the articles per creation month, newest month first.
+func ArticleArchiveCounts() ([]ArchiveBucket, error) {
+	db := common.MustGetDB()
+	buckets := make([]ArchiveBucket, 0)
+	month := common.DateBucketExpr(db, "article_models.created_at", "month")
+	err := db.Model(&ArticleModel{}).
+		Select(month+" AS month, COUNT(*) AS count").
+		Where("article_models.draft = ?", false).
+		Group(month).
+		Order("month DESC").
+		Scan(&buckets).Error
+	return buckets, err
+}
+
+// TimeBucket is the number of events in one day or week, labelled by its first day as "2006-01-02".
+type TimeBucket struct {
+	Bucket string `json:"bucket"`
+	Count  int    `json:"count"`
+}
+
+// FavoriteTimeline counts the favorites of an article per "day" or "week", oldest bucket first.
+func FavoriteTimeline(articleID uint, bucket string) ([]TimeBucket, error) {
+	db := common.MustGetDB()
+	buckets := make([]TimeBucket, 0)
+	expr := common.DateBucketExpr(db, "favorite_models.created_at", bucket)
+	err := db.Model(&FavoriteModel{}).
+		Select(expr+" AS bucket, COUNT(*) AS count").
+		Where("favorite_models.favorite_id = ?", articleID).
+		Group(expr).
+		Order("bucket ASC").
+		Scan(&buckets).Error
+	return buckets, err
+}
+
+// DeleteUnusedTags removes the tags no article carries and returns how many went. Seeded tags
+// and tags someone subscribed to are kept. They are deleted for good, a soft-deleted row would
+// still hold the unique tag name.
+func DeleteUnusedTags() (int, error) {
+	db := common.MustGetDB()
+	result := db.Unscoped().
+		Where("NOT EXISTS (SELECT 1 FROM article_tags WHERE article_tags.tag_model_id = tag_models.id)").
+		Where("seeded = ?", false).
+		Where("NOT EXISTS (SELECT 1 FROM tag_subscription_models WHERE tag_subscription_models.tag_id = tag_models.id AND tag_subscription_models.deleted_at IS NULL)").
+		Delete(&TagModel{})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected > 0 {
+		allTagsCache.invalidate()
+	}
+	return int(result.RowsAffected), nil
+}
+
+// normalizeTags trims the tags and drops the empty and repeated ones, keeping the first order.
+func normalizeTags(tags []string) []string {
+	normalized := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
+
+// CreateTags seeds tags: it creates the ones that don't exist yet, without attaching them to
+// any article, marks them all Seeded and returns the ones it created. Running it again with
+// the same tags creates nothing.
+func CreateTags(tags []string) ([]string, error) {
+	db := common.MustGetDB()
+	created := make([]string, 0)
+	tags = normalizeTags(tags)
+	for _, tag := range tags {
+		result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&TagModel{Tag: tag, Seeded: true})
+		if result.Error != nil {
+			return created, result.Error
+		}
+		if result.RowsAffected > 0 {
+			created = append(created, tag)
+		}
+	}
+	// Tags that already existed, created on the fly by an article, become seeded too
+	if len(tags) > len(created) {
+		if err := db.Model(&TagModel{}).Where("tag IN ?", tags).UpdateColumn("seeded", true).Error; err != nil {
+			return created, err
+		}
+	}
+	if len(created) > 0 {
+		allTagsCache.invalidate()
+	}
+	return created, nil
+}
+
+// UserDraftSummary counts a user's drafts, LastEdited is the most recently updated one or nil.
+type UserDraftSummary struct {
+	Count      int
+	LastEdited *ArticleModel
+}
+
+// DraftSummary summarizes the drafts of the user authorID for a "resume draft" widget.
+func DraftSummary(authorID uint) (UserDraftSummary, error) {
+	db := common.MustGetDB()
+	var summary UserDraftSummary
+	drafts := db.Model(&ArticleModel{}).
+		Where("author_id IN (?)", db.Model(&ArticleUserModel{}).Select("id").Where("user_model_id = ?", authorID)).
+		Where("draft = ?", true)
+	var count int64
+	if err := drafts.Session(&gorm.Session{}).Count(&count).Error; err != nil || count == 0 {
+		return summary, err
+	}
+	summary.Count = int(count)
+	var lastEdited ArticleModel
+	if err := drafts.Order("updated_at desc, id desc").First(&lastEdited).Error; err != nil {
+		return summary, err
+	}
+	summary.LastEdited = &lastEdited
+	return summary, nil
+}
+
+// UserWritingStats summarizes what a user has written.
+type UserWritingStats struct {
+	Words            int     `json:"words"`
+	Articles         int     `json:"articles"`
+	Comments         int     `json:"comments"`
+	AverageFavorites float64 `json:"averageFavorites"`
+}
+
+// WritingStats counts the words and articles a user has written, the comments they left and
+// the favorites their articles average. A user without articles gets zeros.
+func WritingStats(userID uint) (UserWritingStats, error) {
+	db := common.MustGetDB()
+	var stats UserWritingStats
+	authorIDs := db.Model(&ArticleUserModel{}).Select("id").Where("user_model_id = ?", userID)
+
+	var bodies []string
+	if err := db.Model(&ArticleModel{}).Where("author_id IN (?)", authorIDs).Pluck("body", &bodies).Error; err != nil {
+		return stats, err
+	}
+	stats.Articles = len(bodies)
+	for _, body := range bodies {
+		stats.Words += len(strings.Fields(body))
+	}
+
+	var comments int64
+	if err := db.Model(&CommentModel{}).Where("author_id IN (?)", authorIDs).Count(&comments).Error; err != nil {
+		return stats, err
+	}
+	stats.Comments = int(comments)
+
+	var favorites int64
+	err := db.Model(&FavoriteModel{}).
+		Joins("JOIN article_models ON article_models.id = favorite_models.favorite_id AND article_models.deleted_at IS NULL").
+		Where("article_models.author_id IN (?)", authorIDs).
+		Count(&favorites).Error
+	if err != nil {
+		return stats, err
+	}
+	if stats.Articles > 0 {
+		stats.AverageFavorites = float64(favorites) / float64(stats.Articles)
+	}
+	return stats, nil
+}
+
+// TrendResult compares the favorites received in the last period with the period before it.
+// Change is the percentage change, nil when the previous period had no favorites.
+type TrendResult struct {
+	PeriodDays int      `json:"periodDays"`
+	Current    int      `json:"current"`
+	Previous   int      `json:"previous"`
+	Change     *float64 `json:"change"`
+}
+
+// FavoritesTrend counts the favorites a user's articles received in the last periodDays days
+// and in the periodDays days before that.
+func FavoritesTrend(userID uint, periodDays int) (TrendResult, error) {
+	db := common.MustGetDB()
+	result := TrendResult{PeriodDays: periodDays}
+	now := time.Now()
+	period := time.Duration(periodDays) * 24 * time.Hour
+	authorIDs := db.Model(&ArticleUserModel{}).Select("id").Where("user_model_id = ?", userID)
+
+	for _, window := range []struct {
+		total *int
+		from  time.Time
+		to    time.Time
+	}{
+		{&result.Current, now.Add(-period), now},
+		{&result.Previous, now.Add(-2 * period), now.Add(-period)},
+	} {
+		var count int64
+		err := db.Model(&FavoriteModel{}).
+			Joins("JOIN article_models ON article_models.id = favorite_models.favorite_id AND article_models.deleted_at IS NULL").
+			Where("article_models.author_id IN (?)", authorIDs).
+			Where("favorite_models.created_at >= ? AND favorite_models.created_at < ?", window.from, window.to).
+			Count(&count).Error
+		if err != nil {
+			return result, err
+		}
+		*window.total = int(count)
+	}
+	if result.Previous > 0 {
+		change := float64(result.Current-result.Previous) / float64(result.Previous) * 100
+		result.Change = &change
+	}
+	return result, nil
+}
+
+// FanSummary is a user who favorited an author's articles and how many of them.
+type FanSummary struct {
+	Username  string  `json:"username"`
+	Image     *string `json:"image"`
+	Favorites int     `json:"favorites"`
+}
+
+// AuthorFans ranks the users who favorited the articles of authorID by how many favorites they gave.
+// The author's own favorites are left out.
+func AuthorFans(authorID uint, limit int) ([]FanSummary, error) {
+	db := common.MustGetDB()
+	fans := make([]FanSummary, 0)
+	err := db.Model(&FavoriteModel{}).
+		Select("user_models.username AS username, user_models.image AS image, COUNT(*) AS favorites").
+		Joins("JOIN article_models ON article_models.id = favorite_models.favorite_id AND article_models.deleted_at IS NULL").
+		Joins("JOIN article_user_models ON article_user_models.id = favorite_models.favorite_by_id AND article_user_models.deleted_at IS NULL").
+		Joins("JOIN user_models ON user_models.id = article_user_models.user_model_id").
+		Where("article_models.author_id IN (?)", db.Model(&ArticleUserModel{}).Select("id").Where("user_model_id = ?", authorID)).
+		Where("user_models.id <> ?", authorID).
+		Where("favorite_models.private = ?", false).
+		Group("user_models.id, user_models.username, user_models.image").
+		Order("favorites DESC, user_models.username ASC").
+		Limit(limit).
+		Scan(&fans).Error
+	return fans, err
+}
+
+// slugSeparator reads SLUG_SEPARATOR, the string joining the words of a slug.
+func slugSeparator() string {
+	if separator := os.Getenv("SLUG_SEPARATOR"); separator != "" {
+		return separator
+	}
+	return "-"
+}
+
+// slugMaxLength reads SLUG_MAX_LENGTH, the longest slug in bytes, default 80. 0 disables the limit.
+func slugMaxLength() int {
+	return common.GetEnvInt("SLUG_MAX_LENGTH", 80)
+}
+
+// truncateSlug shortens s to at most max bytes at the last separator that fits, so words
+// are not cut in half. Only a first word longer than max is cut inside the word.
+func truncateSlug(s string, max int) string {
+	if max <= 0 || len(s) <= max {
+		return s
+	}
+	separator := slugSeparator()
+	if strings.HasPrefix(s[max:], separator) {
+		return s[:max]
+	}
+	if i := strings.LastIndex(s[:max], separator); i > 0 {
+		return s[:i]
+	}
+	return s[:max]
+}
+
+// MakeSlug turns a title into a slug using the configured separator and maximum length.
+func MakeSlug(title string) string {
+	return truncateSlug(strings.ReplaceAll(slug.Make(title), "-", slugSeparator()), slugMaxLength())
+}
+
+// uniqueSlug returns base, or base with a numeric suffix when another article already uses it.
+// base is shortened to make room for the suffix within SLUG_MAX_LENGTH.
+// Soft-deleted articles keep their slug in the unique index, so they count as taken too.
+func uniqueSlug(tx *gorm.DB, base string, articleID uint) (string, error) {
+	for n := 1; ; n++ {
+		candidate := slugCandidate(base, n)
+		var count int64
+		err := tx.Unscoped().Model(&ArticleModel{}).Where("slug = ? AND id <> ?", candidate, articleID).Count(&count).Error
+		if err != nil || count == 0 {
+			return candidate, err
+		}
+	}
+}
+
+// slugCandidate is the nth slug tried for base: base itself first, then base with the
+// suffix n, shortened to keep within SLUG_MAX_LENGTH.
+func slugCandidate(base string, n int) string {
+	if n < 2 {
+		return base
+	}
+	suffix := fmt.Sprintf("%s%d", slugSeparator(), n)
+	if max := slugMaxLength(); max > 0 {
+		return truncateSlug(base, max-len(suffix)) + suffix
+	}
+	return base + suffix
+}
+
+// ReslugAll recomputes the slug of every article from its title with the current slug
+// configuration, keeping the old slugs in the history, and returns how many changed.
+// The final slugs are all settled before any is written, so a slug an article gives up
+// is free for the others and the result doesn't depend on the order articles come in.
+func ReslugAll() (int, error) {
+	db := common.MustGetDB()
+	changed := 0
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var articles []ArticleModel
+		if err := tx.Unscoped().Order("id").Find(&articles).Error; err != nil {
+			return err
+		}
+		used := make(map[string]bool, len(articles))
+		newSlugs := make([]string, len(articles))
+		// Articles whose slug is already right keep it
+		for i, article := range articles {
+			if base := MakeSlug(article.Title); article.Slug == base && !used[base] {
+				used[base] = true
+				newSlugs[i] = base
+			}
+		}
+		for i, article := range articles {
+			if newSlugs[i] != "" {
+				continue
+			}
+			base := MakeSlug(article.Title)
+			candidate := base
+			for n := 2; used[candidate]; n++ {
+				candidate = slugCandidate(base, n)
+			}
+			used[candidate] = true
+			newSlugs[i] = candidate
+		}
+
+		var moved []int
+		for i, article := range articles {
+			if newSlugs[i] == article.Slug {
+				continue
+			}
+			moved = append(moved, i)
+			// Park the slug first, its new value may still be held by an article not updated yet
+			if err := tx.Unscoped().Model(&article).UpdateColumn("slug", fmt.Sprintf("#reslug-%d", article.ID)).Error; err != nil {
+				return err
+			}
+		}
+		for _, i := range moved {
+			article := articles[i]
+			// A slug taken over by another article is no old link of this one
+			if err := recordSlugChange(tx, article.ID, article.Slug, newSlugs[i], !used[article.Slug]); err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Model(&article).UpdateColumn("slug", newSlugs[i]).Error; err != nil {
+				return err
+			}
+		}
+		changed = len(moved)
+		return nil
+	})
+	return changed, err
+}
+
+// recordSlugChange updates the slug history for an article moving from oldSlug to newSlug,
+// keeping oldSlug as a redirect when keepOld is set.
+func recordSlugChange(tx *gorm.DB, articleID uint, oldSlug, newSlug string, keepOld bool) error {
+	// The new slug may be an old one of this or another article coming back into use
+	if err := tx.Unscoped().Where("slug IN ?", []string{newSlug, oldSlug}).Delete(&SlugHistoryModel{}).Error; err != nil {
+		return err
+	}
+	if !keepOld {
+		return nil
+	}
+	return tx.Create(&SlugHistoryModel{ArticleID: articleID, Slug: oldSlug}).Error
+}
+
+// FindSlugRedirect returns the current slug of the article that used to be reachable at oldSlug.
+func FindSlugRedirect(oldSlug string) (string, error) {
+	db := common.MustGetDB()
+	var article ArticleModel
+	err := db.Where("id = (?)", db.Model(&SlugHistoryModel{}).Select("article_id").Where("slug = ?", oldSlug)).
+		First(&article).Error
+	return article.Slug, err
+}
+
+// ErrTooManyTags is returned by setTags when an article would carry more than MAX_TAGS_PER_ARTICLE tags.
+var ErrTooManyTags = errors.New("too many tags")
+
+func maxTagsPerArticle() int {
+	return common.GetEnvInt("MAX_TAGS_PER_ARTICLE", 10)
+}
+
+// ErrTagsNotAllowed is returned by setTags for tags missing from the allowlist when TAG_MODE=allowlist.
+var ErrTagsNotAllowed = errors.New("tags not allowed")
+
+// tagAllowlistMode reports whether TAG_MODE=allowlist, where articles may only use the tags
+// that already exist, seeded with CreateTags. The default open mode creates tags on the fly.
+func tagAllowlistMode() bool {
+	return os.Getenv("TAG_MODE") == "allowlist"
+}
+
+func (model *ArticleModel) setTags(tags []string) error {
+	return model.setTagsTx(common.MustGetDB(), tags)
+}
+
+// setTagsTx is setTags on a given session, so the tags it creates share a transaction.
+func (model *ArticleModel) setTagsTx(db *gorm.DB, tags []string) error {
+	// A repeated tag is attached once, so it counts once toward the limit
+	tags = normalizeTags(tags)
+	if len(tags) == 0 {
+		model.Tags = []TagModel{}
+		return nil
+	}
+	// Checked before touching the DB, so a rejected list leaves no tags behind
+	if len(tags) > maxTagsPerArticle() {
+		return fmt.Errorf("%w: at most %d allowed", ErrTooManyTags, maxTagsPerArticle())
+	}
+
+	// Batch fetch existing tags
 	var existingTags []TagModel
 	db.Where("tag IN ?", tags).Find(&existingTags)
 
@@ -325,6 +1962,18 @@ func (model *ArticleModel) setTags(tags []string) error {
 		existingTagMap[t.Tag] = t
 	}
 
+	if tagAllowlistMode() {
+		var disallowed []string
+		for _, tag := range tags {
+			if _, ok := existingTagMap[tag]; !ok {
+				disallowed = append(disallowed, tag)
+			}
+		}
+		if len(disallowed) > 0 {
+			return fmt.Errorf("%w: %s", ErrTagsNotAllowed, strings.Join(disallowed, ", "))
+		}
+	}
+
 	// Create missing tags and build final list
 	var tagList []TagModel
 	for _, tag := range tags {
@@ -342,6 +1991,7 @@ func (model *ArticleModel) setTags(tags []string) error {
 				}
 				return err
 			}
+			allTagsCache.invalidate()
 			tagList = append(tagList, newTag)
 		}
 	}
@@ -349,20 +1999,200 @@ func (model *ArticleModel) setTags(tags []string) error {
 	return nil
 }
 
+// CreateArticleWithTags saves a new article, tags it and records its first revision in one
+// transaction, so a failure while tagging leaves neither the article nor its new tags behind.
+// A slug another article already uses gets a numeric suffix.
+func CreateArticleWithTags(article *ArticleModel, tags []string) error {
+	return common.WithTx(func(tx *gorm.DB) error {
+		article.Tags = nil
+		slug, err := uniqueSlug(tx, article.Slug, 0)
+		if err != nil {
+			return err
+		}
+		article.Slug = slug
+		if err := tx.Create(article).Error; err != nil {
+			return err
+		}
+		if err := article.setTagsTx(tx, tags); err != nil {
+			return err
+		}
+		if len(article.Tags) > 0 {
+			if err := tx.Model(article).Association("Tags").Append(article.Tags); err != nil {
+				return err
+			}
+		}
+		// Revision 1 keeps the content as published, so the first edit can be reverted
+		return recordRevision(tx, *article)
+	})
+}
+
+// Edit saves an author's changes like Update. A new title gets a new slug, with a numeric
+// suffix when another article already uses it, and the old slug is kept to redirect old links.
+// An unchanged title keeps the current slug.
+func (model *ArticleModel) Edit(changes ArticleModel) error {
+	db := common.MustGetDB()
+	return db.Transaction(func(tx *gorm.DB) error {
+		changes.Slug = model.Slug
+		if changes.Title != model.Title {
+			slug, err := uniqueSlug(tx, MakeSlug(changes.Title), model.ID)
+			if err != nil {
+				return err
+			}
+			if slug != model.Slug {
+				if err := recordSlugChange(tx, model.ID, model.Slug, slug, true); err != nil {
+					return err
+				}
+				changes.Slug = slug
+			}
+		}
+		return model.updateTx(tx, changes)
+	})
+}
+
+// Update saves the changed fields and records them as a new revision. Articles without any
+// revision yet, saved before revisions were kept, first get one for their current content.
 func (model *ArticleModel) Update(data interface{}) error {
-	db := common.GetDB()
-	err := db.Model(model).Updates(data).Error
-	return err
+	db := common.MustGetDB()
+	return db.Transaction(func(tx *gorm.DB) error {
+		return model.updateTx(tx, data)
+	})
+}
+
+func (model *ArticleModel) updateTx(tx *gorm.DB, data interface{}) error {
+	var revisions int64
+	if err := tx.Model(&ArticleRevisionModel{}).Where("article_id = ?", model.ID).Count(&revisions).Error; err != nil {
+		return err
+	}
+	if revisions == 0 {
+		if err := recordRevision(tx, *model); err != nil {
+			return err
+		}
+	}
+	if err := tx.Model(model).Updates(data).Error; err != nil {
+		return err
+	}
+	return recordRevision(tx, *model)
+}
+
+// Permissions tells a viewer what they may do with an article.
+type Permissions struct {
+	CanEdit    bool `json:"canEdit"`
+	CanDelete  bool `json:"canDelete"`
+	CanComment bool `json:"canComment"`
+}
+
+// ArticlePermissions computes what user may do with article: the author may edit and delete it,
+// admins may delete it for moderation, and any signed in user may comment unless it is locked.
+// article must have its Author loaded, as FindOneArticle does.
+func ArticlePermissions(article ArticleModel, user users.UserModel) Permissions {
+	if user.ID == 0 {
+		return Permissions{}
+	}
+	isAuthor := article.Author.UserModelID == user.ID
+	return Permissions{
+		CanEdit:    isAuthor,
+		CanDelete:  isAuthor || user.Admin,
+		CanComment: !article.CommentsLocked,
+	}
+}
+
+// setCommentsLocked opens or closes the comment thread, it is not an edit so no revision is recorded.
+func (model *ArticleModel) setCommentsLocked(locked bool) error {
+	db := common.MustGetDB()
+	return db.Model(model).Update("comments_locked", locked).Error
+}
+
+// SetPublished publishes the article articleID or pulls it back to draft, which hides it from
+// the public listings and feeds. Its favorites and comments are kept either way.
+func SetPublished(articleID uint, published bool) error {
+	db := common.MustGetDB()
+	return db.Model(&ArticleModel{}).Where("id = ?", articleID).Update("draft", !published).Error
+}
+
+// RecordRevision appends a revision for the current state of article.
+func RecordRevision(article ArticleModel) error {
+	return recordRevision(common.MustGetDB(), article)
+}
+
+func recordRevision(tx *gorm.DB, article ArticleModel) error {
+	// Reload so the snapshot holds the stored content, not whatever the caller kept around
+	var current ArticleModel
+	if err := tx.First(&current, article.ID).Error; err != nil {
+		return err
+	}
+	var latest int
+	err := tx.Model(&ArticleRevisionModel{}).
+		Select("COALESCE(MAX(version), 0)").
+		Where("article_id = ?", article.ID).
+		Scan(&latest).Error
+	if err != nil {
+		return err
+	}
+	return tx.Create(&ArticleRevisionModel{
+		ArticleID:   current.ID,
+		Version:     latest + 1,
+		Title:       current.Title,
+		Description: current.Description,
+		Body:        current.Body,
+	}).Error
+}
+
+// RevertArticle restores the title, description and body of an earlier revision and
+// records the revert as a new revision. Reverting to the current content changes nothing.
+func RevertArticle(articleID uint, version uint) error {
+	db := common.MustGetDB()
+	return db.Transaction(func(tx *gorm.DB) error {
+		var revision ArticleRevisionModel
+		err := tx.Where("article_id = ? AND version = ?", articleID, version).First(&revision).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrRevisionNotFound
+		}
+		if err != nil {
+			return err
+		}
+		var article ArticleModel
+		if err := tx.First(&article, articleID).Error; err != nil {
+			return err
+		}
+		if article.Title == revision.Title && article.Description == revision.Description && article.Body == revision.Body {
+			return nil
+		}
+		err = tx.Model(&article).Updates(map[string]interface{}{
+			"title":       revision.Title,
+			"description": revision.Description,
+			"body":        revision.Body,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return recordRevision(tx, article)
+	})
+}
+
+// ListRevisions returns the revisions of an article, oldest first.
+func ListRevisions(articleID uint) ([]ArticleRevisionModel, error) {
+	db := common.MustGetDB()
+	revisions := make([]ArticleRevisionModel, 0)
+	err := db.Where("article_id = ?", articleID).Order("version ASC").Find(&revisions).Error
+	return revisions, err
 }
 
 func DeleteArticleModel(condition interface{}) error {
-	db := common.GetDB()
+	db := common.MustGetDB()
 	err := db.Where(condition).Delete(&ArticleModel{}).Error
 	return err
 }
 
+// TombstoneComment blanks a comment and flags it deleted while keeping its row,
+// so threads still show where it was.
+func TombstoneComment(id uint) error {
+	db := common.MustGetDB()
+	return db.Model(&CommentModel{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"body": "", "deleted": true}).Error
+}
+
 func DeleteCommentModel(condition interface{}) error {
-	db := common.GetDB()
+	db := common.MustGetDB()
 	err := db.Where(condition).Delete(&CommentModel{}).Error
 	return err
 }
@@ -2,16 +2,36 @@ package articles
 
 import (
 	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/gothinkster/golang-gin-realworld-example-app/common"
 	"github.com/gothinkster/golang-gin-realworld-example-app/users"
 	"gorm.io/gorm"
 	"net/http"
+	"os"
 	"strconv"
+	"strings"
+	"time"
+)
+
+// Errors shared by the article handlers, rendered with common.RespondError.
+var (
+	errArticleNotFound     = common.NewAPIError(http.StatusNotFound, "articles", errors.New("Invalid slug"))
+	errInvalidParam        = common.NewAPIError(http.StatusNotFound, "articles", errors.New("Invalid param"))
+	errNotArticleAuthor    = common.NewAPIError(http.StatusForbidden, "article", errors.New("you are not the author"))
+	errCommentsUnavailable = common.NewAPIError(http.StatusNotFound, "comments", errors.New("Database error"))
+	errCommentsLocked      = common.NewAPIError(http.StatusForbidden, "comments", errors.New("locked"))
+	errTagNotFound         = common.NewAPIError(http.StatusNotFound, "tag", errors.New("not found"))
 )
 
 func ArticlesRegister(router *gin.RouterGroup) {
 	router.GET("/feed", ArticleFeed)
+	router.GET("/feed/count", ArticleFeedCount)
+	router.GET("/feed/latest-per-author", ArticleFeedLatestPerAuthor)
+	router.GET("/feed/unread", ArticleFeedUnread)
+	router.GET("/feed/stream", ArticleFeedStream)
+	router.GET("/network-favorites", ArticleNetworkFavorites)
+	router.GET("/for-you", ArticleForYou)
 	router.POST("", ArticleCreate)
 	router.POST("/", ArticleCreate)
 	router.PUT("/:slug", ArticleUpdate)
@@ -19,54 +39,202 @@ func ArticlesRegister(router *gin.RouterGroup) {
 	router.DELETE("/:slug", ArticleDelete)
 	router.POST("/:slug/favorite", ArticleFavorite)
 	router.DELETE("/:slug/favorite", ArticleUnfavorite)
+	router.POST("/:slug/lock", ArticleLock)
+	router.POST("/:slug/unlock", ArticleUnlock)
+	router.POST("/:slug/publish", ArticlePublish)
+	router.POST("/:slug/unpublish", ArticleUnpublish)
+	router.POST("/:slug/revert/:version", ArticleRevert)
+	router.POST("/:slug/read", ArticleRead)
+	router.POST("/read/all", ArticleReadAll)
 	router.POST("/:slug/comments", ArticleCommentCreate)
 	router.DELETE("/:slug/comments/:id", ArticleCommentDelete)
+	router.POST("/:slug/comments/:id/flag", ArticleCommentFlag)
 }
 
 func ArticlesAnonymousRegister(router *gin.RouterGroup) {
 	router.GET("", ArticleList)
 	router.GET("/", ArticleList)
+	router.GET("/archive", ArticleArchive)
+	router.GET("/top", ArticleTop)
+	router.GET("/latest", ArticleLatest)
+	router.GET("/id/:id", ArticleRetrieveByID)
 	router.GET("/:slug", ArticleRetrieve)
 	router.GET("/:slug/comments", ArticleCommentList)
+	router.GET("/:slug/favorites/timeline", ArticleFavoriteTimeline)
+	router.GET("/:slug/history", ArticleHistory)
+	router.GET("/:slug/permissions", ArticlePermissionsRetrieve)
+	router.GET("/:slug/counts", ArticleCounts)
+	router.GET("/:slug/favorites/delta", ArticleFavoritesDelta)
+	router.GET("/:slug/favorites/audit", ArticleFavoritesAudit)
+	router.POST("/favorite-status", ArticleFavoriteStatus)
+	router.POST("/commented-status", ArticleCommentedStatus)
+}
+
+// SchemaRegister adds the JSON Schemas of the article payloads under /schema.
+func SchemaRegister(router *gin.RouterGroup) {
+	router.GET("/article", ArticleSchema)
+}
+
+// UserArticlesRegister adds the article-related routes of the authenticated user under /user.
+func UserArticlesRegister(router *gin.RouterGroup) {
+	router.GET("/interests", UserInterests)
+	router.GET("/writing-stats", UserWritingStatsRetrieve)
+	router.GET("/drafts/summary", UserDraftSummaryRetrieve)
+	router.GET("/favorites-trend", UserFavoritesTrend)
+	router.GET("/fans", UserFans)
+	router.DELETE("/favorites", UserFavoritesClear)
+	router.GET("/comments", UserComments)
+	router.GET("/history", UserReadHistory)
+	router.GET("/subscriptions/tags", UserTagSubscriptions)
+	router.POST("/subscriptions/tags/:tag", UserTagSubscribe)
+	router.DELETE("/subscriptions/tags/:tag", UserTagUnsubscribe)
+	router.GET("/comments/flagged", UserFlaggedComments)
+	router.GET("/mentions", UserMentions)
+	router.DELETE("/articles", UserArticlesDeleteByTag)
+	router.GET("/articles/export.csv", UserArticlesCSV)
+	router.GET("/articles/favorite-counts", UserArticleFavoriteCounts)
+}
+
+// ProfileArticlesRegister adds the article-related profile routes under /profiles, next to users.ProfileRetrieveRegister.
+func ProfileArticlesRegister(router *gin.RouterGroup) {
+	users.ProfileArticles = profileWithArticles
+	router.GET("/:username/tag-breakdown", ProfileTagBreakdown)
+	router.GET("/:username/cadence", ProfileCadence)
+	router.POST("/article-counts", ProfileArticleCounts)
+}
+
+// AdminRegister adds the admin-only article routes, the group must be guarded by users.AdminMiddleware.
+func AdminRegister(router *gin.RouterGroup) {
+	router.GET("/backup", AdminBackup)
+	router.POST("/restore", AdminRestore)
+	router.POST("/tags", AdminCreateTags)
+	router.DELETE("/tags/unused", AdminDeleteUnusedTags)
+	router.GET("/articles", AdminArticleList)
+	router.POST("/articles/:slug/favorite-matrix", AdminFavoriteMatrix)
+	router.GET("/comments", AdminCommentList)
+	router.POST("/reslug", AdminReslug)
 }
 
 func TagsAnonymousRegister(router *gin.RouterGroup) {
 	router.GET("", TagList)
 	router.GET("/", TagList)
+	router.GET("/trending", TagTrending)
+	router.GET("/top-articles", TagTopArticles)
+	router.GET("/:tag/related", TagRelated)
+	router.POST("/for-articles", TagsForArticleIDs)
 }
 
 func ArticleCreate(c *gin.Context) {
 	articleModelValidator := NewArticleModelValidator()
-	if err := articleModelValidator.Bind(c); err != nil {
-		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+	if err := articleModelValidator.bind(c); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, articleBindError(err))
 		return
 	}
 	//fmt.Println(articleModelValidator.articleModel.Author.UserModel)
 
-	if err := SaveOne(&articleModelValidator.articleModel); err != nil {
-		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+	if err := CreateArticleWithTags(&articleModelValidator.articleModel, articleModelValidator.Article.Tags); err != nil {
+		if isTagsError(err) {
+			c.JSON(http.StatusUnprocessableEntity, articleBindError(err))
+			return
+		}
+		common.RespondError(c, err)
 		return
 	}
+	if !articleModelValidator.articleModel.Draft {
+		feedBroker.Publish(articleModelValidator.articleModel)
+	}
 	serializer := ArticleSerializer{c, articleModelValidator.articleModel}
 	c.JSON(http.StatusCreated, gin.H{"article": serializer.Response()})
 }
 
+// maxSlugsPerRequest bounds how many articles ?slugs= can ask for at once.
+const maxSlugsPerRequest = 50
+
+// maxFavoriteStatusIDs bounds how many articles POST /articles/favorite-status and
+// /articles/commented-status answer for at once, and how many users a favorite matrix covers.
+const maxFavoriteStatusIDs = 100
+
+// isTagsError reports whether err rejects the tags of an article rather than failing to save it.
+func isTagsError(err error) bool {
+	return errors.Is(err, ErrTooManyTags) || errors.Is(err, ErrTagsNotAllowed)
+}
+
+// articleBindError renders a failed ArticleModelValidator.Bind, which can fail on tags as well as on validation.
+func articleBindError(err error) common.CommonError {
+	if isTagsError(err) {
+		return common.NewError("tagList", err)
+	}
+	return common.NewValidatorError(err)
+}
+
 func ArticleList(c *gin.Context) {
+	if slugs := c.Query("slugs"); slugs != "" {
+		articleListBySlugs(c, slugs)
+		return
+	}
+	if cursor, ok := c.GetQuery("cursor"); ok {
+		articleListByCursor(c, cursor)
+		return
+	}
 	//condition := ArticleModel{}
 	tag := c.Query("tag")
 	author := c.Query("author")
 	favorited := c.Query("favorited")
 	limit := c.Query("limit")
 	offset := c.Query("offset")
-	articleModels, modelCount, err := FindManyArticle(tag, author, limit, offset, favorited)
+	filter := NewArticleListFilter(tag, author, limit, offset, favorited)
+	filter.Sort = c.Query("sort")
+	filter.ViewerID = c.MustGet("my_user_id").(uint)
+	filter.ExcludeOwn = c.Query("excludeOwn") == "true"
+	filter.MinFavorites, _ = strconv.Atoi(c.Query("minFavorites"))
+	filter.Search = strings.TrimSpace(c.Query("search"))
+	articleModels, modelCount, err := FindManyArticleWithFilter(filter)
 	if err != nil {
-		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid param")))
+		common.RespondError(c, errInvalidParam)
 		return
 	}
 	serializer := ArticlesSerializer{c, articleModels}
 	c.JSON(http.StatusOK, gin.H{"articles": serializer.Response(), "articlesCount": modelCount})
 }
 
+// articleListByCursor answers ?cursor=, an empty cursor asks for the first page.
+func articleListByCursor(c *gin.Context, cursor string) {
+	limit, _ := parseLimitOffset(c.Query("limit"), "")
+	if limit < 1 {
+		limit = 20
+	}
+	articleModels, nextCursor, err := FindArticlesByCursor(cursor, limit)
+	if errors.Is(err, ErrInvalidCursor) {
+		common.RespondError(c, common.NewAPIError(http.StatusUnprocessableEntity, "cursor", err))
+		return
+	}
+	if err != nil {
+		common.RespondError(c, errInvalidParam)
+		return
+	}
+	serializer := ArticlesSerializer{c, articleModels}
+	c.JSON(http.StatusOK, gin.H{"articles": serializer.Response(), "articlesCount": len(articleModels), "nextCursor": nextCursor})
+}
+
+func articleListBySlugs(c *gin.Context, slugs string) {
+	var slugList []string
+	for _, slug := range strings.Split(slugs, ",") {
+		if slug = strings.TrimSpace(slug); slug != "" {
+			slugList = append(slugList, slug)
+		}
+	}
+	if len(slugList) > maxSlugsPerRequest {
+		slugList = slugList[:maxSlugsPerRequest]
+	}
+	articleModels, err := FindArticlesBySlugs(slugList)
+	if err != nil {
+		common.RespondError(c, errInvalidParam)
+		return
+	}
+	serializer := ArticlesSerializer{c, articleModels}
+	c.JSON(http.StatusOK, gin.H{"articles": serializer.Response(), "articlesCount": len(articleModels)})
+}
+
 func ArticleFeed(c *gin.Context) {
 	limit := c.Query("limit")
 	offset := c.Query("offset")
@@ -75,10 +243,170 @@ func ArticleFeed(c *gin.Context) {
 		c.AbortWithError(http.StatusUnauthorized, errors.New("{error : \"Require auth!\"}"))
 		return
 	}
+	filter := FeedFilter{Tag: c.Query("tag")}
+	filter.Limit, filter.Offset = parseLimitOffset(limit, offset)
+	for param, bound := range map[string]*time.Time{"after": &filter.After, "before": &filter.Before} {
+		if value := c.Query(param); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				common.RespondError(c, common.NewAPIError(http.StatusUnprocessableEntity, param, errors.New("must be an RFC3339 timestamp")))
+				return
+			}
+			*bound = parsed
+		}
+	}
+	articleUserModel := GetArticleUserModel(myUserModel)
+	articleModels, modelCount, err := articleUserModel.GetArticleFeedWithFilter(filter)
+	if err != nil {
+		common.RespondError(c, errInvalidParam)
+		return
+	}
+	serializer := ArticlesSerializer{c, articleModels}
+	c.JSON(http.StatusOK, gin.H{"articles": serializer.Response(), "articlesCount": modelCount})
+}
+
+// feedBroker carries every newly published ArticleModel to the open feed streams.
+var feedBroker = common.NewBroker()
+
+// ArticleFeedStream pushes, as server-sent "article" events, the articles that authors the
+// viewer follows publish while the stream is open. It ends when the client goes away.
+func ArticleFeedStream(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	if myUserModel.ID == 0 {
+		c.AbortWithError(http.StatusUnauthorized, errors.New("{error : \"Require auth!\"}"))
+		return
+	}
+	events, unsubscribe := feedBroker.Subscribe()
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	done := c.Request.Context().Done()
+	for {
+		select {
+		case <-done:
+			return
+		case event := <-events:
+			articleModel, ok := event.(ArticleModel)
+			if !ok {
+				continue
+			}
+			authorID := articleModel.Author.UserModel.ID
+			if !users.BatchGetFollowingStatus(myUserModel.ID, []uint{authorID})[authorID] {
+				continue
+			}
+			serializer := ArticleSerializer{c, articleModel}
+			c.SSEvent("article", gin.H{"article": serializer.Response()})
+			c.Writer.Flush()
+		}
+	}
+}
+
+func ArticleFeedCount(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	if myUserModel.ID == 0 {
+		c.AbortWithError(http.StatusUnauthorized, errors.New("{error : \"Require auth!\"}"))
+		return
+	}
+	var filter FeedFilter
+	if value := c.Query("since"); value != "" {
+		since, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			common.RespondError(c, common.NewAPIError(http.StatusUnprocessableEntity, "since", errors.New("must be an RFC3339 timestamp")))
+			return
+		}
+		filter.After = since
+	}
 	articleUserModel := GetArticleUserModel(myUserModel)
-	articleModels, modelCount, err := articleUserModel.GetArticleFeed(limit, offset)
+	count, err := articleUserModel.CountArticleFeed(filter)
+	if err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"articlesCount": count})
+}
+
+func ArticleFeedLatestPerAuthor(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	if myUserModel.ID == 0 {
+		c.AbortWithError(http.StatusUnauthorized, errors.New("{error : \"Require auth!\"}"))
+		return
+	}
+	articleModels, err := LatestPerAuthor(myUserModel.ID)
+	if err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	serializer := ArticlesSerializer{c, articleModels}
+	c.JSON(http.StatusOK, gin.H{"articles": serializer.Response(), "articlesCount": len(articleModels)})
+}
+
+func ArticleFeedUnread(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	if myUserModel.ID == 0 {
+		c.AbortWithError(http.StatusUnauthorized, errors.New("{error : \"Require auth!\"}"))
+		return
+	}
+	limit, offset := parseLimitOffset(c.Query("limit"), c.Query("offset"))
+	articleModels, modelCount, err := FeedUnread(myUserModel.ID, limit, offset)
+	if err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	serializer := ArticlesSerializer{c, articleModels}
+	c.JSON(http.StatusOK, gin.H{"articles": serializer.Response(), "articlesCount": modelCount})
+}
+
+func ArticleNetworkFavorites(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	if myUserModel.ID == 0 {
+		c.AbortWithError(http.StatusUnauthorized, errors.New("{error : \"Require auth!\"}"))
+		return
+	}
+	limit, _ := parseLimitOffset(c.Query("limit"), "")
+	articleModels, err := NetworkFavorites(myUserModel.ID, limit)
+	if err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	serializer := ArticlesSerializer{c, articleModels}
+	c.JSON(http.StatusOK, gin.H{"articles": serializer.Response(), "articlesCount": len(articleModels)})
+}
+
+func ArticleForYou(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	if myUserModel.ID == 0 {
+		c.AbortWithError(http.StatusUnauthorized, errors.New("{error : \"Require auth!\"}"))
+		return
+	}
+	limit, _ := parseLimitOffset(c.Query("limit"), "")
+	articleModels, err := ForYou(myUserModel.ID, limit)
+	if err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	serializer := ArticlesSerializer{c, articleModels}
+	c.JSON(http.StatusOK, gin.H{"articles": serializer.Response(), "articlesCount": len(articleModels)})
+}
+
+func ArticleArchive(c *gin.Context) {
+	buckets, err := ArticleArchiveCounts()
+	if err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"archive": buckets})
+}
+
+func ArticleTop(c *gin.Context) {
+	limit, offset := parseLimitOffset(c.Query("limit"), c.Query("offset"))
+	articleModels, modelCount, err := TopArticles(limit, offset)
 	if err != nil {
-		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid param")))
+		common.RespondError(c, err)
 		return
 	}
 	serializer := ArticlesSerializer{c, articleModels}
@@ -87,39 +415,134 @@ func ArticleFeed(c *gin.Context) {
 
 func ArticleRetrieve(c *gin.Context) {
 	slug := c.Param("slug")
-	articleModel, err := FindOneArticle(&ArticleModel{Slug: slug})
+	articleModel, err := findOneArticle(common.TracingDB(c), &ArticleModel{Slug: slug})
+	if err != nil {
+		if newSlug, err := FindSlugRedirect(slug); err == nil {
+			location := strings.TrimSuffix(c.Request.URL.Path, slug) + newSlug
+			if c.Request.URL.RawQuery != "" {
+				location += "?" + c.Request.URL.RawQuery
+			}
+			c.Redirect(http.StatusMovedPermanently, location)
+			return
+		}
+		common.RespondError(c, errArticleNotFound)
+		return
+	}
+	if hiddenDraft(articleModel, c.MustGet("my_user_model").(users.UserModel)) {
+		common.RespondError(c, errArticleNotFound)
+		return
+	}
+	renderArticle(c, articleModel)
+}
+
+func ArticleLatest(c *gin.Context) {
+	articleModel, err := FindLatestArticle()
 	if err != nil {
-		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid slug")))
+		common.RespondError(c, common.NewAPIError(http.StatusNotFound, "articles", errors.New("No articles yet")))
+		return
+	}
+	renderArticle(c, articleModel)
+}
+
+func ArticleRetrieveByID(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	// A zero id would leave the condition struct empty and match any article
+	if err != nil || id < 1 {
+		common.RespondError(c, common.NewAPIError(http.StatusNotFound, "articles", errors.New("Invalid id")))
 		return
 	}
+	articleModel, err := FindOneArticle(&ArticleModel{Model: gorm.Model{ID: uint(id)}})
+	if err != nil || hiddenDraft(articleModel, c.MustGet("my_user_model").(users.UserModel)) {
+		common.RespondError(c, common.NewAPIError(http.StatusNotFound, "articles", errors.New("Invalid id")))
+		return
+	}
+	renderArticle(c, articleModel)
+}
+
+// hiddenDraft reports whether articleModel is a draft viewer may not see, only its author can.
+func hiddenDraft(articleModel ArticleModel, viewer users.UserModel) bool {
+	return articleModel.Draft && (viewer.ID == 0 || articleModel.Author.UserModelID != viewer.ID)
+}
+
+// findVisibleArticle loads the article of the :slug param, answering with notFound and
+// returning false when there is none or it is a draft the viewer may not see.
+func findVisibleArticle(c *gin.Context, notFound error) (ArticleModel, bool) {
+	articleModel, err := FindOneArticle(&ArticleModel{Slug: c.Param("slug")})
+	return visibleArticle(c, articleModel, err, notFound)
+}
+
+// findVisibleArticleID is findVisibleArticle loading only what the draft check needs, for
+// handlers that only use the id.
+func findVisibleArticleID(c *gin.Context) (ArticleModel, bool) {
+	db := common.MustGetDB()
+	var articleModel ArticleModel
+	err := db.Select("id", "draft", "author_id").Where(&ArticleModel{Slug: c.Param("slug")}).First(&articleModel).Error
+	// Only a draft needs its author for the check
+	if err == nil && articleModel.Draft {
+		err = db.First(&articleModel.Author, articleModel.AuthorID).Error
+	}
+	return visibleArticle(c, articleModel, err, errArticleNotFound)
+}
+
+func visibleArticle(c *gin.Context, articleModel ArticleModel, err error, notFound error) (ArticleModel, bool) {
+	if err != nil || hiddenDraft(articleModel, c.MustGet("my_user_model").(users.UserModel)) {
+		common.RespondError(c, notFound)
+		return articleModel, false
+	}
+	return articleModel, true
+}
+
+// renderArticle answers with a single article, embedding its comments for ?include=comments.
+func renderArticle(c *gin.Context, articleModel ArticleModel) {
 	serializer := ArticleSerializer{c, articleModel}
+	if parseInclude(c.Query("include"))["comments"] {
+		commentModels, _, err := GetCommentsPaged(articleModel.ID, -1, 0, "asc")
+		if err != nil {
+			common.RespondError(c, errCommentsUnavailable)
+			return
+		}
+		commentsSerializer := CommentsSerializer{c, commentModels}
+		c.JSON(http.StatusOK, gin.H{"article": ArticleWithCommentsResponse{
+			ArticleResponse: serializer.Response(),
+			Comments:        commentsSerializer.Response(),
+		}})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"article": serializer.Response()})
 }
 
+// parseInclude turns an ?include=a,b value into a set of the requested extras.
+func parseInclude(include string) map[string]bool {
+	includes := make(map[string]bool)
+	for _, item := range strings.Split(include, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			includes[item] = true
+		}
+	}
+	return includes
+}
+
 func ArticleUpdate(c *gin.Context) {
-	slug := c.Param("slug")
-	articleModel, err := FindOneArticle(&ArticleModel{Slug: slug})
-	if err != nil {
-		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid slug")))
+	articleModel, ok := findVisibleArticle(c, errArticleNotFound)
+	if !ok {
 		return
 	}
-	// Check if current user is the author
 	myUserModel := c.MustGet("my_user_model").(users.UserModel)
-	articleUserModel := GetArticleUserModel(myUserModel)
-	if articleModel.AuthorID != articleUserModel.ID {
-		c.JSON(http.StatusForbidden, common.NewError("article", errors.New("you are not the author")))
+	if !ArticlePermissions(articleModel, myUserModel).CanEdit {
+		common.RespondError(c, errNotArticleAuthor)
 		return
 	}
 
 	articleModelValidator := NewArticleModelValidatorFillWith(articleModel)
 	if err := articleModelValidator.Bind(c); err != nil {
-		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		c.JSON(http.StatusUnprocessableEntity, articleBindError(err))
 		return
 	}
 
 	articleModelValidator.articleModel.ID = articleModel.ID
-	if err := articleModel.Update(articleModelValidator.articleModel); err != nil {
-		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+	articleModelValidator.articleModel.Draft = articleModel.Draft
+	if err := articleModel.Edit(articleModelValidator.articleModel); err != nil {
+		common.RespondError(c, err)
 		return
 	}
 	serializer := ArticleSerializer{c, articleModel}
@@ -132,120 +555,860 @@ func ArticleDelete(c *gin.Context) {
 	if err == nil {
 		// Article exists, check authorization
 		myUserModel := c.MustGet("my_user_model").(users.UserModel)
-		articleUserModel := GetArticleUserModel(myUserModel)
-		if articleModel.AuthorID != articleUserModel.ID {
-			c.JSON(http.StatusForbidden, common.NewError("article", errors.New("you are not the author")))
+		if !ArticlePermissions(articleModel, myUserModel).CanDelete {
+			common.RespondError(c, errNotArticleAuthor)
 			return
 		}
 	}
 	// Delete regardless of existence (idempotent)
 	if err := DeleteArticleModel(&ArticleModel{Slug: slug}); err != nil {
-		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		common.RespondError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"article": "delete success"})
 }
 
 func ArticleFavorite(c *gin.Context) {
-	slug := c.Param("slug")
-	articleModel, err := FindOneArticle(&ArticleModel{Slug: slug})
-	if err != nil {
-		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid slug")))
+	articleModel, ok := findVisibleArticle(c, errArticleNotFound)
+	if !ok {
 		return
 	}
 	myUserModel := c.MustGet("my_user_model").(users.UserModel)
-	if err = articleModel.favoriteBy(GetArticleUserModel(myUserModel)); err != nil {
-		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+	articleUserModel := GetArticleUserModel(myUserModel)
+	if !common.GetEnvBool("ALLOW_SELF_FAVORITE", true) && articleModel.AuthorID == articleUserModel.ID {
+		common.RespondError(c, common.NewAPIError(http.StatusUnprocessableEntity, "favorite", errors.New("cannot favorite your own article")))
+		return
+	}
+	created, err := articleModel.favoriteByCreated(articleUserModel, c.Query("private") == "true")
+	if err != nil {
+		common.RespondError(c, err)
 		return
 	}
+	// A repeat favorite leaves the favorited state and the count as the client has them
+	if !created {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	// The viewer has just favorited the article, so only the count needs a query.
 	serializer := ArticleSerializer{c, articleModel}
-	c.JSON(http.StatusOK, gin.H{"article": serializer.Response()})
+	c.JSON(http.StatusOK, gin.H{"article": serializer.ResponseWithPreloaded(true, articleModel.favoritesCount())})
 }
 
 func ArticleUnfavorite(c *gin.Context) {
-	slug := c.Param("slug")
-	articleModel, err := FindOneArticle(&ArticleModel{Slug: slug})
-	if err != nil {
-		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid slug")))
+	articleModel, ok := findVisibleArticle(c, errArticleNotFound)
+	if !ok {
 		return
 	}
 	myUserModel := c.MustGet("my_user_model").(users.UserModel)
-	if err = articleModel.unFavoriteBy(GetArticleUserModel(myUserModel)); err != nil {
-		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+	if err := articleModel.unFavoriteBy(GetArticleUserModel(myUserModel)); err != nil {
+		common.RespondError(c, err)
 		return
 	}
 	serializer := ArticleSerializer{c, articleModel}
 	c.JSON(http.StatusOK, gin.H{"article": serializer.Response()})
 }
 
-func ArticleCommentCreate(c *gin.Context) {
-	slug := c.Param("slug")
-	articleModel, err := FindOneArticle(&ArticleModel{Slug: slug})
+func ArticleFavoriteTimeline(c *gin.Context) {
+	bucket := c.DefaultQuery("bucket", "day")
+	if bucket != "day" && bucket != "week" {
+		common.RespondError(c, common.NewAPIError(http.StatusUnprocessableEntity, "bucket", errors.New("must be day or week")))
+		return
+	}
+	articleModel, ok := findVisibleArticle(c, errArticleNotFound)
+	if !ok {
+		return
+	}
+	timeline, err := FavoriteTimeline(articleModel.ID, bucket)
 	if err != nil {
-		c.JSON(http.StatusNotFound, common.NewError("comment", errors.New("Invalid slug")))
+		common.RespondError(c, err)
 		return
 	}
-	commentModelValidator := NewCommentModelValidator()
-	if err := commentModelValidator.Bind(c); err != nil {
+	c.JSON(http.StatusOK, gin.H{"timeline": timeline})
+}
+
+func ArticleHistory(c *gin.Context) {
+	articleModel, ok := findVisibleArticle(c, errArticleNotFound)
+	if !ok {
+		return
+	}
+	revisions, err := ListRevisions(articleModel.ID)
+	if err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	serializer := RevisionsSerializer{c, revisions}
+	c.JSON(http.StatusOK, gin.H{"history": serializer.Response()})
+}
+
+func ArticleFavoriteStatus(c *gin.Context) {
+	validator := NewArticleIDsValidator()
+	if err := validator.Bind(c); err != nil {
 		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
 		return
 	}
-	commentModelValidator.commentModel.Article = articleModel
+	ids := validator.IDs
+	if len(ids) > maxFavoriteStatusIDs {
+		ids = ids[:maxFavoriteStatusIDs]
+	}
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	favorited := BatchGetFavoriteStatus(ids, GetArticleUserModel(myUserModel).ID)
+	statuses := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		statuses[id] = favorited[id]
+	}
+	c.JSON(http.StatusOK, gin.H{"statuses": statuses})
+}
 
-	if err := SaveOne(&commentModelValidator.commentModel); err != nil {
-		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+func ArticleCommentedStatus(c *gin.Context) {
+	validator := NewArticleIDsValidator()
+	if err := validator.Bind(c); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
 		return
 	}
-	serializer := CommentSerializer{c, commentModelValidator.commentModel}
-	c.JSON(http.StatusCreated, gin.H{"comment": serializer.Response()})
+	ids := validator.IDs
+	if len(ids) > maxFavoriteStatusIDs {
+		ids = ids[:maxFavoriteStatusIDs]
+	}
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	commented := BatchGetCommentedStatus(ids, GetArticleUserModel(myUserModel).ID)
+	statuses := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		statuses[id] = commented[id]
+	}
+	c.JSON(http.StatusOK, gin.H{"statuses": statuses})
 }
 
-func ArticleCommentDelete(c *gin.Context) {
-	id64, err := strconv.ParseUint(c.Param("id"), 10, 32)
-	if err != nil {
-		c.JSON(http.StatusNotFound, common.NewError("comment", errors.New("Invalid id")))
+func ArticleCounts(c *gin.Context) {
+	// Only the id is needed, so skip the preloads of FindOneArticle
+	articleModel, ok := findVisibleArticleID(c)
+	if !ok {
 		return
 	}
-	id := uint(id64)
-	commentModel, err := FindOneComment(&CommentModel{Model: gorm.Model{ID: id}})
-	if err == nil {
-		// Comment exists, check authorization
-		myUserModel := c.MustGet("my_user_model").(users.UserModel)
-		articleUserModel := GetArticleUserModel(myUserModel)
-		if commentModel.AuthorID != articleUserModel.ID {
-			c.JSON(http.StatusForbidden, common.NewError("comment", errors.New("you are not the author")))
+	c.JSON(http.StatusOK, gin.H{
+		"favoritesCount": articleModel.favoritesCount(),
+		"commentsCount":  articleModel.commentsCount(),
+	})
+}
+
+// ArticleFavoritesDelta lets a polling client holding a favorites count of ?since= learn how
+// it changed, the delta is negative when favorites were removed.
+func ArticleFavoritesDelta(c *gin.Context) {
+	since := 0
+	if value := c.Query("since"); value != "" {
+		var err error
+		if since, err = strconv.Atoi(value); err != nil || since < 0 {
+			common.RespondError(c, common.NewAPIError(http.StatusUnprocessableEntity, "since", errors.New("must be a non-negative number")))
 			return
 		}
 	}
-	// Delete regardless of existence (idempotent)
-	if err := DeleteCommentModel([]uint{id}); err != nil {
-		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+	articleModel, ok := findVisibleArticleID(c)
+	if !ok {
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"comment": "delete success"})
+	current := int(articleModel.favoritesCount())
+	c.JSON(http.StatusOK, gin.H{"current": current, "delta": current - since})
 }
 
-func ArticleCommentList(c *gin.Context) {
-	slug := c.Param("slug")
-	articleModel, err := FindOneArticle(&ArticleModel{Slug: slug})
-	if err != nil {
-		c.JSON(http.StatusNotFound, common.NewError("comments", errors.New("Invalid slug")))
+func ArticleFavoritesAudit(c *gin.Context) {
+	articleModel, ok := findVisibleArticleID(c)
+	if !ok {
 		return
 	}
-	err = articleModel.getComments()
-	if err != nil {
-		c.JSON(http.StatusNotFound, common.NewError("comments", errors.New("Database error")))
+	rows, distinct := AuditFavorites(articleModel.ID)
+	c.JSON(http.StatusOK, gin.H{"rows": rows, "distinctUsers": distinct})
+}
+
+func ArticlePermissionsRetrieve(c *gin.Context) {
+	articleModel, ok := findVisibleArticle(c, errArticleNotFound)
+	if !ok {
 		return
 	}
-	serializer := CommentsSerializer{c, articleModel.Comments}
-	c.JSON(http.StatusOK, gin.H{"comments": serializer.Response()})
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	c.JSON(http.StatusOK, ArticlePermissions(articleModel, myUserModel))
 }
-func TagList(c *gin.Context) {
-	tagModels, err := getAllTags()
+
+func ArticleRevert(c *gin.Context) {
+	articleModel, ok := findVisibleArticle(c, errArticleNotFound)
+	if !ok {
+		return
+	}
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	articleUserModel := GetArticleUserModel(myUserModel)
+	if articleModel.AuthorID != articleUserModel.ID {
+		common.RespondError(c, errNotArticleAuthor)
+		return
+	}
+	version, err := strconv.ParseUint(c.Param("version"), 10, 32)
 	if err != nil {
-		c.JSON(http.StatusNotFound, common.NewError("articles", errors.New("Invalid param")))
+		common.RespondError(c, common.NewAPIError(http.StatusNotFound, "version", ErrRevisionNotFound))
 		return
 	}
-	serializer := TagsSerializer{c, tagModels}
-	c.JSON(http.StatusOK, gin.H{"tags": serializer.Response()})
+	if err := RevertArticle(articleModel.ID, uint(version)); err != nil {
+		if errors.Is(err, ErrRevisionNotFound) {
+			common.RespondError(c, common.NewAPIError(http.StatusNotFound, "version", err))
+			return
+		}
+		common.RespondError(c, err)
+		return
+	}
+	articleModel, err = FindOneArticle(&ArticleModel{Model: gorm.Model{ID: articleModel.ID}})
+	if err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	serializer := ArticleSerializer{c, articleModel}
+	c.JSON(http.StatusOK, gin.H{"article": serializer.Response()})
+}
+
+func ArticleRead(c *gin.Context) {
+	articleModel, ok := findVisibleArticle(c, errArticleNotFound)
+	if !ok {
+		return
+	}
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	if err := articleModel.markReadBy(myUserModel.ID); err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	serializer := ArticleSerializer{c, articleModel}
+	c.JSON(http.StatusOK, gin.H{"article": serializer.Response()})
+}
+
+func ArticleReadAll(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	marked, err := MarkFeedRead(myUserModel.ID)
+	if err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"marked": marked})
+}
+
+func ArticleLock(c *gin.Context) {
+	setArticleCommentsLocked(c, true)
+}
+
+func ArticleUnlock(c *gin.Context) {
+	setArticleCommentsLocked(c, false)
+}
+
+// setArticleCommentsLocked opens or closes the comment thread of an article, author only.
+func setArticleCommentsLocked(c *gin.Context, locked bool) {
+	articleModel, ok := findVisibleArticle(c, errArticleNotFound)
+	if !ok {
+		return
+	}
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	articleUserModel := GetArticleUserModel(myUserModel)
+	if articleModel.AuthorID != articleUserModel.ID {
+		common.RespondError(c, errNotArticleAuthor)
+		return
+	}
+	if err := articleModel.setCommentsLocked(locked); err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	serializer := ArticleSerializer{c, articleModel}
+	c.JSON(http.StatusOK, gin.H{"article": serializer.Response()})
+}
+
+func ArticlePublish(c *gin.Context) {
+	setArticlePublished(c, true)
+}
+
+func ArticleUnpublish(c *gin.Context) {
+	setArticlePublished(c, false)
+}
+
+// setArticlePublished publishes an article or reverts it to a draft, author only. Publishing
+// a draft announces it on the feed streams like a newly created article.
+func setArticlePublished(c *gin.Context, published bool) {
+	articleModel, ok := findVisibleArticle(c, errArticleNotFound)
+	if !ok {
+		return
+	}
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	articleUserModel := GetArticleUserModel(myUserModel)
+	if articleModel.AuthorID != articleUserModel.ID {
+		common.RespondError(c, errNotArticleAuthor)
+		return
+	}
+	if err := SetPublished(articleModel.ID, published); err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	wasDraft := articleModel.Draft
+	articleModel.Draft = !published
+	if published && wasDraft {
+		feedBroker.Publish(articleModel)
+	}
+	serializer := ArticleSerializer{c, articleModel}
+	c.JSON(http.StatusOK, gin.H{"article": serializer.Response()})
+}
+
+func ArticleCommentCreate(c *gin.Context) {
+	articleModel, ok := findVisibleArticle(c, common.NewAPIError(http.StatusNotFound, "comment", errors.New("Invalid slug")))
+	if !ok {
+		return
+	}
+	if articleModel.CommentsLocked {
+		common.RespondError(c, errCommentsLocked)
+		return
+	}
+	commentModelValidator := NewCommentModelValidator()
+	if err := commentModelValidator.Bind(c); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		return
+	}
+	commentModelValidator.commentModel.Article = articleModel
+
+	if err := SaveOne(&commentModelValidator.commentModel); err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	serializer := CommentSerializer{c, commentModelValidator.commentModel}
+	c.JSON(http.StatusCreated, gin.H{"comment": serializer.Response()})
+}
+
+func ArticleCommentDelete(c *gin.Context) {
+	id64, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		common.RespondError(c, common.NewAPIError(http.StatusNotFound, "comment", errors.New("Invalid id")))
+		return
+	}
+	id := uint(id64)
+	commentModel, err := FindOneComment(&CommentModel{Model: gorm.Model{ID: id}})
+	if err == nil {
+		// Comment exists, check authorization
+		myUserModel := c.MustGet("my_user_model").(users.UserModel)
+		articleUserModel := GetArticleUserModel(myUserModel)
+		if commentModel.AuthorID != articleUserModel.ID {
+			common.RespondError(c, common.NewAPIError(http.StatusForbidden, "comment", errors.New("you are not the author")))
+			return
+		}
+	}
+	// With ?tombstone=true the comment stays in the thread as a deleted placeholder
+	if c.Query("tombstone") == "true" {
+		if err := TombstoneComment(id); err != nil {
+			common.RespondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"comment": "delete success"})
+		return
+	}
+	// Delete regardless of existence (idempotent)
+	if err := DeleteCommentModel([]uint{id}); err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"comment": "delete success"})
+}
+
+func ArticleCommentFlag(c *gin.Context) {
+	id64, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		common.RespondError(c, common.NewAPIError(http.StatusNotFound, "comment", errors.New("Invalid id")))
+		return
+	}
+	commentModel, err := FindOneComment(&CommentModel{Model: gorm.Model{ID: uint(id64)}})
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	if err != nil || commentModel.Article.Slug != c.Param("slug") || hiddenDraft(commentModel.Article, myUserModel) {
+		common.RespondError(c, common.NewAPIError(http.StatusNotFound, "comment", errors.New("Invalid id")))
+		return
+	}
+	validator := NewCommentFlagValidator()
+	if err := validator.Bind(c); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		return
+	}
+	if err := FlagComment(commentModel.ID, myUserModel.ID, validator.Flag.Reason); err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"comment": "flagged"})
+}
+
+func ArticleCommentList(c *gin.Context) {
+	articleModel, ok := findVisibleArticle(c, common.NewAPIError(http.StatusNotFound, "comments", errors.New("Invalid slug")))
+	if !ok {
+		return
+	}
+	order := c.DefaultQuery("order", "asc")
+	if order != "asc" && order != "desc" {
+		common.RespondError(c, common.NewAPIError(http.StatusUnprocessableEntity, "order", errors.New("must be asc or desc")))
+		return
+	}
+	// Without an explicit limit the whole thread is returned
+	limit, offset := parseLimitOffset(c.DefaultQuery("limit", "-1"), c.Query("offset"))
+	commentModels, commentsCount, err := GetCommentsPaged(articleModel.ID, limit, offset, order)
+	if err != nil {
+		common.RespondError(c, errCommentsUnavailable)
+		return
+	}
+	serializer := CommentsSerializer{c, commentModels}
+	c.JSON(http.StatusOK, gin.H{"comments": serializer.Response(), "commentsCount": commentsCount})
+}
+func TagList(c *gin.Context) {
+	tagModels, err := getAllTags()
+	if err != nil {
+		common.RespondError(c, errInvalidParam)
+		return
+	}
+	if ttl := tagsCacheTTL(); ttl > 0 {
+		c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(ttl.Seconds())))
+	}
+	serializer := TagsSerializer{c, tagModels}
+	c.JSON(http.StatusOK, gin.H{"tags": serializer.Response()})
+}
+
+// profileWithArticles embeds the newest articles of userModel in its profile, five unless ?limit says otherwise.
+func profileWithArticles(c *gin.Context, userModel users.UserModel) (interface{}, error) {
+	limit, _ := parseLimitOffset(c.DefaultQuery("limit", "5"), "")
+	articleModels, _, err := FindManyArticleWithFilter(ArticleListFilter{Author: userModel.Username, Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+	profileSerializer := users.ProfileSerializer{C: c, UserModel: userModel}
+	articlesSerializer := ArticlesSerializer{c, articleModels}
+	return ProfileWithArticlesResponse{
+		ProfileResponse: profileSerializer.Response(),
+		Articles:        articlesSerializer.Response(),
+	}, nil
+}
+
+func ProfileArticleCounts(c *gin.Context) {
+	validator := users.NewUsernamesValidator()
+	if err := validator.Bind(c); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		return
+	}
+	c.JSON(http.StatusOK, BatchCountArticlesByAuthor(validator.Usernames))
+}
+
+func ProfileTagBreakdown(c *gin.Context) {
+	username := c.Param("username")
+	userModel, err := users.FindOneUser(&users.UserModel{Username: username})
+	if err != nil {
+		common.RespondError(c, common.NewAPIError(http.StatusNotFound, "profile", errors.New("Invalid username")))
+		return
+	}
+	breakdown, err := AuthorTagBreakdown(userModel.ID)
+	if err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tags": breakdown})
+}
+
+func ProfileCadence(c *gin.Context) {
+	username := c.Param("username")
+	userModel, err := users.FindOneUser(&users.UserModel{Username: username})
+	if err != nil {
+		common.RespondError(c, common.NewAPIError(http.StatusNotFound, "profile", errors.New("Invalid username")))
+		return
+	}
+	cadence, err := PublishingCadence(userModel.ID)
+	if err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"cadence": cadence})
+}
+
+func TagTrending(c *gin.Context) {
+	days, ok := queryDays(c)
+	if !ok {
+		return
+	}
+	limit, _ := parseLimitOffset(c.DefaultQuery("limit", "10"), "")
+	trending, err := TrendingTags(days, limit)
+	if err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tags": trending})
+}
+
+// maxTopArticlesPerTag bounds ?limit= of GET /tags/top-articles, every tag gets that many articles.
+const maxTopArticlesPerTag = 10
+
+func TagTopArticles(c *gin.Context) {
+	limit, _ := parseLimitOffset(c.DefaultQuery("limit", "1"), "")
+	limit = min(max(limit, 1), maxTopArticlesPerTag)
+	top, err := TopArticlesPerTag(limit)
+	if err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	response := make(map[string][]ArticleResponse, len(top))
+	for tag, articleModels := range top {
+		serializer := ArticlesSerializer{c, articleModels}
+		response[tag] = serializer.Response()
+	}
+	c.JSON(http.StatusOK, gin.H{"tags": response})
+}
+
+func TagRelated(c *gin.Context) {
+	limit, _ := parseLimitOffset(c.Query("limit"), "")
+	related, err := RelatedTags(c.Param("tag"), limit)
+	if err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tags": related})
+}
+
+func TagsForArticleIDs(c *gin.Context) {
+	validator := NewArticleIDsValidator()
+	if err := validator.Bind(c); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		return
+	}
+	tags, err := TagsForArticles(validator.IDs)
+	if err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tags": tags})
+}
+
+func UserInterests(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	limit, _ := parseLimitOffset(c.Query("limit"), "")
+	interests, err := FavoriteTagsRanked(myUserModel.ID, limit)
+	if err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"interests": interests})
+}
+
+func UserWritingStatsRetrieve(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	stats, err := WritingStats(myUserModel.ID)
+	if err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"writingStats": stats})
+}
+
+func UserDraftSummaryRetrieve(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	summary, err := DraftSummary(myUserModel.ID)
+	if err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	serializer := DraftSummarySerializer{c, summary}
+	c.JSON(http.StatusOK, gin.H{"drafts": serializer.Response()})
+}
+
+// queryDays reads the ?days= window, 7 when absent, and answers 422 itself when it is not a positive number.
+func queryDays(c *gin.Context) (int, bool) {
+	value := c.Query("days")
+	if value == "" {
+		return 7, true
+	}
+	days, err := strconv.Atoi(value)
+	if err != nil || days < 1 {
+		common.RespondError(c, common.NewAPIError(http.StatusUnprocessableEntity, "days", errors.New("must be a positive number")))
+		return 0, false
+	}
+	return days, true
+}
+
+func UserFavoritesTrend(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	days, ok := queryDays(c)
+	if !ok {
+		return
+	}
+	trend, err := FavoritesTrend(myUserModel.ID, days)
+	if err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"favoritesTrend": trend})
+}
+
+func UserFans(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	limit, _ := parseLimitOffset(c.Query("limit"), "")
+	fans, err := AuthorFans(myUserModel.ID, limit)
+	if err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"fans": fans})
+}
+
+func UserFavoritesClear(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	removed, err := ClearFavorites(myUserModel.ID)
+	if err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"removed": removed})
+}
+
+func UserArticlesDeleteByTag(c *gin.Context) {
+	tag := strings.TrimSpace(c.Query("tag"))
+	if tag == "" {
+		common.RespondError(c, common.NewAPIError(http.StatusUnprocessableEntity, "tag", errors.New("can't be blank")))
+		return
+	}
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	deleted, err := DeleteAuthorArticlesByTag(myUserModel.ID, tag)
+	if err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deleted": deleted})
+}
+
+func UserReadHistory(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	limit, offset := parseLimitOffset(c.Query("limit"), c.Query("offset"))
+	articleModels, modelCount, err := ReadHistory(myUserModel.ID, limit, offset)
+	if err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	serializer := ArticlesSerializer{c, articleModels}
+	c.JSON(http.StatusOK, gin.H{"articles": serializer.Response(), "articlesCount": modelCount})
+}
+
+func UserTagSubscriptions(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	tags, err := ListTagSubscriptions(myUserModel.ID)
+	if err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tags": tags})
+}
+
+func UserTagSubscribe(c *gin.Context) {
+	tagModel, err := FindOneTag(c.Param("tag"))
+	if err != nil {
+		common.RespondError(c, errTagNotFound)
+		return
+	}
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	if err = SubscribeTag(myUserModel.ID, tagModel); err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tag": tagModel.Tag, "subscribed": true})
+}
+
+func UserTagUnsubscribe(c *gin.Context) {
+	tagModel, err := FindOneTag(c.Param("tag"))
+	if err != nil {
+		common.RespondError(c, errTagNotFound)
+		return
+	}
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	if err = UnsubscribeTag(myUserModel.ID, tagModel); err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tag": tagModel.Tag, "subscribed": false})
+}
+
+func UserComments(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	limit, offset := parseLimitOffset(c.Query("limit"), c.Query("offset"))
+	commentModels, commentsCount, err := FindCommentsByAuthor(myUserModel.ID, limit, offset)
+	if err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	serializer := CommentsSerializer{c, commentModels}
+	c.JSON(http.StatusOK, gin.H{"comments": serializer.UserCommentsResponse(), "commentsCount": commentsCount})
+}
+
+func UserFlaggedComments(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	commentModels, err := FindFlaggedCommentsForAuthor(myUserModel.ID)
+	if err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	serializer := CommentsSerializer{c, commentModels}
+	c.JSON(http.StatusOK, gin.H{"comments": serializer.FlaggedResponse(), "commentsCount": len(commentModels)})
+}
+
+func UserMentions(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	limit, offset := parseLimitOffset(c.Query("limit"), c.Query("offset"))
+	articleModels, modelCount, err := FindMentions(myUserModel.Username, limit, offset)
+	if err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	serializer := ArticlesSerializer{c, articleModels}
+	c.JSON(http.StatusOK, gin.H{"articles": serializer.Response(), "articlesCount": modelCount})
+}
+
+func UserArticleFavoriteCounts(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	c.JSON(http.StatusOK, AuthorArticleFavoriteCounts(myUserModel.ID))
+}
+
+func UserArticlesCSV(c *gin.Context) {
+	myUserModel := c.MustGet("my_user_model").(users.UserModel)
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", `attachment; filename="articles.csv"`)
+	c.Status(http.StatusOK)
+	// Headers are already sent while streaming, so a failure can only be recorded
+	if err := StreamArticlesCSV(c.Writer, myUserModel.ID); err != nil {
+		c.Error(err)
+	}
+}
+
+// Sitemap serves /sitemap.xml, linking under SITE_BASE_URL. The Host header is client
+// controlled, so without SITE_BASE_URL it answers 503 rather than link to it.
+func Sitemap(c *gin.Context) {
+	baseURL := strings.TrimRight(os.Getenv("SITE_BASE_URL"), "/")
+	if baseURL == "" {
+		common.RespondError(c, common.NewAPIError(http.StatusServiceUnavailable, "sitemap", errors.New("SITE_BASE_URL is not set")))
+		return
+	}
+	c.Header("Content-Type", "application/xml; charset=utf-8")
+	c.Status(http.StatusOK)
+	// Headers are already sent while streaming, so a failure can only be recorded
+	if err := StreamSitemap(c.Writer, baseURL); err != nil {
+		c.Error(err)
+	}
+}
+
+func ArticleSchema(c *gin.Context) {
+	schema := common.JSONSchemaForStruct(ArticleModelValidator{})
+	// required_description is a custom rule, describe what it currently enforces
+	if common.GetEnvBool("REQUIRE_DESCRIPTION", true) {
+		article := schema["properties"].(map[string]interface{})["article"].(map[string]interface{})
+		article["required"] = append(article["required"].([]string), "description")
+	}
+	c.JSON(http.StatusOK, schema)
+}
+
+func AdminBackup(c *gin.Context) {
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", `attachment; filename="backup.zip"`)
+	c.Status(http.StatusOK)
+	// Headers are already sent while streaming, so a failure can only be recorded
+	if err := StreamBackup(c.Writer); err != nil {
+		c.Error(err)
+	}
+}
+
+// restoreMaxBytes caps the archive AdminRestore accepts, RestoreBackup holds it all in memory.
+func restoreMaxBytes() int64 {
+	return int64(common.GetEnvInt("RESTORE_MAX_BYTES", 100<<20))
+}
+
+func AdminRestore(c *gin.Context) {
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, restoreMaxBytes())
+	if err := RestoreBackup(body); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			common.RespondError(c, common.NewAPIError(http.StatusRequestEntityTooLarge, "backup",
+				fmt.Errorf("backup archive exceeds %d bytes", tooLarge.Limit)))
+			return
+		}
+		common.RespondError(c, common.NewAPIError(http.StatusUnprocessableEntity, "backup", err))
+		return
+	}
+	allTagsCache.invalidate()
+	c.JSON(http.StatusOK, gin.H{"backup": "restore success"})
+}
+
+func AdminCreateTags(c *gin.Context) {
+	validator := NewTagsValidator()
+	if err := validator.Bind(c); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		return
+	}
+	created, err := CreateTags(validator.Tags)
+	if err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	isCreated := make(map[string]bool, len(created))
+	for _, tag := range created {
+		isCreated[tag] = true
+	}
+	existing := make([]string, 0)
+	for _, tag := range normalizeTags(validator.Tags) {
+		if !isCreated[tag] {
+			existing = append(existing, tag)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"created": created, "existing": existing})
+}
+
+func AdminDeleteUnusedTags(c *gin.Context) {
+	deleted, err := DeleteUnusedTags()
+	if err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deleted": deleted})
+}
+
+func AdminArticleList(c *gin.Context) {
+	limit, offset := parseLimitOffset(c.Query("limit"), c.Query("offset"))
+	includeDeleted := c.Query("includeDeleted") == "true"
+	articleModels, modelCount, err := FindArticlesForAudit(includeDeleted, limit, offset)
+	if err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	serializer := ArticlesSerializer{c, articleModels}
+	c.JSON(http.StatusOK, gin.H{"articles": serializer.AuditResponse(), "articlesCount": modelCount})
+}
+
+func AdminFavoriteMatrix(c *gin.Context) {
+	validator := NewUserIDsValidator()
+	if err := validator.Bind(c); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		return
+	}
+	var articleModel ArticleModel
+	err := common.MustGetDB().Select("id").Where(&ArticleModel{Slug: c.Param("slug")}).First(&articleModel).Error
+	if err != nil {
+		common.RespondError(c, errArticleNotFound)
+		return
+	}
+	userIDs := validator.UserIDs
+	if len(userIDs) > maxFavoriteStatusIDs {
+		userIDs = userIDs[:maxFavoriteStatusIDs]
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"favorited":      FavoriteMatrix(articleModel.ID, userIDs),
+		"favoritesCount": articleModel.favoritesCount(),
+	})
+}
+
+func AdminCommentList(c *gin.Context) {
+	limit, offset := parseLimitOffset(c.Query("limit"), c.Query("offset"))
+	commentModels, commentsCount, err := FindAllComments(limit, offset)
+	if err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	serializer := CommentsSerializer{c, commentModels}
+	c.JSON(http.StatusOK, gin.H{"comments": serializer.UserCommentsResponse(), "commentsCount": commentsCount})
+}
+
+func AdminReslug(c *gin.Context) {
+	changed, err := ReslugAll()
+	if err != nil {
+		common.RespondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"changed": changed})
 }
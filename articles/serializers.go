@@ -57,6 +57,17 @@ type ArticleResponse struct {
 	Tags           []string              `json:"tagList"`
 	Favorite       bool                  `json:"favorited"`
 	FavoritesCount uint                  `json:"favoritesCount"`
+	CommentsCount  uint                  `json:"commentsCount"`
+	IsAuthor       bool                  `json:"isAuthor"`
+	Draft          bool                  `json:"draft,omitempty"`
+	// Read is only reported to authenticated viewers
+	Read *bool `json:"read,omitempty"`
+}
+
+// ArticleWithCommentsResponse is an article with its comment thread embedded, for ?include=comments.
+type ArticleWithCommentsResponse struct {
+	ArticleResponse
+	Comments []CommentResponse `json:"comments"`
 }
 
 type ArticlesSerializer struct {
@@ -79,6 +90,10 @@ func (s *ArticleSerializer) Response() ArticleResponse {
 		Author:         authorSerializer.Response(),
 		Favorite:       s.isFavoriteBy(GetArticleUserModel(myUserModel)),
 		FavoritesCount: s.favoritesCount(),
+		CommentsCount:  s.commentsCount(),
+		IsAuthor:       s.isAuthoredBy(myUserModel),
+		Read:           readFlag(myUserModel, s.isReadBy(myUserModel.ID)),
+		Draft:          s.Draft,
 	}
 	response.Tags = make([]string, 0)
 	for _, tag := range s.Tags {
@@ -89,9 +104,31 @@ func (s *ArticleSerializer) Response() ArticleResponse {
 	return response
 }
 
+// isAuthoredBy reports whether the viewer wrote the article; anonymous viewers never did.
+func (s *ArticleSerializer) isAuthoredBy(viewer users.UserModel) bool {
+	return viewer.ID != 0 && s.Author.UserModelID == viewer.ID
+}
+
+// readFlag is the read state reported to the viewer, nil for anonymous viewers.
+func readFlag(viewer users.UserModel, read bool) *bool {
+	if viewer.ID == 0 {
+		return nil
+	}
+	return &read
+}
+
 // ResponseWithPreloaded creates response using preloaded favorite data to avoid N+1 queries
 func (s *ArticleSerializer) ResponseWithPreloaded(favorited bool, favoritesCount uint) ArticleResponse {
 	authorSerializer := ArticleUserSerializer{C: s.C, ArticleUserModel: s.Author}
+	response := s.responseWithAuthor(favorited, favoritesCount, authorSerializer.Response())
+	myUserModel := s.C.MustGet("my_user_model").(users.UserModel)
+	response.Read = readFlag(myUserModel, s.isReadBy(myUserModel.ID))
+	response.CommentsCount = s.commentsCount()
+	return response
+}
+
+// responseWithAuthor creates response from preloaded favorite data and an already serialized author.
+func (s *ArticleSerializer) responseWithAuthor(favorited bool, favoritesCount uint, author users.ProfileResponse) ArticleResponse {
 	response := ArticleResponse{
 		ID:             s.ID,
 		Slug:           s.Slug,
@@ -100,9 +137,11 @@ func (s *ArticleSerializer) ResponseWithPreloaded(favorited bool, favoritesCount
 		Body:           s.Body,
 		CreatedAt:      s.CreatedAt.UTC().Format("2006-01-02T15:04:05.999Z"),
 		UpdatedAt:      s.UpdatedAt.UTC().Format("2006-01-02T15:04:05.999Z"),
-		Author:         authorSerializer.Response(),
+		Author:         author,
 		Favorite:       favorited,
 		FavoritesCount: favoritesCount,
+		IsAuthor:       s.isAuthoredBy(s.C.MustGet("my_user_model").(users.UserModel)),
+		Draft:          s.Draft,
 	}
 	response.Tags = make([]string, 0)
 	for _, tag := range s.Tags {
@@ -119,23 +158,56 @@ func (s *ArticlesSerializer) Response() []ArticleResponse {
 		return response
 	}
 
-	// Batch fetch favorite counts and status
+	// Batch fetch favorite counts and status in one query
 	var articleIDs []uint
 	for _, article := range s.Articles {
 		articleIDs = append(articleIDs, article.ID)
 	}
 
-	favoriteCounts := BatchGetFavoriteCounts(articleIDs)
-
 	myUserModel := s.C.MustGet("my_user_model").(users.UserModel)
-	articleUserModel := GetArticleUserModel(myUserModel)
-	favoriteStatus := BatchGetFavoriteStatus(articleIDs, articleUserModel.ID)
+	favoriteCounts, favoriteStatus := BatchGetFavoriteSummary(articleIDs, myUserModel.ID)
+	readStatus := BatchGetReadStatus(articleIDs, myUserModel.ID)
+	commentCounts := BatchGetCommentCounts(articleIDs)
+
+	// Batch fetch author follow data
+	var authorUserIDs []uint
+	for _, article := range s.Articles {
+		authorUserIDs = append(authorUserIDs, article.Author.UserModel.ID)
+	}
+	followCounts := users.BatchGetFollowCounts(authorUserIDs)
+	followingStatus := users.BatchGetFollowingStatus(myUserModel.ID, authorUserIDs)
 
 	for _, article := range s.Articles {
 		serializer := ArticleSerializer{C: s.C, ArticleModel: article}
 		favorited := favoriteStatus[article.ID]
 		count := favoriteCounts[article.ID]
-		response = append(response, serializer.ResponseWithPreloaded(favorited, count))
+		authorID := article.Author.UserModel.ID
+		authorSerializer := users.ProfileSerializer{C: s.C, UserModel: article.Author.UserModel}
+		author := authorSerializer.ResponseWithPreloaded(followingStatus[authorID], followCounts[authorID])
+		articleResponse := serializer.responseWithAuthor(favorited, count, author)
+		articleResponse.Read = readFlag(myUserModel, readStatus[article.ID])
+		articleResponse.CommentsCount = commentCounts[article.ID]
+		response = append(response, articleResponse)
+	}
+	return response
+}
+
+// AuditArticleResponse is an article as admins see it, DeletedAt is set for soft-deleted ones.
+type AuditArticleResponse struct {
+	ArticleResponse
+	DeletedAt *string `json:"deletedAt"`
+}
+
+func (s *ArticlesSerializer) AuditResponse() []AuditArticleResponse {
+	articles := s.Response()
+	response := make([]AuditArticleResponse, 0, len(articles))
+	for i, article := range articles {
+		var deletedAt *string
+		if deleted := s.Articles[i].DeletedAt; deleted.Valid {
+			formatted := deleted.Time.UTC().Format("2006-01-02T15:04:05.999Z")
+			deletedAt = &formatted
+		}
+		response = append(response, AuditArticleResponse{ArticleResponse: article, DeletedAt: deletedAt})
 	}
 	return response
 }
@@ -156,25 +228,160 @@ type CommentResponse struct {
 	CreatedAt string                `json:"createdAt"`
 	UpdatedAt string                `json:"updatedAt"`
 	Author    users.ProfileResponse `json:"author"`
+	Deleted   bool                  `json:"deleted"`
 }
 
 func (s *CommentSerializer) Response() CommentResponse {
 	authorSerializer := ArticleUserSerializer{C: s.C, ArticleUserModel: s.Author}
+	return s.responseWithAuthor(authorSerializer.Response())
+}
+
+// responseWithAuthor serializes the comment, a tombstone keeps its place in the thread but
+// no longer tells who wrote it.
+func (s *CommentSerializer) responseWithAuthor(author users.ProfileResponse) CommentResponse {
+	if s.Deleted {
+		author = users.ProfileResponse{}
+	}
 	response := CommentResponse{
 		ID:        s.ID,
 		Body:      s.Body,
 		CreatedAt: s.CreatedAt.UTC().Format("2006-01-02T15:04:05.999Z"),
 		UpdatedAt: s.UpdatedAt.UTC().Format("2006-01-02T15:04:05.999Z"),
-		Author:    authorSerializer.Response(),
+		Author:    author,
+		Deleted:   s.Deleted,
 	}
 	return response
 }
 
 func (s *CommentsSerializer) Response() []CommentResponse {
 	response := []CommentResponse{}
+	if len(s.Comments) == 0 {
+		return response
+	}
+
+	// Batch fetch author follow data
+	var authorUserIDs []uint
+	for _, comment := range s.Comments {
+		authorUserIDs = append(authorUserIDs, comment.Author.UserModel.ID)
+	}
+	myUserModel := s.C.MustGet("my_user_model").(users.UserModel)
+	followCounts := users.BatchGetFollowCounts(authorUserIDs)
+	followingStatus := users.BatchGetFollowingStatus(myUserModel.ID, authorUserIDs)
+
 	for _, comment := range s.Comments {
 		serializer := CommentSerializer{C: s.C, CommentModel: comment}
-		response = append(response, serializer.Response())
+		authorID := comment.Author.UserModel.ID
+		authorSerializer := users.ProfileSerializer{C: s.C, UserModel: comment.Author.UserModel}
+		author := authorSerializer.ResponseWithPreloaded(followingStatus[authorID], followCounts[authorID])
+		response = append(response, serializer.responseWithAuthor(author))
+	}
+	return response
+}
+
+// UserCommentResponse is a comment listed outside its thread, with the article it belongs to.
+type UserCommentResponse struct {
+	CommentResponse
+	Article CommentArticleResponse `json:"article"`
+}
+
+type CommentArticleResponse struct {
+	Slug  string `json:"slug"`
+	Title string `json:"title"`
+}
+
+// UserCommentsResponse serializes comments with their article context, for lists spanning many articles.
+func (s *CommentsSerializer) UserCommentsResponse() []UserCommentResponse {
+	comments := s.Response()
+	response := make([]UserCommentResponse, 0, len(comments))
+	for i, comment := range comments {
+		article := s.Comments[i].Article
+		response = append(response, UserCommentResponse{
+			CommentResponse: comment,
+			Article:         CommentArticleResponse{Slug: article.Slug, Title: article.Title},
+		})
+	}
+	return response
+}
+
+// FlaggedCommentResponse is a comment in an author's moderation queue with its open flags.
+type FlaggedCommentResponse struct {
+	UserCommentResponse
+	Flags []CommentFlagResponse `json:"flags"`
+}
+
+type CommentFlagResponse struct {
+	Reason    string `json:"reason"`
+	CreatedAt string `json:"createdAt"`
+}
+
+// FlaggedResponse serializes a moderation queue, the comments must have their Flags loaded.
+func (s *CommentsSerializer) FlaggedResponse() []FlaggedCommentResponse {
+	comments := s.UserCommentsResponse()
+	response := make([]FlaggedCommentResponse, 0, len(comments))
+	for i, comment := range comments {
+		flags := make([]CommentFlagResponse, 0, len(s.Comments[i].Flags))
+		for _, flag := range s.Comments[i].Flags {
+			flags = append(flags, CommentFlagResponse{
+				Reason:    flag.Reason,
+				CreatedAt: flag.CreatedAt.UTC().Format("2006-01-02T15:04:05.999Z"),
+			})
+		}
+		response = append(response, FlaggedCommentResponse{UserCommentResponse: comment, Flags: flags})
+	}
+	return response
+}
+
+type RevisionsSerializer struct {
+	C         *gin.Context
+	Revisions []ArticleRevisionModel
+}
+
+type RevisionResponse struct {
+	Version   int    `json:"version"`
+	UpdatedAt string `json:"updatedAt"`
+}
+
+func (s *RevisionsSerializer) Response() []RevisionResponse {
+	response := make([]RevisionResponse, 0, len(s.Revisions))
+	for _, revision := range s.Revisions {
+		response = append(response, RevisionResponse{
+			Version:   revision.Version,
+			UpdatedAt: revision.CreatedAt.UTC().Format("2006-01-02T15:04:05.999Z"),
+		})
+	}
+	return response
+}
+
+// ProfileWithArticlesResponse is a profile with the author's most recent articles embedded.
+type ProfileWithArticlesResponse struct {
+	users.ProfileResponse
+	Articles []ArticleResponse `json:"articles"`
+}
+
+type DraftSummarySerializer struct {
+	C *gin.Context
+	UserDraftSummary
+}
+
+type DraftSummaryResponse struct {
+	Count      int              `json:"count"`
+	LastEdited *LastEditedDraft `json:"lastEdited"`
+}
+
+type LastEditedDraft struct {
+	Slug      string `json:"slug"`
+	Title     string `json:"title"`
+	UpdatedAt string `json:"updatedAt"`
+}
+
+func (s *DraftSummarySerializer) Response() DraftSummaryResponse {
+	response := DraftSummaryResponse{Count: s.Count}
+	if s.LastEdited != nil {
+		response.LastEdited = &LastEditedDraft{
+			Slug:      s.LastEdited.Slug,
+			Title:     s.LastEdited.Title,
+			UpdatedAt: s.LastEdited.UpdatedAt.UTC().Format("2006-01-02T15:04:05.999Z"),
+		}
 	}
 	return response
 }
@@ -0,0 +1,70 @@
+package articles
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/gothinkster/golang-gin-realworld-example-app/common"
+	"gorm.io/gorm"
+)
+
+const (
+	sitemapOpen  = xml.Header + `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">` + "\n"
+	sitemapClose = "</urlset>\n"
+)
+
+type sitemapURL struct {
+	XMLName xml.Name `xml:"url"`
+	Loc     string   `xml:"loc"`
+	LastMod string   `xml:"lastmod"`
+}
+
+// encodeSitemapURLs writes one <url> entry per article, linking to the frontend's /article/:slug page.
+func encodeSitemapURLs(enc *xml.Encoder, baseURL string, articles []ArticleModel) error {
+	for _, article := range articles {
+		entry := sitemapURL{
+			Loc:     baseURL + "/article/" + url.PathEscape(article.Slug),
+			LastMod: article.UpdatedAt.UTC().Format(time.RFC3339),
+		}
+		if err := enc.Encode(entry); err != nil {
+			return err
+		}
+	}
+	return enc.Flush()
+}
+
+// RenderSitemap renders a sitemap of the given articles, for sets small enough to hold in memory.
+func RenderSitemap(baseURL string, articles []ArticleModel) ([]byte, error) {
+	var out bytes.Buffer
+	out.WriteString(sitemapOpen)
+	if err := encodeSitemapURLs(xml.NewEncoder(&out), baseURL, articles); err != nil {
+		return nil, err
+	}
+	out.WriteString(sitemapClose)
+	return out.Bytes(), nil
+}
+
+// StreamSitemap writes the sitemap of every published article to w. Rows are read in batches
+// and written as they are read, so large sites are never held in memory.
+func StreamSitemap(w io.Writer, baseURL string) error {
+	db := common.MustGetDB()
+	if _, err := io.WriteString(w, sitemapOpen); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	var batch []ArticleModel
+	err := db.Select("id", "slug", "updated_at").
+		Where("draft = ?", false).
+		Order("id").
+		FindInBatches(&batch, backupBatchSize, func(tx *gorm.DB, _ int) error {
+			return encodeSitemapURLs(enc, baseURL, batch)
+		}).Error
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, sitemapClose)
+	return err
+}
@@ -1,14 +1,26 @@
 package articles
 
 import (
+	"archive/zip"
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gosimple/slug"
 	"github.com/gothinkster/golang-gin-realworld-example-app/common"
 	"github.com/gothinkster/golang-gin-realworld-example-app/users"
 	"github.com/stretchr/testify/assert"
@@ -27,9 +39,13 @@ func setupRouter() *gin.Engine {
 	v1.Use(users.AuthMiddleware(false))
 	ArticlesAnonymousRegister(v1.Group("/articles"))
 	TagsAnonymousRegister(v1.Group("/tags"))
+	users.ProfileRetrieveRegister(v1.Group("/profiles"))
+	ProfileArticlesRegister(v1.Group("/profiles"))
 
 	v1.Use(users.AuthMiddleware(true))
 	ArticlesRegister(v1.Group("/articles"))
+	UserArticlesRegister(v1.Group("/user"))
+	AdminRegister(v1.Group("/admin", users.AdminMiddleware()))
 
 	return r
 }
@@ -333,6 +349,12 @@ func userModelMocker(n int) []users.UserModel {
 }
 
 func resetDBWithMock() {
+	resetDB()
+	userModelMocker(3)
+}
+
+// resetDB recreates an empty test database
+func resetDB() {
 	common.TestDBFree(test_db)
 	test_db = common.TestDBInit()
 	users.AutoMigrate()
@@ -341,7 +363,24 @@ func resetDBWithMock() {
 	test_db.AutoMigrate(&FavoriteModel{})
 	test_db.AutoMigrate(&ArticleUserModel{})
 	test_db.AutoMigrate(&CommentModel{})
-	userModelMocker(3)
+	test_db.AutoMigrate(&ArticleRevisionModel{})
+	test_db.AutoMigrate(&ReadModel{})
+	test_db.AutoMigrate(&SlugHistoryModel{})
+	test_db.AutoMigrate(&CommentFlagModel{})
+	test_db.AutoMigrate(&TagSubscriptionModel{})
+	allTagsCache.invalidate()
+}
+
+// countQueries runs fn and returns how many SELECT statements it issued.
+func countQueries(fn func()) int {
+	count := 0
+	name := fmt.Sprintf("test:count_queries_%d", common.RandInt())
+	test_db.Callback().Query().After("gorm:query").Register(name, func(*gorm.DB) {
+		count++
+	})
+	defer test_db.Callback().Query().Remove(name)
+	fn()
+	return count
 }
 
 // Router tests
@@ -916,6 +955,7 @@ func TestArticleFavoriteEndpoint(t *testing.T) {
 		AuthorID:    articleUserModel.ID,
 	}
 	SaveOne(&article)
+	asserts.NoError(test_db.Create(&CommentModel{ArticleID: article.ID, AuthorID: articleUserModel.ID, Body: "first"}).Error)
 
 	// Test favorite article
 	req, _ := http.NewRequest("POST", fmt.Sprintf("/api/articles/%s/favorite", slug), nil)
@@ -925,6 +965,7 @@ func TestArticleFavoriteEndpoint(t *testing.T) {
 
 	asserts.Equal(http.StatusOK, w.Code, "Article favorite should return 200")
 	asserts.Contains(w.Body.String(), `"favorited":true`, "Article should be favorited")
+	asserts.Contains(w.Body.String(), `"commentsCount":1`, "Favoriting should report the comments like any article response")
 
 	// Test unfavorite article
 	req, _ = http.NewRequest("DELETE", fmt.Sprintf("/api/articles/%s/favorite", slug), nil)
@@ -934,6 +975,7 @@ func TestArticleFavoriteEndpoint(t *testing.T) {
 
 	asserts.Equal(http.StatusOK, w.Code, "Article unfavorite should return 200")
 	asserts.Contains(w.Body.String(), `"favorited":false`, "Article should be unfavorited")
+	asserts.Contains(w.Body.String(), `"commentsCount":1`)
 }
 
 func TestArticleCommentsEndpoint(t *testing.T) {
@@ -1348,7 +1390,7 @@ func TestSetTagsRaceCondition(t *testing.T) {
 	err := article.setTags([]string{"tag1", "tag2", "tag1"})
 	asserts.NoError(err, "setTags should handle duplicate tags")
 	// Should have 2 unique tags
-	asserts.Equal(3, len(article.Tags), "Should preserve all tags in list")
+	asserts.Equal(2, len(article.Tags), "Should attach a repeated tag once")
 }
 
 func TestArticleFeedWithEmptyFollowings(t *testing.T) {
@@ -1590,6 +1632,3811 @@ func TestCommentDeleteAuthorizationForbidden(t *testing.T) {
 	asserts.Equal(comment.ID, foundComment.ID, "Comment ID should match")
 }
 
+func TestFavoriteByCreated(t *testing.T) {
+	asserts := assert.New(t)
+
+	article, _ := createArticleWithUser("Favorite Created Article", fmt.Sprintf("favorite-created-%d", common.RandInt()))
+	fan := GetArticleUserModel(createTestUser())
+
+	created, err := article.favoriteByCreated(fan, false)
+	asserts.NoError(err)
+	asserts.True(created, "First favorite should create a row")
+
+	created, err = article.favoriteByCreated(fan, false)
+	asserts.NoError(err)
+	asserts.False(created, "Repeated favorite should not create a row")
+	asserts.Equal(uint(1), article.favoritesCount(), "Repeated favorite should not change the count")
+
+	asserts.NoError(article.unFavoriteBy(fan))
+	created, err = article.favoriteByCreated(fan, false)
+	asserts.NoError(err)
+	asserts.True(created, "Favoriting again after unfavorite should create a row")
+}
+
+func TestArticleFavoriteRepeat(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	article, _ := createArticleWithUser("Favorite Repeat Article", fmt.Sprintf("favorite-repeat-%d", common.RandInt()))
+	fan := createTestUser()
+	recounts := 0
+	test_db.Callback().Query().After("gorm:query").Register("test:count_recounts", func(db *gorm.DB) {
+		sql := strings.ToLower(db.Statement.SQL.String())
+		if strings.Contains(sql, "count(") && strings.Contains(sql, "favorite_models") {
+			recounts++
+		}
+	})
+	defer test_db.Callback().Query().Remove("test:count_recounts")
+	favorite := func() *httptest.ResponseRecorder {
+		recounts = 0
+		req, _ := http.NewRequest("POST", "/api/articles/"+article.Slug+"/favorite", nil)
+		common.HeaderTokenMock(req, fan.ID)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := favorite()
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Contains(w.Body.String(), `"favoritesCount":1`)
+	asserts.Equal(1, recounts, "A new favorite should count once")
+
+	w = favorite()
+	asserts.Equal(http.StatusNotModified, w.Code, "A repeat favorite should change nothing")
+	asserts.Empty(w.Body.String())
+	asserts.Zero(recounts, "A repeat favorite should not recount")
+	asserts.Equal(uint(1), article.favoritesCount())
+}
+
+func TestArticleSelfFavoriteConfig(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	article, author := createArticleWithUser("Self Favorite Article", fmt.Sprintf("self-favorite-%d", common.RandInt()))
+	url := fmt.Sprintf("/api/articles/%s/favorite", article.Slug)
+
+	os.Setenv("ALLOW_SELF_FAVORITE", "false")
+	defer os.Unsetenv("ALLOW_SELF_FAVORITE")
+	req, _ := http.NewRequest("POST", url, nil)
+	common.HeaderTokenMock(req, author.ID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusUnprocessableEntity, w.Code, "Self favorite should be rejected when disabled")
+	asserts.Equal(`{"errors":{"favorite":"cannot favorite your own article"}}`, w.Body.String())
+	asserts.Equal(uint(0), article.favoritesCount())
+
+	other := createTestUser()
+	req, _ = http.NewRequest("POST", url, nil)
+	common.HeaderTokenMock(req, other.ID)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code, "Other users can still favorite when disabled")
+
+	os.Setenv("ALLOW_SELF_FAVORITE", "true")
+	req, _ = http.NewRequest("POST", url, nil)
+	common.HeaderTokenMock(req, author.ID)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code, "Self favorite should be allowed when enabled")
+	asserts.Contains(w.Body.String(), `"favoritesCount":2`)
+}
+
+func TestBatchGetFavoriteCountsSoftDelete(t *testing.T) {
+	asserts := assert.New(t)
+
+	kept, _ := createArticleWithUser("Kept Article", fmt.Sprintf("kept-article-%d", common.RandInt()))
+	deleted, _ := createArticleWithUser("Deleted Article", fmt.Sprintf("deleted-article-%d", common.RandInt()))
+	fan := GetArticleUserModel(createTestUser())
+	goneFan := GetArticleUserModel(createTestUser())
+	asserts.NoError(kept.favoriteBy(fan))
+	asserts.NoError(kept.favoriteBy(goneFan))
+	asserts.NoError(deleted.favoriteBy(fan))
+
+	counts := BatchGetFavoriteCounts([]uint{kept.ID, deleted.ID})
+	asserts.Equal(uint(2), counts[kept.ID])
+	asserts.Equal(uint(1), counts[deleted.ID])
+
+	asserts.NoError(DeleteArticleModel(&ArticleModel{Slug: deleted.Slug}))
+	test_db.Delete(&goneFan)
+
+	counts = BatchGetFavoriteCounts([]uint{kept.ID, deleted.ID})
+	asserts.Equal(uint(1), counts[kept.ID], "Favorites by deleted users should not be counted")
+	asserts.Equal(uint(1), kept.favoritesCount(), "A single article should count like the batch")
+	_, ok := counts[deleted.ID]
+	asserts.False(ok, "Soft-deleted article should not appear in counts")
+}
+
+func TestArticleIsAuthor(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	article, author := createArticleWithUser("Is Author Article", fmt.Sprintf("is-author-%d", common.RandInt()))
+	other := createTestUser()
+	url := fmt.Sprintf("/api/articles/%s", article.Slug)
+
+	req, _ := http.NewRequest("GET", url, nil)
+	common.HeaderTokenMock(req, author.ID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Contains(w.Body.String(), `"isAuthor":true`, "Author should see isAuthor true")
+
+	req, _ = http.NewRequest("GET", url, nil)
+	common.HeaderTokenMock(req, other.ID)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Contains(w.Body.String(), `"isAuthor":false`, "Other user should see isAuthor false")
+
+	req, _ = http.NewRequest("GET", url, nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Contains(w.Body.String(), `"isAuthor":false`, "Anonymous viewer should see isAuthor false")
+
+	req, _ = http.NewRequest("GET", "/api/articles?author="+author.Username, nil)
+	common.HeaderTokenMock(req, author.ID)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Contains(w.Body.String(), `"isAuthor":true`, "List response should also flag the author")
+}
+
+func TestArticleListSortByComments(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	tag := fmt.Sprintf("discussed-%d", common.RandInt())
+	quiet, _ := createArticleWithUser("Quiet Article", fmt.Sprintf("quiet-%d", common.RandInt()))
+	busy, _ := createArticleWithUser("Busy Article", fmt.Sprintf("busy-%d", common.RandInt()))
+	untagged, _ := createArticleWithUser("Untagged Article", fmt.Sprintf("untagged-%d", common.RandInt()))
+	for _, article := range []*ArticleModel{&quiet, &busy} {
+		asserts.NoError(article.setTags([]string{tag}))
+		asserts.NoError(SaveOne(article))
+	}
+	commenter := GetArticleUserModel(createTestUser())
+	for i := 0; i < 3; i++ {
+		test_db.Create(&CommentModel{ArticleID: busy.ID, AuthorID: commenter.ID, Body: "busy comment"})
+		test_db.Create(&CommentModel{ArticleID: untagged.ID, AuthorID: commenter.ID, Body: "untagged comment"})
+	}
+	test_db.Create(&CommentModel{ArticleID: quiet.ID, AuthorID: commenter.ID, Body: "quiet comment"})
+	// Tombstoned and soft-deleted comments are not counted, as in commentsCount
+	for i := 0; i < 3; i++ {
+		test_db.Create(&CommentModel{ArticleID: quiet.ID, AuthorID: commenter.ID, Body: "removed", Deleted: true})
+		removed := CommentModel{ArticleID: quiet.ID, AuthorID: commenter.ID, Body: "removed"}
+		test_db.Create(&removed)
+		test_db.Delete(&removed)
+	}
+	// Make the quiet article the most recently updated one
+	asserts.NoError(quiet.Update(map[string]interface{}{"Body": "bumped"}))
+
+	filter := NewArticleListFilter(tag, "", "10", "0", "")
+	filter.Sort = ArticleSortComments
+	articles, count, err := FindManyArticleWithFilter(filter)
+	asserts.NoError(err)
+	asserts.Equal(2, count, "Sort should compose with the tag filter")
+	asserts.Len(articles, 2)
+	asserts.Equal(busy.ID, articles[0].ID, "Article with more comments should rank first")
+	asserts.Equal(quiet.ID, articles[1].ID)
+
+	req, _ := http.NewRequest("GET", "/api/articles?sort=comments&tag="+tag, nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Regexp(`"slug":"`+busy.Slug+`".*"slug":"`+quiet.Slug+`"`, w.Body.String())
+	asserts.NotContains(w.Body.String(), untagged.Slug)
+
+	// Equal counts keep the newest article first, however recently the others were edited
+	newer, _ := createArticleWithUser("Newer Article", fmt.Sprintf("newer-%d", common.RandInt()))
+	asserts.NoError(newer.setTags([]string{tag}))
+	asserts.NoError(SaveOne(&newer))
+	test_db.Create(&CommentModel{ArticleID: newer.ID, AuthorID: commenter.ID, Body: "newer comment"})
+	test_db.Model(&quiet).UpdateColumn("created_at", time.Now().Add(-time.Hour))
+	asserts.NoError(quiet.Update(map[string]interface{}{"Body": "bumped again"}))
+	articles, _, err = FindManyArticleWithFilter(filter)
+	asserts.NoError(err)
+	asserts.Len(articles, 3)
+	asserts.Equal([]uint{busy.ID, newer.ID, quiet.ID}, []uint{articles[0].ID, articles[1].ID, articles[2].ID})
+}
+
+func TestCreateArticleDescriptionConfig(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	user := createTestUser()
+	create := func(title string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"article":{"title":"%s","body":"Test Body"}}`, title)
+		req, _ := http.NewRequest("POST", "/api/articles", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		common.HeaderTokenMock(req, user.ID)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := create(fmt.Sprintf("Required Description %d", common.RandInt()))
+	asserts.Equal(http.StatusUnprocessableEntity, w.Code, "Missing description should return 422 by default")
+	asserts.Equal(`{"errors":{"Description":"{key: required}"}}`, w.Body.String(), "Clients should keep reading the required key")
+
+	os.Setenv("REQUIRE_DESCRIPTION", "false")
+	defer os.Unsetenv("REQUIRE_DESCRIPTION")
+	w = create(fmt.Sprintf("Optional Description %d", common.RandInt()))
+	asserts.Equal(http.StatusCreated, w.Code, "Missing description should be accepted in optional mode")
+	asserts.Contains(w.Body.String(), `"description":""`)
+}
+
+func TestFavoriteTagsRanked(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	suffix := common.RandInt()
+	goTag, webTag, dbTag := fmt.Sprintf("go-%d", suffix), fmt.Sprintf("web-%d", suffix), fmt.Sprintf("db-%d", suffix)
+	tagged := [][]string{{goTag, webTag}, {goTag, dbTag}, {goTag, webTag}}
+	reader := createTestUser()
+	readerArticleUser := GetArticleUserModel(reader)
+	for i, tags := range tagged {
+		article, _ := createArticleWithUser("Interest Article", fmt.Sprintf("interest-%d-%d", suffix, i))
+		asserts.NoError(article.setTags(tags))
+		asserts.NoError(SaveOne(&article))
+		asserts.NoError(article.favoriteBy(readerArticleUser))
+	}
+
+	interests, err := FavoriteTagsRanked(reader.ID, 10)
+	asserts.NoError(err)
+	asserts.Equal([]TagCount{{goTag, 3}, {webTag, 2}, {dbTag, 1}}, interests)
+
+	interests, err = FavoriteTagsRanked(reader.ID, 1)
+	asserts.NoError(err)
+	asserts.Len(interests, 1, "Limit should be applied")
+
+	fresh := createTestUser()
+	interests, err = FavoriteTagsRanked(fresh.ID, 10)
+	asserts.NoError(err)
+	asserts.Empty(interests, "User without favorites should have no interests")
+
+	req, _ := http.NewRequest("GET", "/api/user/interests", nil)
+	common.HeaderTokenMock(req, fresh.ID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal(`{"interests":[]}`, w.Body.String())
+
+	req, _ = http.NewRequest("GET", "/api/user/interests", nil)
+	common.HeaderTokenMock(req, reader.ID)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Contains(w.Body.String(), fmt.Sprintf(`{"tag":"%s","count":3}`, goTag))
+}
+
+func TestTagListCache(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	allTagsCache.invalidate()
+	os.Setenv("TAGS_CACHE_TTL", "1m")
+	defer os.Unsetenv("TAGS_CACHE_TTL")
+
+	_, err := getAllTags()
+	asserts.NoError(err)
+	queries := countQueries(func() {
+		_, err = getAllTags()
+	})
+	asserts.NoError(err)
+	asserts.Equal(0, queries, "Second call within TTL should not hit the DB")
+
+	article, _ := createArticleWithUser("Cache Article", fmt.Sprintf("cache-article-%d", common.RandInt()))
+	newTag := fmt.Sprintf("fresh-%d", common.RandInt())
+	asserts.NoError(article.setTags([]string{newTag}))
+	queries = countQueries(func() {
+		_, err = getAllTags()
+	})
+	asserts.Equal(1, queries, "Creating a tag should invalidate the cache")
+
+	req, _ := http.NewRequest("GET", "/api/tags", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal("public, max-age=60", w.Header().Get("Cache-Control"))
+	asserts.Contains(w.Body.String(), newTag)
+
+	os.Setenv("TAGS_CACHE_TTL", "0s")
+	queries = countQueries(func() {
+		_, err = getAllTags()
+	})
+	asserts.Equal(1, queries, "A zero TTL should disable the cache")
+}
+
+func TestArticleArchiveCounts(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	r := setupRouter()
+	january := time.Date(2024, time.January, 15, 12, 0, 0, 0, time.UTC)
+	february := time.Date(2024, time.February, 3, 8, 0, 0, 0, time.UTC)
+	for i, createdAt := range []time.Time{january, january.AddDate(0, 0, 10), february} {
+		article, _ := createArticleWithUser("Archive Article", fmt.Sprintf("archive-%d", i))
+		test_db.Model(&article).UpdateColumn("created_at", createdAt)
+	}
+
+	buckets, err := ArticleArchiveCounts()
+	asserts.NoError(err)
+	asserts.Equal([]ArchiveBucket{{"2024-02", 1}, {"2024-01", 2}}, buckets)
+
+	req, _ := http.NewRequest("GET", "/api/articles/archive", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal(`{"archive":[{"month":"2024-02","count":1},{"month":"2024-01","count":2}]}`, w.Body.String())
+}
+
+func TestArticleCommentsLock(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	article, author := createArticleWithUser("Locked Article", fmt.Sprintf("locked-%d", common.RandInt()))
+	reader := createTestUser()
+	request := func(method, url, body string, userID uint) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		common.HeaderTokenMock(req, userID)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	lockURL := fmt.Sprintf("/api/articles/%s/lock", article.Slug)
+	unlockURL := fmt.Sprintf("/api/articles/%s/unlock", article.Slug)
+	commentURL := fmt.Sprintf("/api/articles/%s/comments", article.Slug)
+	commentBody := `{"comment":{"body":"Is this thing on?"}}`
+
+	w := request("POST", lockURL, "", reader.ID)
+	asserts.Equal(http.StatusForbidden, w.Code, "Only the author can lock comments")
+
+	w = request("POST", lockURL, "", author.ID)
+	asserts.Equal(http.StatusOK, w.Code)
+
+	w = request("POST", commentURL, commentBody, reader.ID)
+	asserts.Equal(http.StatusForbidden, w.Code, "Commenting on a locked article should be refused")
+	asserts.Equal(`{"errors":{"comments":"locked"}}`, w.Body.String())
+
+	w = request("POST", unlockURL, "", author.ID)
+	asserts.Equal(http.StatusOK, w.Code)
+
+	w = request("POST", commentURL, commentBody, reader.ID)
+	asserts.Equal(http.StatusCreated, w.Code, "Commenting after unlock should succeed")
+}
+
+func TestArticleFeedWithFilter(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	reader := createTestUser()
+	writer := createTestUser()
+	asserts.NoError(followUser(reader, writer))
+	writerArticleUser := GetArticleUserModel(writer)
+	tag := fmt.Sprintf("feedtag-%d", common.RandInt())
+	base := time.Date(2024, time.May, 1, 0, 0, 0, 0, time.UTC)
+
+	articles := map[string]ArticleModel{}
+	for i, spec := range []struct {
+		name   string
+		tagged bool
+		days   int
+	}{{"old-tagged", true, 0}, {"new-tagged", true, 10}, {"new-untagged", false, 10}, {"late-tagged", true, 20}} {
+		article := ArticleModel{
+			Slug:        fmt.Sprintf("feed-filter-%s-%d-%d", spec.name, i, common.RandInt()),
+			Title:       spec.name,
+			Description: "Test Description",
+			Body:        "Test Body",
+			Author:      writerArticleUser,
+			AuthorID:    writerArticleUser.ID,
+		}
+		if spec.tagged {
+			asserts.NoError(article.setTags([]string{tag}))
+		}
+		asserts.NoError(SaveOne(&article))
+		test_db.Model(&article).UpdateColumn("created_at", base.AddDate(0, 0, spec.days))
+		articles[spec.name] = article
+	}
+
+	readerArticleUser := GetArticleUserModel(reader)
+	result, count, err := readerArticleUser.GetArticleFeedWithFilter(FeedFilter{
+		Tag:    tag,
+		After:  base.AddDate(0, 0, 5),
+		Before: base.AddDate(0, 0, 15),
+		Limit:  10,
+	})
+	asserts.NoError(err)
+	asserts.Equal(1, count, "Tag and date filters should compose")
+	asserts.Len(result, 1)
+	asserts.Equal(articles["new-tagged"].ID, result[0].ID)
+
+	_, count, err = readerArticleUser.GetArticleFeed("10", "0")
+	asserts.NoError(err)
+	asserts.Equal(4, count, "Wrapper should keep returning the unfiltered feed")
+
+	req, _ := http.NewRequest("GET", "/api/articles/feed?tag="+tag+"&after=2024-05-06T00:00:00Z", nil)
+	common.HeaderTokenMock(req, reader.ID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Contains(w.Body.String(), `"articlesCount":2`)
+
+	req, _ = http.NewRequest("GET", "/api/articles/feed?before=yesterday", nil)
+	common.HeaderTokenMock(req, reader.ID)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusUnprocessableEntity, w.Code, "Invalid timestamps should be rejected")
+}
+
+func TestCommentsSerializerFollowingFlags(t *testing.T) {
+	asserts := assert.New(t)
+
+	article, _ := createArticleWithUser("Thread Article", fmt.Sprintf("thread-%d", common.RandInt()))
+	viewer := createTestUser()
+	followed := createTestUser()
+	stranger := createTestUser()
+	asserts.NoError(followUser(viewer, followed))
+	for i := 0; i < 3; i++ {
+		for _, author := range []users.UserModel{followed, stranger} {
+			test_db.Create(&CommentModel{ArticleID: article.ID, AuthorID: GetArticleUserModel(author).ID, Body: "thread comment"})
+		}
+	}
+	asserts.NoError(article.getComments())
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set("my_user_model", viewer)
+	serializer := CommentsSerializer{c, article.Comments}
+	var response []CommentResponse
+	queries := countQueries(func() {
+		response = serializer.Response()
+	})
+
+	asserts.Len(response, 6)
+	for _, comment := range response {
+		asserts.Equal(comment.Author.Username == followed.Username, comment.Author.Following,
+			"Following flag should be true only for the followed author")
+	}
+	asserts.LessOrEqual(queries, 3, "Author data should be batch-loaded, not fetched per comment")
+}
+
+func TestArticleListAfterUsernameChange(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	r.Group("/api/user", users.AuthMiddleware(true)).PUT("", users.UserUpdate)
+	article, author := createArticleWithUser("Renamed Author Article", fmt.Sprintf("renamed-author-%d", common.RandInt()))
+	oldUsername := author.Username
+	newUsername := fmt.Sprintf("renamed%d", common.RandInt())
+
+	req, _ := http.NewRequest("PUT", "/api/user", bytes.NewBufferString(fmt.Sprintf(`{"user":{"username":"%s"}}`, newUsername)))
+	req.Header.Set("Content-Type", "application/json")
+	common.HeaderTokenMock(req, author.ID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+
+	articles, count, err := FindManyArticle("", newUsername, "10", "0", "")
+	asserts.NoError(err)
+	asserts.Equal(1, count, "Articles should be found by the new username")
+	asserts.Equal(article.ID, articles[0].ID)
+	asserts.Equal(newUsername, articles[0].Author.UserModel.Username)
+
+	_, count, err = FindManyArticle("", oldUsername, "10", "0", "")
+	asserts.NoError(err)
+	asserts.Equal(0, count, "The old username should no longer match")
+}
+
+func TestArticleListExcludeOwn(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	tag := fmt.Sprintf("discovery-%d", common.RandInt())
+	var viewer users.UserModel
+	for i := 0; i < 3; i++ {
+		article, author := createArticleWithUser("Discovery Article", fmt.Sprintf("discovery-%d-%d", i, common.RandInt()))
+		asserts.NoError(article.setTags([]string{tag}))
+		asserts.NoError(SaveOne(&article))
+		if i == 0 {
+			viewer = author
+		}
+	}
+
+	list := func(query string, userID uint) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/api/articles?tag="+tag+query, nil)
+		if userID != 0 {
+			common.HeaderTokenMock(req, userID)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := list("&excludeOwn=true", viewer.ID)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Contains(w.Body.String(), `"articlesCount":2`, "Own article should be excluded")
+	asserts.NotContains(w.Body.String(), `"username":"`+viewer.Username+`"`)
+
+	w = list("", viewer.ID)
+	asserts.Contains(w.Body.String(), `"articlesCount":3`, "Own article should be included without the flag")
+
+	w = list("&excludeOwn=true", 0)
+	asserts.Contains(w.Body.String(), `"articlesCount":3`, "Anonymous viewers should ignore the flag")
+}
+
+func TestArticleListBySlugs(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	suffix := common.RandInt()
+	first, _ := createArticleWithUser("First By Slug", fmt.Sprintf("first-by-slug-%d", suffix))
+	second, _ := createArticleWithUser("Second By Slug", fmt.Sprintf("second-by-slug-%d", suffix))
+
+	articles, err := FindArticlesBySlugs([]string{second.Slug, "unknown-slug", first.Slug})
+	asserts.NoError(err)
+	asserts.Len(articles, 2, "Unknown slugs should be omitted")
+	asserts.Equal(second.ID, articles[0].ID, "Requested order should be preserved")
+	asserts.Equal(first.ID, articles[1].ID)
+
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/api/articles?slugs=%s,unknown-slug,%s", second.Slug, first.Slug), nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Regexp(`"slug":"`+second.Slug+`".*"slug":"`+first.Slug+`"`, w.Body.String())
+	asserts.Contains(w.Body.String(), `"articlesCount":2`)
+
+	// Past the limit, the trailing slugs are dropped
+	overLimit := make([]string, 0, maxSlugsPerRequest+1)
+	for i := 0; i < maxSlugsPerRequest; i++ {
+		overLimit = append(overLimit, fmt.Sprintf("missing-%d", i))
+	}
+	overLimit = append(overLimit, first.Slug)
+	req, _ = http.NewRequest("GET", "/api/articles?slugs="+strings.Join(overLimit, ","), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Contains(w.Body.String(), `"articlesCount":0`)
+}
+
+func TestArticleCommentListOrder(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	article, author := createArticleWithUser("Ordered Thread", fmt.Sprintf("ordered-thread-%d", common.RandInt()))
+	authorID := GetArticleUserModel(author).ID
+	base := time.Now().Add(-time.Hour)
+	var comments []CommentModel
+	for i := 0; i < 3; i++ {
+		comment := CommentModel{ArticleID: article.ID, AuthorID: authorID, Body: fmt.Sprintf("comment %d", i)}
+		comment.CreatedAt = base.Add(time.Duration(i) * time.Minute)
+		test_db.Create(&comment)
+		comments = append(comments, comment)
+	}
+
+	asc, count, err := GetCommentsPaged(article.ID, -1, 0, "asc")
+	asserts.NoError(err)
+	asserts.Equal(3, count)
+	asserts.Equal([]uint{comments[0].ID, comments[1].ID, comments[2].ID}, []uint{asc[0].ID, asc[1].ID, asc[2].ID})
+
+	desc, _, err := GetCommentsPaged(article.ID, 2, 0, "desc")
+	asserts.NoError(err)
+	asserts.Equal([]uint{comments[2].ID, comments[1].ID}, []uint{desc[0].ID, desc[1].ID}, "Newest first with a limit")
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/api/articles/%s/comments%s", article.Slug, query), nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	w := get("")
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Regexp(`"comment 0".*"comment 1".*"comment 2"`, w.Body.String(), "Default should be chronological")
+	asserts.Contains(w.Body.String(), `"commentsCount":3`)
+
+	w = get("?order=desc&limit=2&offset=1")
+	asserts.Regexp(`"comment 1".*"comment 0"`, w.Body.String())
+	asserts.NotContains(w.Body.String(), `"comment 2"`)
+
+	w = get("?order=sideways")
+	asserts.Equal(http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestArticleRetrieveIncludeComments(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	article, author := createArticleWithUser("Inline Comments", fmt.Sprintf("inline-comments-%d", common.RandInt()))
+	test_db.Create(&CommentModel{ArticleID: article.ID, AuthorID: GetArticleUserModel(author).ID, Body: "inline comment"})
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/api/articles/%s%s", article.Slug, query), nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("?include=comments")
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Contains(w.Body.String(), `"slug":"`+article.Slug+`"`)
+	asserts.Contains(w.Body.String(), `"comments":[{`)
+	asserts.Contains(w.Body.String(), `"body":"inline comment"`)
+
+	w = get("")
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.NotContains(w.Body.String(), `"comments"`, "Comments should be absent by default")
+
+	asserts.Equal(map[string]bool{"comments": true, "author": true}, parseInclude(" comments,,author "))
+}
+
+func TestSetTagsLimit(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	article, author := createArticleWithUser("Tag Limit Article", fmt.Sprintf("tag-limit-%d", common.RandInt()))
+	suffix := common.RandInt()
+	var tags []string
+	for i := 0; i < 11; i++ {
+		tags = append(tags, fmt.Sprintf("limit-%d-%d", suffix, i))
+	}
+
+	err := article.setTags(tags)
+	asserts.ErrorIs(err, ErrTooManyTags, "11 tags should exceed the default limit of 10")
+	asserts.Empty(article.Tags, "No tags should be associated")
+	var created int64
+	test_db.Model(&TagModel{}).Where("tag IN ?", tags).Count(&created)
+	asserts.Equal(int64(0), created, "No tags should be created")
+
+	asserts.NoError(article.setTags(tags[:10]), "10 tags should be allowed")
+	asserts.NoError(article.setTags(append(tags[:10:10], tags[0], " "+tags[1])), "Repeated tags should count once")
+	asserts.Len(article.Tags, 10)
+
+	os.Setenv("MAX_TAGS_PER_ARTICLE", "2")
+	defer os.Unsetenv("MAX_TAGS_PER_ARTICLE")
+	body := fmt.Sprintf(`{"article":{"title":"Too Many Tags %d","description":"d","body":"b","tagList":["a","b","c"]}}`, suffix)
+	req, _ := http.NewRequest("POST", "/api/articles", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	common.HeaderTokenMock(req, author.ID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusUnprocessableEntity, w.Code)
+	asserts.Equal(`{"errors":{"tagList":"too many tags: at most 2 allowed"}}`, w.Body.String())
+}
+
+func TestTagAllowlistMode(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	article, author := createArticleWithUser("Allowlist Article", fmt.Sprintf("allowlist-%d", common.RandInt()))
+	suffix := common.RandInt()
+	approved := fmt.Sprintf("approved-%d", suffix)
+	unknown := fmt.Sprintf("unknown-%d", suffix)
+	_, err := CreateTags([]string{approved})
+	asserts.NoError(err)
+
+	os.Setenv("TAG_MODE", "allowlist")
+	defer os.Unsetenv("TAG_MODE")
+	err = article.setTags([]string{approved, unknown})
+	asserts.ErrorIs(err, ErrTagsNotAllowed)
+	var created int64
+	test_db.Model(&TagModel{}).Where("tag = ?", unknown).Count(&created)
+	asserts.Equal(int64(0), created, "Unknown tags should not be created")
+	asserts.NoError(article.setTags([]string{approved}), "Seeded tags should be accepted")
+
+	post := func(tag string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"article":{"title":"Allowlist %s","description":"d","body":"b","tagList":["%s"]}}`, tag, tag)
+		req, _ := http.NewRequest("POST", "/api/articles", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		common.HeaderTokenMock(req, author.ID)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	w := post(unknown)
+	asserts.Equal(http.StatusUnprocessableEntity, w.Code)
+	asserts.Equal(fmt.Sprintf(`{"errors":{"tagList":"tags not allowed: %s"}}`, unknown), w.Body.String())
+	asserts.Equal(http.StatusCreated, post(approved).Code)
+
+	os.Setenv("TAG_MODE", "open")
+	asserts.NoError(article.setTags([]string{unknown}), "Open mode should create new tags")
+}
+
+// createAdminUser creates a test user with admin privileges
+func createAdminUser() users.UserModel {
+	user := createTestUser()
+	test_db.Model(&user).Update("admin", true)
+	user.Admin = true
+	return user
+}
+
+func TestStreamBackup(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	r := setupRouter()
+	article, author := createArticleWithUser("Backup Article", "backup-article")
+	asserts.NoError(article.setTags([]string{"backup", "zip"}))
+	asserts.NoError(SaveOne(&article))
+	fan := createTestUser()
+	asserts.NoError(article.favoriteBy(GetArticleUserModel(fan)))
+	asserts.NoError(followUser(fan, author))
+	test_db.Create(&CommentModel{ArticleID: article.ID, AuthorID: GetArticleUserModel(fan).ID, Body: "backup comment"})
+	admin := createAdminUser()
+
+	req, _ := http.NewRequest("GET", "/api/admin/backup", nil)
+	common.HeaderTokenMock(req, fan.ID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusForbidden, w.Code, "Non-admins should not get a backup")
+
+	req, _ = http.NewRequest("GET", "/api/admin/backup", nil)
+	common.HeaderTokenMock(req, admin.ID)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal("application/zip", w.Header().Get("Content-Type"))
+
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	asserts.NoError(err)
+	lines := map[string]int{}
+	contents := map[string]string{}
+	for _, file := range archive.File {
+		reader, err := file.Open()
+		asserts.NoError(err)
+		data, _ := io.ReadAll(reader)
+		reader.Close()
+		contents[file.Name] = string(data)
+		lines[file.Name] = strings.Count(string(data), "\n")
+	}
+	asserts.Equal(map[string]int{
+		"users.ndjson":     6, // 3 mocked users, author, fan and admin
+		"tags.ndjson":      2,
+		"articles.ndjson":  1,
+		"comments.ndjson":  1,
+		"favorites.ndjson": 1,
+		"follows.ndjson":   1,
+	}, lines)
+	asserts.Contains(contents["articles.ndjson"], `"tagList":["backup","zip"]`)
+	asserts.Contains(contents["articles.ndjson"], fmt.Sprintf(`"authorId":%d`, author.ID))
+}
+
+func TestRestoreBackup(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	article, author := createArticleWithUser("Restore Article", "restore-article")
+	asserts.NoError(article.setTags([]string{"restore", "zip"}))
+	article.CommentsLocked = true
+	asserts.NoError(SaveOne(&article))
+	fan := createTestUser()
+	_, err := article.favoriteByCreated(GetArticleUserModel(fan), true)
+	asserts.NoError(err)
+	asserts.NoError(followUser(fan, author))
+	test_db.Create(&CommentModel{ArticleID: article.ID, AuthorID: GetArticleUserModel(fan).ID, Body: "restore comment"})
+	test_db.Create(&CommentModel{ArticleID: article.ID, AuthorID: GetArticleUserModel(fan).ID, Deleted: true})
+	admin := createAdminUser()
+
+	var archive bytes.Buffer
+	asserts.NoError(StreamBackup(&archive))
+
+	// Restore into a fresh database
+	resetDB()
+	asserts.NoError(RestoreBackup(bytes.NewReader(archive.Bytes())))
+	for _, model := range []interface{}{&users.UserModel{}, &TagModel{}, &ArticleModel{}, &CommentModel{}, &FavoriteModel{}, &users.FollowModel{}} {
+		var count int64
+		test_db.Model(model).Count(&count)
+		asserts.NotZero(count, fmt.Sprintf("%T should be restored", model))
+	}
+	var userCount int64
+	test_db.Model(&users.UserModel{}).Count(&userCount)
+	asserts.Equal(int64(6), userCount)
+
+	restored, err := FindOneArticle(&ArticleModel{Slug: "restore-article"})
+	asserts.NoError(err)
+	asserts.Equal("Restore Article", restored.Title)
+	asserts.Equal(article.Body, restored.Body)
+	asserts.Equal(author.Username, restored.Author.UserModel.Username)
+	asserts.True(restored.CommentsLocked, "A locked thread should stay locked")
+	asserts.ElementsMatch([]string{"restore", "zip"}, []string{restored.Tags[0].Tag, restored.Tags[1].Tag})
+	asserts.Equal(uint(1), restored.favoritesCount())
+	var restoredFavorite FavoriteModel
+	test_db.Where(&FavoriteModel{FavoriteID: restored.ID}).First(&restoredFavorite)
+	asserts.True(restoredFavorite.Private, "A private favorite should stay private")
+	comments, _, err := GetCommentsPaged(restored.ID, -1, 0, "asc")
+	asserts.NoError(err)
+	asserts.Len(comments, 2)
+	asserts.False(comments[0].Deleted)
+	asserts.True(comments[1].Deleted, "Tombstones should come back as tombstones")
+
+	// Restoring the same archive again collides on unique columns and rolls back
+	var restoredAdmin users.UserModel
+	test_db.Where(&users.UserModel{Username: admin.Username}).First(&restoredAdmin)
+	asserts.True(restoredAdmin.Admin)
+	r := setupRouter()
+	req, _ := http.NewRequest("POST", "/api/admin/restore", bytes.NewReader(archive.Bytes()))
+	req.Header.Set("Content-Type", "application/zip")
+	common.HeaderTokenMock(req, restoredAdmin.ID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusUnprocessableEntity, w.Code)
+	asserts.Contains(w.Body.String(), `"backup"`)
+	test_db.Model(&users.UserModel{}).Count(&userCount)
+	asserts.Equal(int64(6), userCount, "A failed restore should leave no partial rows")
+
+	os.Setenv("RESTORE_MAX_BYTES", "100")
+	defer os.Unsetenv("RESTORE_MAX_BYTES")
+	req, _ = http.NewRequest("POST", "/api/admin/restore", bytes.NewReader(archive.Bytes()))
+	req.Header.Set("Content-Type", "application/zip")
+	common.HeaderTokenMock(req, restoredAdmin.ID)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusRequestEntityTooLarge, w.Code)
+	asserts.Equal(`{"errors":{"backup":"backup archive exceeds 100 bytes"}}`, w.Body.String())
+}
+
+func TestNetworkFavorites(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	r := setupRouter()
+	loved, _ := createArticleWithUser("Loved Article", "loved-article")
+	liked, _ := createArticleWithUser("Liked Article", "liked-article")
+	unseen, _ := createArticleWithUser("Unseen Article", "unseen-article")
+	viewer := createTestUser()
+	followeeA := createTestUser()
+	followeeB := createTestUser()
+	stranger := createTestUser()
+	asserts.NoError(followUser(viewer, followeeA))
+	asserts.NoError(followUser(viewer, followeeB))
+
+	asserts.NoError(liked.favoriteBy(GetArticleUserModel(followeeA)))
+	asserts.NoError(loved.favoriteBy(GetArticleUserModel(followeeA)))
+	asserts.NoError(loved.favoriteBy(GetArticleUserModel(followeeB)))
+	asserts.NoError(unseen.favoriteBy(GetArticleUserModel(stranger)))
+
+	models, err := NetworkFavorites(viewer.ID, 20)
+	asserts.NoError(err)
+	slugs := make([]string, len(models))
+	for i, model := range models {
+		slugs[i] = model.Slug
+	}
+	asserts.Equal([]string{"loved-article", "liked-article"}, slugs, "Articles favorited by more followees should rank first")
+
+	models, err = NetworkFavorites(viewer.ID, 1)
+	asserts.NoError(err)
+	asserts.Len(models, 1)
+
+	req, _ := http.NewRequest("GET", "/api/articles/network-favorites?limit=20", nil)
+	common.HeaderTokenMock(req, viewer.ID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Regexp(`"slug":"loved-article".*"slug":"liked-article".*"articlesCount":2`, w.Body.String())
+
+	req, _ = http.NewRequest("GET", "/api/articles/network-favorites", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusUnauthorized, w.Code)
+}
+
+func TestFavoriteTimeline(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	r := setupRouter()
+	article, _ := createArticleWithUser("Timeline Article", "timeline-article")
+	for _, day := range []string{"2024-03-11", "2024-03-14", "2024-03-14", "2024-03-18"} {
+		createdAt, _ := time.Parse("2006-01-02 15:04", day+" 10:30")
+		fan := GetArticleUserModel(createTestUser())
+		asserts.NoError(test_db.Create(&FavoriteModel{
+			Model:        gorm.Model{CreatedAt: createdAt},
+			FavoriteID:   article.ID,
+			FavoriteByID: fan.ID,
+		}).Error)
+	}
+
+	days, err := FavoriteTimeline(article.ID, "day")
+	asserts.NoError(err)
+	asserts.Equal([]TimeBucket{{"2024-03-11", 1}, {"2024-03-14", 2}, {"2024-03-18", 1}}, days)
+
+	weeks, err := FavoriteTimeline(article.ID, "week")
+	asserts.NoError(err)
+	asserts.Equal([]TimeBucket{{"2024-03-11", 3}, {"2024-03-18", 1}}, weeks)
+
+	req, _ := http.NewRequest("GET", "/api/articles/timeline-article/favorites/timeline?bucket=week", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal(`{"timeline":[{"bucket":"2024-03-11","count":3},{"bucket":"2024-03-18","count":1}]}`, w.Body.String())
+
+	req, _ = http.NewRequest("GET", "/api/articles/timeline-article/favorites/timeline?bucket=year", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusUnprocessableEntity, w.Code)
+
+	req, _ = http.NewRequest("GET", "/api/articles/missing-article/favorites/timeline", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusNotFound, w.Code)
+}
+
+func TestArticleListMinFavorites(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	r := setupRouter()
+	fans := make([]ArticleUserModel, 3)
+	for i := range fans {
+		fans[i] = GetArticleUserModel(createTestUser())
+	}
+	for i, slug := range []string{"no-favorites", "one-favorite", "two-favorites", "three-favorites"} {
+		article, _ := createArticleWithUser(slug, slug)
+		if slug == "three-favorites" {
+			asserts.NoError(article.setTags([]string{"popular"}))
+			asserts.NoError(SaveOne(&article))
+		}
+		for _, fan := range fans[:i] {
+			asserts.NoError(article.favoriteBy(fan))
+		}
+	}
+
+	filter := NewArticleListFilter("", "", "", "", "")
+	filter.MinFavorites = 2
+	models, count, err := FindManyArticleWithFilter(filter)
+	asserts.NoError(err)
+	asserts.Equal(2, count)
+	asserts.ElementsMatch([]string{"two-favorites", "three-favorites"}, []string{models[0].Slug, models[1].Slug})
+
+	filter.Tag = "popular"
+	models, count, err = FindManyArticleWithFilter(filter)
+	asserts.NoError(err)
+	asserts.Equal(1, count, "minFavorites should compose with the tag filter")
+	asserts.Equal("three-favorites", models[0].Slug)
+
+	req, _ := http.NewRequest("GET", "/api/articles?minFavorites=1", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Contains(w.Body.String(), `"articlesCount":3`)
+	asserts.NotContains(w.Body.String(), `"slug":"no-favorites"`)
+}
+
+func TestDeleteUnusedTags(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	r := setupRouter()
+	article, _ := createArticleWithUser("Tagged Article", "tagged-article")
+	asserts.NoError(article.setTags([]string{"used", "stale"}))
+	asserts.NoError(SaveOne(&article))
+	test_db.Create(&TagModel{Tag: "orphan"})
+	test_db.Create(&TagModel{Tag: "forgotten"})
+	_, err := CreateTags([]string{"seeded"})
+	asserts.NoError(err)
+	subscribed := TagModel{Tag: "subscribed"}
+	test_db.Create(&subscribed)
+	asserts.NoError(SubscribeTag(createTestUser().ID, subscribed))
+	// Dropping a tag from the article leaves it unused
+	asserts.NoError(article.setTags([]string{"used"}))
+	asserts.NoError(test_db.Model(&article).Association("Tags").Replace(article.Tags))
+
+	admin := createAdminUser()
+	req, _ := http.NewRequest("DELETE", "/api/admin/tags/unused", nil)
+	common.HeaderTokenMock(req, admin.ID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal(`{"deleted":3}`, w.Body.String())
+
+	var remaining []string
+	asserts.NoError(test_db.Model(&TagModel{}).Order("tag").Pluck("tag", &remaining).Error)
+	asserts.Equal([]string{"seeded", "subscribed", "used"}, remaining, "Seeded and subscribed tags should be kept")
+
+	deleted, err := DeleteUnusedTags()
+	asserts.NoError(err)
+	asserts.Equal(0, deleted)
+
+	// A removed tag can be created again
+	asserts.NoError(article.setTags([]string{"used", "orphan"}))
+	asserts.Len(article.Tags, 2)
+}
+
+func TestArticleHistory(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	r := setupRouter()
+	article, author := createArticleWithUser("History Article", "history-article")
+
+	for _, body := range []string{"First edit", "Second edit"} {
+		req, _ := http.NewRequest("PUT", "/api/articles/history-article", bytes.NewBufferString(fmt.Sprintf(`{"article":{"body":"%s"}}`, body)))
+		req.Header.Set("Content-Type", "application/json")
+		common.HeaderTokenMock(req, author.ID)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		asserts.Equal(http.StatusOK, w.Code)
+	}
+	// Locking comments is not an edit
+	asserts.NoError(article.setCommentsLocked(true))
+
+	revisions, err := ListRevisions(article.ID)
+	asserts.NoError(err)
+	asserts.Len(revisions, 3, "The first edit should keep the content from before it")
+	asserts.Equal(1, revisions[0].Version)
+	asserts.Equal("Test Body", revisions[0].Body)
+	asserts.Equal(3, revisions[2].Version)
+
+	req, _ := http.NewRequest("GET", "/api/articles/history-article/history", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Regexp(`^\{"history":\[\{"version":1,"updatedAt":"[^"]+"\},\{"version":2,"updatedAt":"[^"]+"\},\{"version":3,"updatedAt":"[^"]+"\}\]\}$`, w.Body.String())
+
+	req, _ = http.NewRequest("GET", "/api/articles/missing-article/history", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusNotFound, w.Code)
+}
+
+func TestArticleRevert(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	r := setupRouter()
+	article, author := createArticleWithUser("Revert Article", "revert-article")
+	other := createTestUser()
+
+	asserts.NoError(article.Update(ArticleModel{Description: "First description", Body: "First body"}))
+	asserts.NoError(article.Update(ArticleModel{Description: "Second description", Body: "Second body"}))
+
+	revert := func(version string, userID uint) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/api/articles/revert-article/revert/"+version, nil)
+		common.HeaderTokenMock(req, userID)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	asserts.Equal(http.StatusForbidden, revert("2", other.ID).Code)
+	asserts.Equal(http.StatusNotFound, revert("9", author.ID).Code, "Unknown version should return 404")
+	asserts.Equal(http.StatusNotFound, revert("latest", author.ID).Code)
+
+	w := revert("2", author.ID)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Contains(w.Body.String(), `"description":"First description","body":"First body"`)
+	reverted, err := FindOneArticle(&ArticleModel{Slug: "revert-article"})
+	asserts.NoError(err)
+	asserts.Equal("Revert Article", reverted.Title)
+	asserts.Equal("First body", reverted.Body)
+
+	revisions, err := ListRevisions(article.ID)
+	asserts.NoError(err)
+	asserts.Len(revisions, 4, "The revert should be recorded as a revision")
+	asserts.Equal("First body", revisions[3].Body)
+
+	// Version 4 holds the current content already
+	asserts.NoError(RevertArticle(article.ID, 4))
+	revisions, _ = ListRevisions(article.ID)
+	asserts.Len(revisions, 4, "Reverting to the current content should be a no-op")
+}
+
+func TestArticleRevertToCreated(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	r := setupRouter()
+	author := createTestUser()
+
+	send := func(method, url, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		common.HeaderTokenMock(req, author.ID)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("POST", "/api/articles", `{"article":{"title":"Revert To Created","description":"Original description","body":"Original body"}}`)
+	asserts.Equal(http.StatusCreated, w.Code)
+	created, err := FindOneArticle(&ArticleModel{Slug: "revert-to-created"})
+	asserts.NoError(err)
+	revisions, err := ListRevisions(created.ID)
+	asserts.NoError(err)
+	asserts.Len(revisions, 1, "Creating an article should record revision 1")
+
+	w = send("PUT", "/api/articles/revert-to-created", `{"article":{"body":"Edited body"}}`)
+	asserts.Equal(http.StatusOK, w.Code)
+
+	w = send("POST", "/api/articles/revert-to-created/revert/1", "")
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Contains(w.Body.String(), `"description":"Original description","body":"Original body"`)
+	revisions, _ = ListRevisions(created.ID)
+	asserts.Len(revisions, 3)
+	asserts.Equal("Original body", revisions[2].Body)
+}
+
+func TestWritingStats(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	r := setupRouter()
+	first, writer := createArticleWithUser("Stats One", "stats-one")
+	asserts.NoError(first.Update(ArticleModel{Body: "one two three"}))
+	second := ArticleModel{Slug: "stats-two", Title: "Stats Two", Body: "four  five\nsix seven", AuthorID: first.AuthorID}
+	asserts.NoError(SaveOne(&second))
+	writerArticleUser := GetArticleUserModel(writer)
+	test_db.Create(&CommentModel{ArticleID: first.ID, AuthorID: writerArticleUser.ID, Body: "self reply"})
+	for i := 0; i < 3; i++ {
+		asserts.NoError(first.favoriteBy(GetArticleUserModel(createTestUser())))
+	}
+
+	stats, err := WritingStats(writer.ID)
+	asserts.NoError(err)
+	asserts.Equal(UserWritingStats{Words: 7, Articles: 2, Comments: 1, AverageFavorites: 1.5}, stats)
+
+	fresh := createTestUser()
+	stats, err = WritingStats(fresh.ID)
+	asserts.NoError(err)
+	asserts.Equal(UserWritingStats{}, stats, "A user without articles should get zeros")
+
+	req, _ := http.NewRequest("GET", "/api/user/writing-stats", nil)
+	common.HeaderTokenMock(req, fresh.ID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal(`{"writingStats":{"words":0,"articles":0,"comments":0,"averageFavorites":0}}`, w.Body.String())
+}
+
+func TestLatestPerAuthor(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	r := setupRouter()
+	older, prolific := createArticleWithUser("Older Article", "older-article")
+	newer := ArticleModel{Slug: "newer-article", Title: "Newer Article", Body: "Body", AuthorID: older.AuthorID}
+	asserts.NoError(SaveOne(&newer))
+	test_db.Model(&older).UpdateColumn("created_at", time.Now().Add(-time.Hour))
+	_, single := createArticleWithUser("Only Article", "only-article")
+	createArticleWithUser("Unfollowed Article", "unfollowed-article")
+
+	viewer := createTestUser()
+	asserts.NoError(followUser(viewer, prolific))
+	asserts.NoError(followUser(viewer, single))
+
+	models, err := LatestPerAuthor(viewer.ID)
+	asserts.NoError(err)
+	slugs := make([]string, len(models))
+	for i, model := range models {
+		slugs[i] = model.Slug
+	}
+	asserts.ElementsMatch([]string{"newer-article", "only-article"}, slugs, "Only the newest article of each followee should appear")
+
+	req, _ := http.NewRequest("GET", "/api/articles/feed/latest-per-author", nil)
+	common.HeaderTokenMock(req, viewer.ID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Contains(w.Body.String(), `"articlesCount":2`)
+	asserts.NotContains(w.Body.String(), "older-article")
+
+	req, _ = http.NewRequest("GET", "/api/articles/feed/latest-per-author", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusUnauthorized, w.Code)
+}
+
+func TestFeedFavoriteFlagsBatched(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	r := setupRouter()
+	viewer := createTestUser()
+	viewerArticleUser := GetArticleUserModel(viewer)
+	fan := GetArticleUserModel(createTestUser())
+
+	feedQueries := func(articles int) (int, string) {
+		for i := 0; i < articles; i++ {
+			article, author := createArticleWithUser("Feed Article", fmt.Sprintf("feed-article-%d", common.RandInt()))
+			asserts.NoError(followUser(viewer, author))
+			asserts.NoError(article.favoriteBy(fan))
+			if i%2 == 0 {
+				asserts.NoError(article.favoriteBy(viewerArticleUser))
+			}
+		}
+		var w *httptest.ResponseRecorder
+		queries := countQueries(func() {
+			req, _ := http.NewRequest("GET", "/api/articles/feed", nil)
+			common.HeaderTokenMock(req, viewer.ID)
+			w = httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+		})
+		asserts.Equal(http.StatusOK, w.Code)
+		return queries, w.Body.String()
+	}
+
+	fewQueries, _ := feedQueries(2)
+	manyQueries, body := feedQueries(6)
+	asserts.Equal(fewQueries, manyQueries, "Feed queries should not grow with the number of articles")
+
+	var response struct {
+		Articles []ArticleResponse `json:"articles"`
+	}
+	asserts.NoError(json.Unmarshal([]byte(body), &response))
+	asserts.Len(response.Articles, 8)
+	favorited := 0
+	for _, article := range response.Articles {
+		if article.Favorite {
+			favorited++
+			asserts.Equal(uint(2), article.FavoritesCount)
+		} else {
+			asserts.Equal(uint(1), article.FavoritesCount)
+		}
+	}
+	asserts.Equal(4, favorited)
+
+	article, err := FindOneArticle(&ArticleModel{Slug: response.Articles[0].Slug})
+	asserts.NoError(err)
+	counts, status := BatchGetFavoriteSummary([]uint{article.ID}, 0)
+	asserts.Len(counts, 1)
+	asserts.Empty(status, "Anonymous viewers have no favorites")
+}
+
+func TestFavoritesTrend(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	r := setupRouter()
+	article, author := createArticleWithUser("Trend Article", "trend-article")
+	favoriteAt := func(ago time.Duration) {
+		fan := GetArticleUserModel(createTestUser())
+		asserts.NoError(test_db.Create(&FavoriteModel{
+			Model:        gorm.Model{CreatedAt: time.Now().Add(-ago)},
+			FavoriteID:   article.ID,
+			FavoriteByID: fan.ID,
+		}).Error)
+	}
+
+	// Nothing in the previous week yet
+	favoriteAt(24 * time.Hour)
+	trend, err := FavoritesTrend(author.ID, 7)
+	asserts.NoError(err)
+	asserts.Equal(1, trend.Current)
+	asserts.Equal(0, trend.Previous)
+	asserts.Nil(trend.Change, "A previous period without favorites should have no change")
+
+	favoriteAt(2 * 24 * time.Hour)
+	favoriteAt(3 * 24 * time.Hour)
+	favoriteAt(9 * 24 * time.Hour)
+	favoriteAt(10 * 24 * time.Hour)
+	favoriteAt(20 * 24 * time.Hour) // outside both periods
+	trend, err = FavoritesTrend(author.ID, 7)
+	asserts.NoError(err)
+	asserts.Equal(3, trend.Current)
+	asserts.Equal(2, trend.Previous)
+	if asserts.NotNil(trend.Change) {
+		asserts.InDelta(50.0, *trend.Change, 0.001)
+	}
+
+	req, _ := http.NewRequest("GET", "/api/user/favorites-trend?days=7", nil)
+	common.HeaderTokenMock(req, author.ID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal(`{"favoritesTrend":{"periodDays":7,"current":3,"previous":2,"change":50}}`, w.Body.String())
+
+	fresh := createTestUser()
+	req, _ = http.NewRequest("GET", "/api/user/favorites-trend", nil)
+	common.HeaderTokenMock(req, fresh.ID)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(`{"favoritesTrend":{"periodDays":7,"current":0,"previous":0,"change":null}}`, w.Body.String())
+
+	req, _ = http.NewRequest("GET", "/api/user/favorites-trend?days=0", nil)
+	common.HeaderTokenMock(req, fresh.ID)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestArticleRead(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	r := setupRouter()
+	first, author := createArticleWithUser("First Read", "first-read")
+	createArticleWithUser("Other Author Read", "other-author-read")
+	second := ArticleModel{Slug: "second-read", Title: "Second Read", Body: "Body", AuthorID: first.AuthorID}
+	asserts.NoError(SaveOne(&second))
+	reader := createTestUser()
+	asserts.NoError(followUser(reader, author))
+
+	request := func(method, url string, userID uint) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, nil)
+		if userID != 0 {
+			common.HeaderTokenMock(req, userID)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request("GET", "/api/articles/first-read", reader.ID)
+	asserts.Contains(w.Body.String(), `"read":false`)
+	w = request("GET", "/api/articles/first-read", 0)
+	asserts.NotContains(w.Body.String(), `"read"`, "Anonymous viewers should not get a read flag")
+
+	w = request("POST", "/api/articles/first-read/read", reader.ID)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Contains(w.Body.String(), `"read":true`)
+	w = request("POST", "/api/articles/first-read/read", reader.ID)
+	asserts.Equal(http.StatusOK, w.Code, "Reading twice should be fine")
+	asserts.Equal(http.StatusNotFound, request("POST", "/api/articles/missing/read", reader.ID).Code)
+
+	w = request("GET", "/api/articles/first-read", reader.ID)
+	asserts.Contains(w.Body.String(), `"read":true`)
+	w = request("GET", "/api/articles/second-read", reader.ID)
+	asserts.Contains(w.Body.String(), `"read":false`)
+
+	w = request("POST", "/api/articles/read/all", reader.ID)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal(`{"marked":1}`, w.Body.String(), "Only the unread feed article should be marked")
+
+	w = request("GET", "/api/articles/feed", reader.ID)
+	asserts.Equal(2, strings.Count(w.Body.String(), `"read":true`))
+	w = request("GET", "/api/articles/other-author-read", reader.ID)
+	asserts.Contains(w.Body.String(), `"read":false`, "Articles outside the feed stay unread")
+}
+
+func TestFeedUnread(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	r := setupRouter()
+	read, author := createArticleWithUser("Already Read", "already-read")
+	unread := ArticleModel{Slug: "still-unread", Title: "Still Unread", Body: "Body", AuthorID: read.AuthorID}
+	asserts.NoError(SaveOne(&unread))
+	reader := createTestUser()
+	asserts.NoError(followUser(reader, author))
+	asserts.NoError(read.markReadBy(reader.ID))
+
+	models, count, err := FeedUnread(reader.ID, 20, 0)
+	asserts.NoError(err)
+	asserts.Equal(1, count)
+	if asserts.Len(models, 1) {
+		asserts.Equal("still-unread", models[0].Slug)
+	}
+
+	req, _ := http.NewRequest("GET", "/api/articles/feed/unread", nil)
+	common.HeaderTokenMock(req, reader.ID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Contains(w.Body.String(), `"slug":"still-unread"`)
+	asserts.NotContains(w.Body.String(), "already-read")
+	asserts.Contains(w.Body.String(), `"articlesCount":1`)
+
+	req, _ = http.NewRequest("GET", "/api/articles/feed/unread", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusUnauthorized, w.Code)
+
+	subscribed, _ := createArticleWithUser("Subscribed Tag", "subscribed-unread")
+	asserts.NoError(subscribed.setTags([]string{"unread-subscription"}))
+	asserts.NoError(SaveOne(&subscribed))
+	tagModel, err := FindOneTag("unread-subscription")
+	asserts.NoError(err)
+	asserts.NoError(SubscribeTag(reader.ID, tagModel))
+	_, count, err = FeedUnread(reader.ID, 20, 0)
+	asserts.NoError(err)
+	asserts.Equal(2, count, "Articles of subscribed tags belong to the feed too")
+	marked, err := MarkFeedRead(reader.ID)
+	asserts.NoError(err)
+	asserts.Equal(2, marked)
+	_, count, err = FeedUnread(reader.ID, 20, 0)
+	asserts.NoError(err)
+	asserts.Equal(0, count)
+}
+
+func TestArticleRetrieveByID(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	r := setupRouter()
+	article, _ := createArticleWithUser("By Id Article", "by-id-article")
+	deleted, _ := createArticleWithUser("Deleted By Id", "deleted-by-id")
+	asserts.NoError(DeleteArticleModel(&ArticleModel{Slug: "deleted-by-id"}))
+
+	get := func(url string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", url, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	bySlug := get("/api/articles/by-id-article")
+	byID := get(fmt.Sprintf("/api/articles/id/%d", article.ID))
+	asserts.Equal(http.StatusOK, byID.Code)
+	asserts.Equal(bySlug.Body.String(), byID.Body.String(), "Both routes should render the same article")
+
+	for _, url := range []string{"/api/articles/id/999999", "/api/articles/id/abc", "/api/articles/id/0", fmt.Sprintf("/api/articles/id/%d", deleted.ID)} {
+		w := get(url)
+		asserts.Equal(http.StatusNotFound, w.Code, url)
+		asserts.Equal(`{"errors":{"articles":"Invalid id"}}`, w.Body.String())
+	}
+}
+
+func TestAuthorFans(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	r := setupRouter()
+	first, author := createArticleWithUser("Fan Article One", "fan-article-one")
+	second := ArticleModel{Slug: "fan-article-two", Title: "Fan Article Two", Body: "Body", AuthorID: first.AuthorID}
+	asserts.NoError(SaveOne(&second))
+	other, _ := createArticleWithUser("Someone Else", "someone-else")
+
+	superFan := createTestUser()
+	casualFan := createTestUser()
+	asserts.NoError(first.favoriteBy(GetArticleUserModel(casualFan)))
+	asserts.NoError(first.favoriteBy(GetArticleUserModel(superFan)))
+	asserts.NoError(second.favoriteBy(GetArticleUserModel(superFan)))
+	asserts.NoError(other.favoriteBy(GetArticleUserModel(casualFan)))
+	asserts.NoError(first.favoriteBy(GetArticleUserModel(author)))
+
+	fans, err := AuthorFans(author.ID, 20)
+	asserts.NoError(err)
+	if asserts.Len(fans, 2, "The author should not count as their own fan") {
+		asserts.Equal(superFan.Username, fans[0].Username)
+		asserts.Equal(2, fans[0].Favorites)
+		asserts.Equal(casualFan.Username, fans[1].Username)
+		asserts.Equal(1, fans[1].Favorites, "Favorites on other authors' articles should not count")
+	}
+
+	req, _ := http.NewRequest("GET", "/api/user/fans?limit=1", nil)
+	common.HeaderTokenMock(req, author.ID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal(fmt.Sprintf(`{"fans":[{"username":"%s","image":null,"favorites":2}]}`, superFan.Username), w.Body.String())
+}
+
+func TestClearFavorites(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	r := setupRouter()
+	reader := createTestUser()
+	other := createTestUser()
+	var articles []ArticleModel
+	for i := 0; i < 3; i++ {
+		article, _ := createArticleWithUser("Clear Favorite", fmt.Sprintf("clear-favorite-%d", i))
+		asserts.NoError(article.favoriteBy(GetArticleUserModel(reader)))
+		articles = append(articles, article)
+	}
+	asserts.NoError(articles[0].favoriteBy(GetArticleUserModel(other)))
+
+	req, _ := http.NewRequest("DELETE", "/api/user/favorites", nil)
+	common.HeaderTokenMock(req, reader.ID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal(`{"removed":3}`, w.Body.String())
+
+	models, count, err := FindManyArticle("", "", "", "", reader.Username)
+	asserts.NoError(err)
+	asserts.Equal(0, count)
+	asserts.Empty(models)
+	counts := BatchGetFavoriteCounts([]uint{articles[0].ID, articles[1].ID, articles[2].ID})
+	asserts.Equal(map[uint]uint{articles[0].ID: 1}, counts, "Only the other user's favorite should be counted")
+
+	removed, err := ClearFavorites(reader.ID)
+	asserts.NoError(err)
+	asserts.Equal(0, removed)
+}
+
+func TestAuthorTagBreakdown(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	r := setupRouter()
+	first, author := createArticleWithUser("Breakdown One", "breakdown-one")
+	asserts.NoError(first.setTags([]string{"golang", "testing"}))
+	asserts.NoError(SaveOne(&first))
+	second := ArticleModel{Slug: "breakdown-two", Title: "Breakdown Two", Body: "Body", AuthorID: first.AuthorID}
+	asserts.NoError(second.setTags([]string{"golang"}))
+	asserts.NoError(SaveOne(&second))
+	other, _ := createArticleWithUser("Other Breakdown", "other-breakdown")
+	asserts.NoError(other.setTags([]string{"testing", "elsewhere"}))
+	asserts.NoError(SaveOne(&other))
+
+	breakdown, err := AuthorTagBreakdown(author.ID)
+	asserts.NoError(err)
+	asserts.Equal([]TagCount{{"golang", 2}, {"testing", 1}}, breakdown)
+
+	req, _ := http.NewRequest("GET", "/api/profiles/"+author.Username+"/tag-breakdown", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal(`{"tags":[{"tag":"golang","count":2},{"tag":"testing","count":1}]}`, w.Body.String())
+
+	req, _ = http.NewRequest("GET", "/api/profiles/nobody-here/tag-breakdown", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusNotFound, w.Code)
+}
+
+func TestFindCommentsByAuthor(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	r := setupRouter()
+	article, _ := createArticleWithUser("Commented Article", "commented-article")
+	gone, _ := createArticleWithUser("Gone Article", "gone-article")
+	commenter := GetArticleUserModel(createTestUser())
+	someone := GetArticleUserModel(createTestUser())
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		test_db.Create(&CommentModel{
+			Model:     gorm.Model{CreatedAt: now.Add(time.Duration(i) * time.Minute)},
+			ArticleID: article.ID,
+			AuthorID:  commenter.ID,
+			Body:      fmt.Sprintf("comment %d", i),
+		})
+	}
+	test_db.Create(&CommentModel{ArticleID: article.ID, AuthorID: someone.ID, Body: "not mine"})
+	test_db.Create(&CommentModel{ArticleID: gone.ID, AuthorID: commenter.ID, Body: "on a deleted article"})
+	asserts.NoError(DeleteArticleModel(&ArticleModel{Slug: "gone-article"}))
+
+	comments, count, err := FindCommentsByAuthor(commenter.UserModelID, 2, 0)
+	asserts.NoError(err)
+	asserts.Equal(3, count)
+	if asserts.Len(comments, 2) {
+		asserts.Equal("comment 2", comments[0].Body, "Newest comment should come first")
+		asserts.Equal("comment 1", comments[1].Body)
+	}
+	comments, _, err = FindCommentsByAuthor(commenter.UserModelID, 2, 2)
+	asserts.NoError(err)
+	if asserts.Len(comments, 1) {
+		asserts.Equal("comment 0", comments[0].Body)
+	}
+
+	req, _ := http.NewRequest("GET", "/api/user/comments?limit=1&offset=1", nil)
+	common.HeaderTokenMock(req, commenter.UserModelID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Regexp(`^\{"comments":\[\{"id":\d+,"body":"comment 1",.*"article":\{"slug":"commented-article","title":"Commented Article"\}\}\],"commentsCount":3\}$`, w.Body.String())
+}
+
+func TestPrivateMode(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	createArticleWithUser("Private Article", "private-article")
+	reader := createTestUser()
+	os.Setenv("PRIVATE_MODE", "true")
+	defer os.Unsetenv("PRIVATE_MODE")
+
+	r := gin.New()
+	v1 := r.Group("/api")
+	v1.Use(users.AuthMiddleware(false), common.PrivateModeMiddleware())
+	ArticlesAnonymousRegister(v1.Group("/articles"))
+	TagsAnonymousRegister(v1.Group("/tags"))
+
+	for _, url := range []string{"/api/articles", "/api/articles/private-article", "/api/tags"} {
+		req, _ := http.NewRequest("GET", url, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		asserts.Equal(http.StatusUnauthorized, w.Code, url)
+	}
+
+	req, _ := http.NewRequest("GET", "/api/articles", nil)
+	common.HeaderTokenMock(req, reader.ID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Contains(w.Body.String(), "private-article")
+
+	// Public mode is the default
+	os.Unsetenv("PRIVATE_MODE")
+	r = gin.New()
+	v1 = r.Group("/api")
+	v1.Use(users.AuthMiddleware(false), common.PrivateModeMiddleware())
+	ArticlesAnonymousRegister(v1.Group("/articles"))
+	req, _ = http.NewRequest("GET", "/api/articles", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+}
+
+func TestAdminArticleList(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	r := setupRouter()
+	createArticleWithUser("Kept Article", "kept-article")
+	createArticleWithUser("Removed Article", "removed-article")
+	asserts.NoError(DeleteArticleModel(&ArticleModel{Slug: "removed-article"}))
+	admin := createAdminUser()
+	member := createTestUser()
+
+	get := func(url string, userID uint) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", url, nil)
+		common.HeaderTokenMock(req, userID)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/api/admin/articles?includeDeleted=true", admin.ID)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Contains(w.Body.String(), `"articlesCount":2`)
+	asserts.Regexp(`"slug":"removed-article".*"deletedAt":"\d{4}-\d{2}-\d{2}T[^"]+"`, w.Body.String())
+	asserts.Regexp(`"slug":"kept-article".*"deletedAt":null`, w.Body.String())
+
+	w = get("/api/admin/articles", admin.ID)
+	asserts.Contains(w.Body.String(), `"articlesCount":1`)
+	asserts.NotContains(w.Body.String(), "removed-article")
+
+	w = get("/api/admin/articles?includeDeleted=true", member.ID)
+	asserts.Equal(http.StatusForbidden, w.Code)
+
+	w = get("/api/articles", member.ID)
+	asserts.NotContains(w.Body.String(), "removed-article", "The public list should not show deleted articles")
+}
+
+func TestReslugAll(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	r := setupRouter()
+	createArticleWithUser("Hello World", "hello-world")
+	createArticleWithUser("Hello World", "hello-world-copy")
+	createArticleWithUser("Already", "already")
+	admin := createAdminUser()
+
+	os.Setenv("SLUG_SEPARATOR", "_")
+	defer os.Unsetenv("SLUG_SEPARATOR")
+	asserts.Equal("hello_world", MakeSlug("Hello World"))
+
+	req, _ := http.NewRequest("POST", "/api/admin/reslug", nil)
+	common.HeaderTokenMock(req, admin.ID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal(`{"changed":2}`, w.Body.String())
+
+	for _, slug := range []string{"hello_world", "hello_world_2", "already"} {
+		_, err := FindOneArticle(&ArticleModel{Slug: slug})
+		asserts.NoError(err, slug)
+	}
+
+	req, _ = http.NewRequest("GET", "/api/articles/hello-world-copy?include=comments", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusMovedPermanently, w.Code)
+	asserts.Equal("/api/articles/hello_world_2?include=comments", w.Header().Get("Location"))
+
+	changed, err := ReslugAll()
+	asserts.NoError(err)
+	asserts.Equal(0, changed, "Reslugging twice should change nothing")
+
+	// Going back to the default separator brings the old slugs back into use
+	os.Unsetenv("SLUG_SEPARATOR")
+	changed, err = ReslugAll()
+	asserts.NoError(err)
+	asserts.Equal(2, changed)
+	_, err = FindOneArticle(&ArticleModel{Slug: "hello-world"})
+	asserts.NoError(err)
+	redirect, err := FindSlugRedirect("hello_world")
+	asserts.NoError(err)
+	asserts.Equal("hello-world", redirect)
+
+	// A slug given up later in the same run is free for an earlier article
+	earlier, _ := createArticleWithUser("Freed Slug", "freed-slug-earlier")
+	later, _ := createArticleWithUser("Moving Away", "freed-slug")
+	changed, err = ReslugAll()
+	asserts.NoError(err)
+	asserts.Equal(2, changed)
+	reloaded, err := FindOneArticle(&ArticleModel{Model: gorm.Model{ID: earlier.ID}})
+	asserts.NoError(err)
+	asserts.Equal("freed-slug", reloaded.Slug)
+	reloaded, err = FindOneArticle(&ArticleModel{Model: gorm.Model{ID: later.ID}})
+	asserts.NoError(err)
+	asserts.Equal("moving-away", reloaded.Slug)
+	_, err = FindSlugRedirect("freed-slug")
+	asserts.Error(err, "A slug in use should not redirect elsewhere")
+	changed, err = ReslugAll()
+	asserts.NoError(err)
+	asserts.Equal(0, changed)
+}
+
+func TestArticleUpdateSlug(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	r := setupRouter()
+	article, author := createArticleWithUser("Original Title", "original-title")
+	createArticleWithUser("Taken Title", "taken-title")
+
+	update := func(slug, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("PUT", "/api/articles/"+slug, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		common.HeaderTokenMock(req, author.ID)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	redirect := func(slug string) string {
+		req, _ := http.NewRequest("GET", "/api/articles/"+slug, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		asserts.Equal(http.StatusMovedPermanently, w.Code, slug)
+		return w.Header().Get("Location")
+	}
+
+	w := update("original-title", `{"article":{"title":"Taken Title"}}`)
+	asserts.Equal(http.StatusOK, w.Code, "A title another article has should not fail the update")
+	asserts.Contains(w.Body.String(), `"slug":"taken-title-2"`)
+	asserts.Equal("/api/articles/taken-title-2", redirect("original-title"))
+
+	w = update("taken-title-2", `{"article":{"body":"Edited body"}}`)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Contains(w.Body.String(), `"slug":"taken-title-2"`, "An unchanged title should keep the slug")
+
+	w = update("taken-title-2", `{"article":{"title":"Original Title"}}`)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Contains(w.Body.String(), `"slug":"original-title"`)
+	asserts.Equal("/api/articles/original-title", redirect("taken-title-2"))
+	_, err := FindSlugRedirect("original-title")
+	asserts.Error(err, "A slug back in use should not redirect")
+
+	reloaded, err := FindOneArticle(&ArticleModel{Model: gorm.Model{ID: article.ID}})
+	asserts.NoError(err)
+	asserts.Equal("original-title", reloaded.Slug)
+}
+
+func TestArticleLatest(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDB()
+	r := setupRouter()
+	get := func() *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/api/articles/latest", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get()
+	asserts.Equal(http.StatusNotFound, w.Code)
+	asserts.Equal(`{"errors":{"articles":"No articles yet"}}`, w.Body.String())
+
+	older, _ := createArticleWithUser("Older Latest", "older-latest")
+	test_db.Model(&older).UpdateColumn("created_at", time.Now().Add(-time.Hour))
+	createArticleWithUser("Newest Latest", "newest-latest")
+	createArticleWithUser("Deleted Latest", "deleted-latest")
+	asserts.NoError(DeleteArticleModel(&ArticleModel{Slug: "deleted-latest"}))
+
+	w = get()
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Contains(w.Body.String(), `"slug":"newest-latest"`)
+}
+
+func TestRelatedTags(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	r := setupRouter()
+	for i, tags := range [][]string{
+		{"go", "web", "testing"},
+		{"go", "web"},
+		{"go", "cli"},
+		{"rust", "cli"},
+	} {
+		article, _ := createArticleWithUser("Related Tags", fmt.Sprintf("related-tags-%d", i))
+		asserts.NoError(article.setTags(tags))
+		asserts.NoError(SaveOne(&article))
+	}
+
+	related, err := RelatedTags("go", 10)
+	asserts.NoError(err)
+	asserts.Equal([]TagCount{{"web", 2}, {"cli", 1}, {"testing", 1}}, related)
+
+	related, err = RelatedTags("unknown", 10)
+	asserts.NoError(err)
+	asserts.Empty(related)
+
+	req, _ := http.NewRequest("GET", "/api/tags/go/related?limit=1", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal(`{"tags":[{"tag":"web","count":2}]}`, w.Body.String())
+}
+
+func TestArticleFeedCountEndpoint(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	reader := createTestUser()
+	writer := createTestUser()
+	asserts.NoError(followUser(reader, writer))
+	writerArticleUser := GetArticleUserModel(writer)
+	base := time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		article := ArticleModel{
+			Slug:        fmt.Sprintf("feed-count-%d-%d", i, common.RandInt()),
+			Title:       "Feed Count",
+			Description: "Test Description",
+			Body:        "Test Body",
+			Author:      writerArticleUser,
+			AuthorID:    writerArticleUser.ID,
+		}
+		asserts.NoError(SaveOne(&article))
+		test_db.Model(&article).UpdateColumn("created_at", base.AddDate(0, 0, i*10))
+	}
+	createArticleWithUser("Not Followed", fmt.Sprintf("feed-count-stranger-%d", common.RandInt()))
+
+	count := func(query string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/api/articles/feed/count"+query, nil)
+		common.HeaderTokenMock(req, reader.ID)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := count("")
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal(`{"articlesCount":3}`, w.Body.String())
+
+	w = count("?since=2024-06-05T00:00:00Z")
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal(`{"articlesCount":2}`, w.Body.String())
+
+	w = count("?since=yesterday")
+	asserts.Equal(http.StatusUnprocessableEntity, w.Code, "Invalid timestamps should be rejected")
+
+	req, _ := http.NewRequest("GET", "/api/articles/feed/count", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusUnauthorized, w.Code)
+}
+
+func TestTagsForArticles(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	r := setupRouter()
+	var ids []uint
+	for i, tags := range [][]string{{"go", "web"}, {"web", "api"}, {"rust"}} {
+		article, _ := createArticleWithUser("Tag Chips", fmt.Sprintf("tag-chips-%d", i))
+		asserts.NoError(article.setTags(tags))
+		asserts.NoError(SaveOne(&article))
+		ids = append(ids, article.ID)
+	}
+
+	tags, err := TagsForArticles(ids[:2])
+	asserts.NoError(err)
+	asserts.Equal([]string{"api", "go", "web"}, tags)
+
+	tags, err = TagsForArticles(nil)
+	asserts.NoError(err)
+	asserts.Empty(tags)
+
+	padded := make([]uint, maxTagsForArticlesIDs)
+	padded = append(padded, ids[2])
+	tags, err = TagsForArticles(padded)
+	asserts.NoError(err)
+	asserts.Empty(tags, "ids beyond the clamp should be ignored")
+
+	body := fmt.Sprintf(`{"ids":[%d,%d]}`, ids[0], ids[2])
+	req, _ := http.NewRequest("POST", "/api/tags/for-articles", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal(`{"tags":["go","rust","web"]}`, w.Body.String())
+
+	req, _ = http.NewRequest("POST", "/api/tags/for-articles", bytes.NewBufferString(`{"ids":[]}`))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestArticlePermissions(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	article, author := createArticleWithUser("Permissions", fmt.Sprintf("permissions-%d", common.RandInt()))
+	stranger := createTestUser()
+	admin := createAdminUser()
+
+	asserts.Equal(Permissions{CanEdit: true, CanDelete: true, CanComment: true}, ArticlePermissions(article, author))
+	asserts.Equal(Permissions{CanComment: true}, ArticlePermissions(article, stranger))
+	asserts.Equal(Permissions{CanDelete: true, CanComment: true}, ArticlePermissions(article, admin))
+	asserts.Equal(Permissions{}, ArticlePermissions(article, users.UserModel{}))
+
+	asserts.NoError(article.setCommentsLocked(true))
+	req, _ := http.NewRequest("GET", "/api/articles/"+article.Slug+"/permissions", nil)
+	common.HeaderTokenMock(req, author.ID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal(`{"canEdit":true,"canDelete":true,"canComment":false}`, w.Body.String())
+
+	req, _ = http.NewRequest("GET", "/api/articles/"+article.Slug+"/permissions", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal(`{"canEdit":false,"canDelete":false,"canComment":false}`, w.Body.String())
+
+	req, _ = http.NewRequest("GET", "/api/articles/missing-permissions-slug/permissions", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusNotFound, w.Code)
+
+	req, _ = http.NewRequest("PUT", "/api/articles/"+article.Slug, bytes.NewBufferString(`{"article":{"body":"moderated"}}`))
+	req.Header.Set("Content-Type", "application/json")
+	common.HeaderTokenMock(req, admin.ID)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusForbidden, w.Code, "Admins should not edit other authors' articles")
+
+	req, _ = http.NewRequest("DELETE", "/api/articles/"+article.Slug, nil)
+	common.HeaderTokenMock(req, admin.ID)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code, "Admins should be able to delete any article")
+}
+
+func TestCommentTombstone(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	article, _ := createArticleWithUser("Tombstones", fmt.Sprintf("tombstones-%d", common.RandInt()))
+	commenter := createTestUser()
+	kept := CommentModel{ArticleID: article.ID, AuthorID: GetArticleUserModel(commenter).ID, Body: "kept comment"}
+	removed := CommentModel{ArticleID: article.ID, AuthorID: GetArticleUserModel(commenter).ID, Body: "removed comment"}
+	asserts.NoError(test_db.Create(&kept).Error)
+	asserts.NoError(test_db.Create(&removed).Error)
+
+	req, _ := http.NewRequest("DELETE", fmt.Sprintf("/api/articles/%s/comments/%d?tombstone=true", article.Slug, removed.ID), nil)
+	common.HeaderTokenMock(req, commenter.ID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+
+	req, _ = http.NewRequest("GET", "/api/articles/"+article.Slug+"/comments", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	var response struct {
+		Comments []CommentResponse `json:"comments"`
+	}
+	asserts.NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	asserts.Len(response.Comments, 2, "Tombstoned comments should stay in the thread")
+	for _, comment := range response.Comments {
+		if comment.ID == removed.ID {
+			asserts.True(comment.Deleted)
+			asserts.Empty(comment.Body)
+			asserts.Empty(comment.Author.Username, "Tombstones should not tell who wrote the comment")
+		} else {
+			asserts.False(comment.Deleted)
+			asserts.Equal("kept comment", comment.Body)
+			asserts.Equal(commenter.Username, comment.Author.Username)
+		}
+	}
+
+	req, _ = http.NewRequest("DELETE", fmt.Sprintf("/api/articles/%s/comments/%d", article.Slug, kept.ID), nil)
+	common.HeaderTokenMock(req, commenter.ID)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	var count int64
+	test_db.Model(&CommentModel{}).Where("article_id = ?", article.ID).Count(&count)
+	asserts.Equal(int64(1), count, "Plain deletes should still remove the comment")
+}
+
+func TestFindMentions(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	r := setupRouter()
+	viewer := createTestUser()
+	viewer.Username = fmt.Sprintf("odd_%%name%d", common.RandInt())
+	asserts.NoError(test_db.Model(&viewer).Update("username", viewer.Username).Error)
+	wildcardMatch := strings.NewReplacer("_", "x", "%", "yy").Replace(viewer.Username)
+
+	bodies := map[string]string{
+		"mentioned":   "Thanks @" + viewer.Username + " for the review",
+		"wildcard":    "Thanks @" + wildcardMatch + " for the review",
+		"unmentioned": "Thanks " + viewer.Username + " without an at sign",
+	}
+	articles := map[string]ArticleModel{}
+	for name, body := range bodies {
+		article, _ := createArticleWithUser("Mentions", "mentions-"+name)
+		asserts.NoError(test_db.Model(&article).Update("body", body).Error)
+		articles[name] = article
+	}
+
+	found, count, err := FindMentions(viewer.Username, 20, 0)
+	asserts.NoError(err)
+	asserts.Equal(1, count, "LIKE wildcards in the username should match literally")
+	asserts.Len(found, 1)
+	asserts.Equal(articles["mentioned"].ID, found[0].ID)
+
+	req, _ := http.NewRequest("GET", "/api/user/mentions?limit=20", nil)
+	common.HeaderTokenMock(req, viewer.ID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Contains(w.Body.String(), `"slug":"mentions-mentioned"`)
+	asserts.Contains(w.Body.String(), `"articlesCount":1`)
+
+	req, _ = http.NewRequest("GET", "/api/user/mentions", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusUnauthorized, w.Code)
+}
+
+func TestBatchCountArticlesByAuthor(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	_, prolific := createArticleWithUser("Counted", fmt.Sprintf("counted-%d", common.RandInt()))
+	prolificArticleUser := GetArticleUserModel(prolific)
+	for i := 0; i < 2; i++ {
+		asserts.NoError(SaveOne(&ArticleModel{
+			Slug:     fmt.Sprintf("counted-more-%d-%d", i, common.RandInt()),
+			Title:    "Counted",
+			Body:     "Test Body",
+			Author:   prolificArticleUser,
+			AuthorID: prolificArticleUser.ID,
+		}))
+	}
+	idle := createTestUser()
+
+	counts := BatchCountArticlesByAuthor([]string{prolific.Username, idle.Username, "ghost-user"})
+	asserts.Equal(map[string]int{prolific.Username: 3, idle.Username: 0, "ghost-user": 0}, counts)
+
+	body := fmt.Sprintf(`{"usernames":["%s","ghost-user"]}`, prolific.Username)
+	req, _ := http.NewRequest("POST", "/api/profiles/article-counts", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.JSONEq(fmt.Sprintf(`{"%s":3,"ghost-user":0}`, prolific.Username), w.Body.String())
+
+	req, _ = http.NewRequest("POST", "/api/profiles/article-counts", bytes.NewBufferString(`{"usernames":[]}`))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestProfileWithArticles(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	_, author := createArticleWithUser("Profile Article", fmt.Sprintf("profile-article-%d", common.RandInt()))
+	authorArticleUser := GetArticleUserModel(author)
+	for i := 0; i < 2; i++ {
+		asserts.NoError(SaveOne(&ArticleModel{
+			Slug:     fmt.Sprintf("profile-article-more-%d-%d", i, common.RandInt()),
+			Title:    "Profile Article",
+			Body:     "Test Body",
+			Author:   authorArticleUser,
+			AuthorID: authorArticleUser.ID,
+		}))
+	}
+
+	retrieve := func(query string) map[string]json.RawMessage {
+		req, _ := http.NewRequest("GET", "/api/profiles/"+author.Username+query, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		asserts.Equal(http.StatusOK, w.Code)
+		var response struct {
+			Profile map[string]json.RawMessage `json:"profile"`
+		}
+		asserts.NoError(json.Unmarshal(w.Body.Bytes(), &response))
+		return response.Profile
+	}
+
+	profile := retrieve("")
+	asserts.Equal(`"`+author.Username+`"`, string(profile["username"]))
+	asserts.NotContains(profile, "articles", "Profiles should not embed articles by default")
+
+	profile = retrieve("?includeArticles=true&limit=2")
+	asserts.Equal(`"`+author.Username+`"`, string(profile["username"]))
+	var embedded []ArticleResponse
+	asserts.NoError(json.Unmarshal(profile["articles"], &embedded))
+	asserts.Len(embedded, 2)
+	asserts.Equal(author.Username, embedded[0].Author.Username)
+
+	profile = retrieve("?includeArticles=true")
+	asserts.NoError(json.Unmarshal(profile["articles"], &embedded))
+	asserts.Len(embedded, 3)
+}
+
+func TestArticleListHotSort(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	r := setupRouter()
+	old, _ := createArticleWithUser("Old Popular", "hot-old-popular")
+	fresh, _ := createArticleWithUser("Fresh", "hot-fresh")
+	stale, _ := createArticleWithUser("Stale", "hot-stale")
+	test_db.Model(&old).UpdateColumn("created_at", time.Now().Add(-48*time.Hour))
+	test_db.Model(&fresh).UpdateColumn("created_at", time.Now().Add(-1*time.Hour))
+	test_db.Model(&stale).UpdateColumn("created_at", time.Now().Add(-24*time.Hour))
+	for i := 0; i < 10; i++ {
+		fan := GetArticleUserModel(createTestUser())
+		asserts.NoError(old.favoriteBy(fan))
+		if i < 3 {
+			asserts.NoError(fresh.favoriteBy(fan))
+		}
+	}
+
+	os.Setenv("HOT_GRAVITY", "1.8")
+	defer os.Unsetenv("HOT_GRAVITY")
+	articleModels, count, err := FindManyArticleWithFilter(ArticleListFilter{Sort: ArticleSortHot, Limit: 10})
+	asserts.NoError(err)
+	asserts.Equal(3, count)
+	asserts.Equal([]string{"hot-fresh", "hot-old-popular", "hot-stale"},
+		[]string{articleModels[0].Slug, articleModels[1].Slug, articleModels[2].Slug},
+		"A newer article with fewer favorites should outrank an older popular one")
+	asserts.Equal(old.Title, articleModels[1].Title, "Ranked articles should be fully loaded")
+
+	list := func(query string) []string {
+		req, _ := http.NewRequest("GET", "/api/articles?sort=hot"+query, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		asserts.Equal(http.StatusOK, w.Code)
+		var response struct {
+			Articles      []ArticleResponse `json:"articles"`
+			ArticlesCount int               `json:"articlesCount"`
+		}
+		asserts.NoError(json.Unmarshal(w.Body.Bytes(), &response))
+		asserts.Equal(3, response.ArticlesCount)
+		slugs := make([]string, 0, len(response.Articles))
+		for _, article := range response.Articles {
+			slugs = append(slugs, article.Slug)
+		}
+		return slugs
+	}
+	asserts.Equal([]string{"hot-fresh", "hot-old-popular", "hot-stale"}, list(""))
+	asserts.Equal([]string{"hot-old-popular"}, list("&limit=1&offset=1"))
+
+	os.Setenv("HOT_GRAVITY", "0")
+	asserts.Equal([]string{"hot-old-popular", "hot-fresh", "hot-stale"}, list(""),
+		"Without gravity favorites alone decide, equal scores keep the newest first")
+	os.Setenv("HOT_GRAVITY", "1.8")
+
+	articleModels, _, err = FindManyArticleWithFilter(ArticleListFilter{Sort: ArticleSortHot, Limit: 10, Offset: 5})
+	asserts.NoError(err)
+	asserts.Empty(articleModels)
+}
+
+func TestFlaggedCommentsQueue(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	article, author := createArticleWithUser("Moderated", fmt.Sprintf("moderated-%d", common.RandInt()))
+	_, unrelated := createArticleWithUser("Unrelated", fmt.Sprintf("unrelated-%d", common.RandInt()))
+	commenter := GetArticleUserModel(createTestUser())
+	flagged := CommentModel{ArticleID: article.ID, AuthorID: commenter.ID, Body: "rude comment"}
+	clean := CommentModel{ArticleID: article.ID, AuthorID: commenter.ID, Body: "kind comment"}
+	asserts.NoError(test_db.Create(&flagged).Error)
+	asserts.NoError(test_db.Create(&clean).Error)
+	reporter := createTestUser()
+
+	flag := func(slug string, commentID uint) int {
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/api/articles/%s/comments/%d/flag", slug, commentID),
+			bytes.NewBufferString(`{"flag":{"reason":"spam"}}`))
+		req.Header.Set("Content-Type", "application/json")
+		common.HeaderTokenMock(req, reporter.ID)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	asserts.Equal(http.StatusOK, flag(article.Slug, flagged.ID))
+	asserts.Equal(http.StatusOK, flag(article.Slug, flagged.ID), "Flagging twice should not fail")
+	asserts.Equal(http.StatusNotFound, flag("some-other-slug", flagged.ID))
+
+	queue := func(user users.UserModel) []FlaggedCommentResponse {
+		req, _ := http.NewRequest("GET", "/api/user/comments/flagged", nil)
+		common.HeaderTokenMock(req, user.ID)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		asserts.Equal(http.StatusOK, w.Code)
+		var response struct {
+			Comments []FlaggedCommentResponse `json:"comments"`
+		}
+		asserts.NoError(json.Unmarshal(w.Body.Bytes(), &response))
+		return response.Comments
+	}
+
+	authorQueue := queue(author)
+	asserts.Len(authorQueue, 1)
+	asserts.Equal(flagged.ID, authorQueue[0].ID)
+	asserts.Equal(article.Slug, authorQueue[0].Article.Slug)
+	asserts.Len(authorQueue[0].Flags, 1)
+	asserts.Equal("spam", authorQueue[0].Flags[0].Reason)
+
+	asserts.Empty(queue(unrelated), "Other authors should not see the flagged comment")
+
+	test_db.Model(&CommentFlagModel{}).Where("comment_id = ?", flagged.ID).Update("resolved", true)
+	asserts.Empty(queue(author), "Resolved flags should leave the queue")
+}
+
+func TestSlugMaxLength(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	title := "A remarkably long title about writing web services in Go that keeps going well past any sensible limit"
+	asserts.Equal("a-remarkably-long-title-about-writing-web-services-in-go-that-keeps-going-well", MakeSlug(title),
+		"Slugs should be cut at the last separator within 80 bytes")
+
+	os.Setenv("SLUG_MAX_LENGTH", "20")
+	defer os.Unsetenv("SLUG_MAX_LENGTH")
+	asserts.Equal("a-remarkably-long", MakeSlug(title))
+	asserts.Equal("short-title", MakeSlug("Short Title"))
+	asserts.Equal("supercalifragilistic", MakeSlug("Supercalifragilisticexpialidocious"), "A single long word is cut hard")
+	asserts.Equal("exactly-twenty-chars", MakeSlug("Exactly twenty chars and more"), "A separator right after the limit is a boundary")
+
+	os.Setenv("SLUG_MAX_LENGTH", "18")
+	createArticleWithUser(title, MakeSlug(title))
+	second, _ := createArticleWithUser(title, "placeholder-slug")
+	asserts.Equal("a-remarkably-long", MakeSlug(title))
+	slug, err := uniqueSlug(test_db, MakeSlug(title), second.ID)
+	asserts.NoError(err)
+	asserts.Equal("a-remarkably-2", slug, "The base should shrink at a boundary so the suffix fits")
+
+	os.Setenv("SLUG_MAX_LENGTH", "0")
+	asserts.Equal(strings.Count(MakeSlug(title), "-"), strings.Count(title, " "), "0 disables truncation")
+}
+
+func TestArticleCreateUniqueSlug(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	r := setupRouter()
+	author := createTestUser()
+	create := func(title string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"article":{"title":"%s","description":"d","body":"b"}}`, title)
+		req, _ := http.NewRequest("POST", "/api/articles", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		common.HeaderTokenMock(req, author.ID)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	prefix := "A remarkably long title about writing web services in Go that keeps going well past"
+	first := create(prefix + " the first limit")
+	asserts.Equal(http.StatusCreated, first.Code)
+	asserts.Contains(first.Body.String(), `"slug":"a-remarkably-long-title-about-writing-web-services-in-go-that-keeps-going-well"`)
+	second := create(prefix + " the second limit")
+	asserts.Equal(http.StatusCreated, second.Code, "Titles sharing the truncated prefix should both be created")
+	asserts.Contains(second.Body.String(), `"slug":"a-remarkably-long-title-about-writing-web-services-in-go-that-keeps-going-well-2"`,
+		"The suffix should fit within SLUG_MAX_LENGTH")
+
+	asserts.Equal(http.StatusCreated, create("Same Title").Code)
+	w := create("Same Title")
+	asserts.Equal(http.StatusCreated, w.Code, "An identical title should get a suffixed slug")
+	asserts.Contains(w.Body.String(), `"slug":"same-title-2"`)
+}
+
+func TestStreamArticlesCSV(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	article, author := createArticleWithUser(`Commas, "quotes" and more`, fmt.Sprintf("csv-export-%d", common.RandInt()))
+	authorArticleUser := GetArticleUserModel(author)
+	plain := ArticleModel{
+		Slug:     fmt.Sprintf("csv-export-plain-%d", common.RandInt()),
+		Title:    "Plain",
+		Body:     "Test Body",
+		Author:   authorArticleUser,
+		AuthorID: authorArticleUser.ID,
+	}
+	asserts.NoError(SaveOne(&plain))
+	createArticleWithUser("Someone else", fmt.Sprintf("csv-export-other-%d", common.RandInt()))
+	reader := GetArticleUserModel(createTestUser())
+	asserts.NoError(article.favoriteBy(reader))
+	asserts.NoError(test_db.Create(&CommentModel{ArticleID: article.ID, AuthorID: reader.ID, Body: "first"}).Error)
+	asserts.NoError(test_db.Create(&CommentModel{ArticleID: article.ID, AuthorID: reader.ID, Deleted: true}).Error)
+
+	req, _ := http.NewRequest("GET", "/api/user/articles/export.csv", nil)
+	common.HeaderTokenMock(req, author.ID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal("text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	asserts.Contains(w.Body.String(), `"Commas, ""quotes"" and more"`, "Titles should be quoted and escaped")
+
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	asserts.NoError(err)
+	asserts.Len(rows, 3)
+	asserts.Equal([]string{"slug", "title", "favoritesCount", "commentsCount", "createdAt"}, rows[0])
+	asserts.Equal([]string{article.Slug, article.Title, "1", "1", article.CreatedAt.UTC().Format("2006-01-02T15:04:05.999Z")}, rows[1])
+	asserts.Equal([]string{plain.Slug, "Plain", "0", "0"}, rows[2][:4])
+}
+
+func TestArticleFavoriteStatus(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	liked, _ := createArticleWithUser("Liked", fmt.Sprintf("favorite-status-liked-%d", common.RandInt()))
+	other, _ := createArticleWithUser("Other", fmt.Sprintf("favorite-status-other-%d", common.RandInt()))
+	viewer := createTestUser()
+	asserts.NoError(liked.favoriteBy(GetArticleUserModel(viewer)))
+
+	status := func(body string, userID uint) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/api/articles/favorite-status", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if userID != 0 {
+			common.HeaderTokenMock(req, userID)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	body := fmt.Sprintf(`{"ids":[%d,%d]}`, liked.ID, other.ID)
+
+	w := status(body, viewer.ID)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.JSONEq(fmt.Sprintf(`{"statuses":{"%d":true,"%d":false}}`, liked.ID, other.ID), w.Body.String())
+
+	w = status(body, 0)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.JSONEq(fmt.Sprintf(`{"statuses":{"%d":false,"%d":false}}`, liked.ID, other.ID), w.Body.String())
+
+	ids := make([]string, 0, maxFavoriteStatusIDs+1)
+	for i := 1; i <= maxFavoriteStatusIDs+1; i++ {
+		ids = append(ids, strconv.Itoa(i))
+	}
+	w = status(`{"ids":[`+strings.Join(ids, ",")+`]}`, viewer.ID)
+	asserts.Equal(http.StatusOK, w.Code)
+	var response struct {
+		Statuses map[string]bool `json:"statuses"`
+	}
+	asserts.NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	asserts.Len(response.Statuses, maxFavoriteStatusIDs, "The id count should be clamped")
+
+	w = status(`{"ids":[]}`, viewer.ID)
+	asserts.Equal(http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestArticleCommentedStatus(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	discussed, _ := createArticleWithUser("Discussed", fmt.Sprintf("commented-status-discussed-%d", common.RandInt()))
+	other, _ := createArticleWithUser("Other", fmt.Sprintf("commented-status-other-%d", common.RandInt()))
+	viewer := createTestUser()
+	viewerArticleUser := GetArticleUserModel(viewer)
+	test_db.Create(&CommentModel{ArticleID: discussed.ID, AuthorID: viewerArticleUser.ID, Body: "first"})
+	test_db.Create(&CommentModel{ArticleID: discussed.ID, AuthorID: viewerArticleUser.ID, Body: "second"})
+	test_db.Create(&CommentModel{ArticleID: other.ID, AuthorID: viewerArticleUser.ID, Body: "removed", Deleted: true})
+
+	status := func(userID uint) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"ids":[%d,%d]}`, discussed.ID, other.ID)
+		req, _ := http.NewRequest("POST", "/api/articles/commented-status", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if userID != 0 {
+			common.HeaderTokenMock(req, userID)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := status(viewer.ID)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.JSONEq(fmt.Sprintf(`{"statuses":{"%d":true,"%d":false}}`, discussed.ID, other.ID), w.Body.String(),
+		"Only live comments should mark an article as commented")
+
+	w = status(0)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.JSONEq(fmt.Sprintf(`{"statuses":{"%d":false,"%d":false}}`, discussed.ID, other.ID), w.Body.String())
+}
+
+func TestTrendingTags(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	r := setupRouter()
+	for i, spec := range []struct {
+		tags []string
+		age  time.Duration
+	}{
+		{[]string{"go", "web"}, 24 * time.Hour},
+		{[]string{"go"}, 48 * time.Hour},
+		{[]string{"web", "rust"}, 72 * time.Hour},
+		{[]string{"rust"}, 10 * 24 * time.Hour},
+		{[]string{"rust"}, 20 * 24 * time.Hour},
+	} {
+		article, _ := createArticleWithUser("Trending", fmt.Sprintf("trending-%d", i))
+		asserts.NoError(article.setTags(spec.tags))
+		asserts.NoError(SaveOne(&article))
+		test_db.Model(&article).UpdateColumn("created_at", time.Now().Add(-spec.age))
+	}
+
+	trending, err := TrendingTags(7, 10)
+	asserts.NoError(err)
+	asserts.Equal([]TagCount{{"go", 2}, {"web", 2}, {"rust", 1}}, trending, "Old articles should not count")
+
+	trending, err = TrendingTags(30, 1)
+	asserts.NoError(err)
+	asserts.Equal([]TagCount{{"rust", 3}}, trending)
+
+	req, _ := http.NewRequest("GET", "/api/tags/trending?days=2&limit=10", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal(`{"tags":[{"tag":"go","count":1},{"tag":"web","count":1}]}`, w.Body.String())
+
+	req, _ = http.NewRequest("GET", "/api/tags/trending?days=0", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestAuthorArticleFavoriteCounts(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	popular, author := createArticleWithUser("Popular", fmt.Sprintf("favorite-counts-popular-%d", common.RandInt()))
+	authorArticleUser := GetArticleUserModel(author)
+	liked := ArticleModel{Slug: fmt.Sprintf("favorite-counts-liked-%d", common.RandInt()), Title: "Liked", Body: "Test Body", Author: authorArticleUser, AuthorID: authorArticleUser.ID}
+	ignored := ArticleModel{Slug: fmt.Sprintf("favorite-counts-ignored-%d", common.RandInt()), Title: "Ignored", Body: "Test Body", Author: authorArticleUser, AuthorID: authorArticleUser.ID}
+	asserts.NoError(SaveOne(&liked))
+	asserts.NoError(SaveOne(&ignored))
+	other, _ := createArticleWithUser("Other", fmt.Sprintf("favorite-counts-other-%d", common.RandInt()))
+	for i := 0; i < 3; i++ {
+		fan := GetArticleUserModel(createTestUser())
+		asserts.NoError(popular.favoriteBy(fan))
+		asserts.NoError(other.favoriteBy(fan))
+		if i == 0 {
+			asserts.NoError(liked.favoriteBy(fan))
+		}
+	}
+
+	expected := map[string]uint{popular.Slug: 3, liked.Slug: 1, ignored.Slug: 0}
+	asserts.Equal(expected, AuthorArticleFavoriteCounts(author.ID))
+	asserts.Empty(AuthorArticleFavoriteCounts(createTestUser().ID))
+
+	req, _ := http.NewRequest("GET", "/api/user/articles/favorite-counts", nil)
+	common.HeaderTokenMock(req, author.ID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	var counts map[string]uint
+	asserts.NoError(json.Unmarshal(w.Body.Bytes(), &counts))
+	asserts.Equal(expected, counts)
+}
+
+func TestArticleResponseUsesStoredSlug(t *testing.T) {
+	asserts := assert.New(t)
+
+	article, author := createArticleWithUser("A Title That Slugs Differently", fmt.Sprintf("stored-slug-%d", common.RandInt()))
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set("my_user_model", author)
+
+	serializer := ArticleSerializer{c, article}
+	asserts.Equal(article.Slug, serializer.Response().Slug, "The stored slug should be returned, not one derived from the title")
+	articlesSerializer := ArticlesSerializer{c, []ArticleModel{article}}
+	asserts.Equal(article.Slug, articlesSerializer.Response()[0].Slug)
+}
+
+// BenchmarkArticlesResponse serializes a page of 1000 articles. The "slug.Make" case adds the
+// per-article slug computation the serializer avoids by returning the stored slug.
+func BenchmarkArticlesResponse(b *testing.B) {
+	author := ArticleUserModel{UserModel: users.UserModel{ID: 1, Username: "bench-author"}}
+	author.ID = 1
+	articleModels := make([]ArticleModel, 1000)
+	for i := range articleModels {
+		title := fmt.Sprintf("Benchmark article number %d about serializing pages", i)
+		articleModels[i] = ArticleModel{Slug: slug.Make(title), Title: title, Body: "Test Body", Author: author, AuthorID: author.ID}
+		articleModels[i].ID = uint(i + 1)
+	}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set("my_user_model", users.UserModel{})
+	serializer := ArticlesSerializer{c, articleModels}
+
+	b.Run("stored slug", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			serializer.Response()
+		}
+	})
+	b.Run("slug.Make", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, response := range serializer.Response() {
+				response.Slug = slug.Make(response.Title)
+			}
+		}
+	})
+}
+
+func TestArticleCounts(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	article, _ := createArticleWithUser("Counted Card", fmt.Sprintf("counted-card-%d", common.RandInt()))
+	for i := 0; i < 2; i++ {
+		reader := GetArticleUserModel(createTestUser())
+		asserts.NoError(article.favoriteBy(reader))
+		asserts.NoError(test_db.Create(&CommentModel{ArticleID: article.ID, AuthorID: reader.ID, Body: "nice"}).Error)
+	}
+	asserts.NoError(test_db.Create(&CommentModel{ArticleID: article.ID, AuthorID: article.AuthorID, Body: "thanks"}).Error)
+
+	req, _ := http.NewRequest("GET", "/api/articles/"+article.Slug+"/counts", nil)
+	w := httptest.NewRecorder()
+	queries := countQueries(func() { r.ServeHTTP(w, req) })
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal(`{"commentsCount":3,"favoritesCount":2}`, w.Body.String())
+	asserts.Equal(3, queries, "The article lookup and the two counts should be the only queries")
+
+	req, _ = http.NewRequest("GET", "/api/articles/missing-counted-card/counts", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusNotFound, w.Code)
+}
+
+func TestArticleSearchRelevance(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	r := setupRouter()
+	inBody, _ := createArticleWithUser("Unrelated Heading", "search-in-body")
+	test_db.Model(&inBody).Update("body", "This one explains Goroutines in passing")
+	inTitle, _ := createArticleWithUser("Understanding goroutines", "search-in-title")
+	inDescription, _ := createArticleWithUser("Another Heading", "search-in-description")
+	test_db.Model(&inDescription).Update("description", "All about goroutines")
+	createArticleWithUser("Nothing To See", "search-no-match")
+	// The most recently updated article would come first without relevance ordering
+	test_db.Model(&inBody).UpdateColumn("updated_at", time.Now().Add(time.Hour))
+
+	articleModels, count, err := FindManyArticleWithFilter(ArticleListFilter{Search: "GOROUTINES", Limit: 10})
+	asserts.NoError(err)
+	asserts.Equal(3, count)
+	asserts.Equal([]string{"search-in-title", "search-in-description", "search-in-body"},
+		[]string{articleModels[0].Slug, articleModels[1].Slug, articleModels[2].Slug},
+		"Title matches should rank above description and body matches")
+	asserts.Equal(inTitle.ID, articleModels[0].ID)
+
+	_, count, err = FindManyArticleWithFilter(ArticleListFilter{Search: "100%", Limit: 10})
+	asserts.NoError(err)
+	asserts.Equal(0, count, "LIKE wildcards in the term should match literally")
+
+	req, _ := http.NewRequest("GET", "/api/articles?search=goroutines&limit=1", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Contains(w.Body.String(), `"slug":"search-in-title"`)
+	asserts.Contains(w.Body.String(), `"articlesCount":3`)
+}
+
+func TestPublishingCadence(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	author := createTestUser()
+	cadence, err := PublishingCadence(author.ID)
+	asserts.NoError(err)
+	asserts.Equal(Cadence{}, cadence, "No articles should give zeros")
+
+	authorArticleUser := GetArticleUserModel(author)
+	today := time.Now().UTC().Truncate(24 * time.Hour).Add(time.Hour)
+	publish := func(daysAgo int) {
+		article := ArticleModel{
+			Slug:     fmt.Sprintf("cadence-%d-%d", daysAgo, common.RandInt()),
+			Title:    "Cadence",
+			Body:     "Test Body",
+			Author:   authorArticleUser,
+			AuthorID: authorArticleUser.ID,
+		}
+		asserts.NoError(SaveOne(&article))
+		test_db.Model(&article).UpdateColumn("created_at", today.AddDate(0, 0, -daysAgo))
+	}
+
+	publish(10)
+	cadence, err = PublishingCadence(author.ID)
+	asserts.NoError(err)
+	asserts.Equal(Cadence{}, cadence, "A single old article has no gaps and no streak")
+
+	for _, daysAgo := range []int{4, 2, 1} {
+		publish(daysAgo)
+	}
+	cadence, err = PublishingCadence(author.ID)
+	asserts.NoError(err)
+	asserts.Equal(Cadence{AverageDaysBetween: 3, LongestGapDays: 6, CurrentStreakDays: 2}, cadence,
+		"Gaps of 6, 2 and 1 days, with a streak through yesterday")
+
+	publish(0)
+	req, _ := http.NewRequest("GET", "/api/profiles/"+author.Username+"/cadence", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal(`{"cadence":{"averageDaysBetween":2.5,"longestGapDays":6,"currentStreakDays":3}}`, w.Body.String())
+
+	req, _ = http.NewRequest("GET", "/api/profiles/ghost-user/cadence", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusNotFound, w.Code)
+}
+
+func TestPrivateFavorites(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	article, author := createArticleWithUser("Privately Favorited", fmt.Sprintf("privately-favorited-%d", common.RandInt()))
+	fan := createTestUser()
+	other := createTestUser()
+	followUser(other, fan)
+
+	req, _ := http.NewRequest("POST", "/api/articles/"+article.Slug+"/favorite?private=true", nil)
+	req.Header.Set("Authorization", "Token "+common.GenToken(fan.ID))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Contains(w.Body.String(), `"favoritesCount":1`, "Private favorites still count")
+
+	listFavorited := func(viewer *users.UserModel) string {
+		req, _ := http.NewRequest("GET", "/api/articles?favorited="+fan.Username, nil)
+		if viewer != nil {
+			req.Header.Set("Authorization", "Token "+common.GenToken(viewer.ID))
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		asserts.Equal(http.StatusOK, w.Code)
+		return w.Body.String()
+	}
+	asserts.Contains(listFavorited(&fan), article.Slug, "The owner sees their private favorite")
+	asserts.NotContains(listFavorited(&other), article.Slug, "Other users do not see a private favorite")
+	asserts.NotContains(listFavorited(nil), article.Slug, "Anonymous users do not see a private favorite")
+	fans, err := AuthorFans(author.ID, 10)
+	asserts.NoError(err)
+	asserts.Empty(fans, "Private favorites should not make the fan public")
+	network, err := NetworkFavorites(other.ID, 10)
+	asserts.NoError(err)
+	asserts.Empty(network, "Private favorites should not reach the fan's followers")
+
+	created, err := article.favoriteByCreated(GetArticleUserModel(fan), false)
+	asserts.NoError(err)
+	asserts.False(created, "Making a favorite public should not create a row")
+	asserts.Contains(listFavorited(&other), article.Slug, "A public favorite is visible to everyone")
+	fans, _ = AuthorFans(author.ID, 10)
+	asserts.Len(fans, 1)
+	network, _ = NetworkFavorites(other.ID, 10)
+	asserts.Len(network, 1)
+}
+
+func TestBackfillFavoritePrivacy(t *testing.T) {
+	asserts := assert.New(t)
+
+	// favorite_models as it was while private was nullable
+	type legacyFavorite struct {
+		gorm.Model
+		FavoriteID   uint
+		FavoriteByID uint
+		Private      *bool
+	}
+	legacy := test_db.Table("favorite_models")
+	asserts.NoError(test_db.Migrator().DropTable(&FavoriteModel{}))
+	asserts.NoError(legacy.AutoMigrate(&legacyFavorite{}))
+	asserts.NoError(test_db.Table("favorite_models").Create(&legacyFavorite{FavoriteID: 1, FavoriteByID: 1}).Error)
+
+	asserts.NoError(BackfillFavoritePrivacy(test_db))
+	asserts.NoError(test_db.AutoMigrate(&FavoriteModel{}), "The column should tighten once no NULL is left")
+	var public int64
+	test_db.Model(&FavoriteModel{}).Where("private = ?", false).Count(&public)
+	asserts.Equal(int64(1), public, "Favorites stored before the column was NOT NULL should be public")
+	resetDB()
+}
+
+func TestAdminCommentList(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	r := setupRouter()
+	article, _ := createArticleWithUser("Moderated Article", "moderated-article")
+	commenter := createTestUser()
+	commenterArticleUser := GetArticleUserModel(commenter)
+	for i := 0; i < 3; i++ {
+		comment := CommentModel{ArticleID: article.ID, AuthorID: commenterArticleUser.ID, Body: fmt.Sprintf("comment %d", i)}
+		asserts.NoError(test_db.Create(&comment).Error)
+		test_db.Model(&comment).UpdateColumn("created_at", time.Now().Add(time.Duration(i)*time.Minute))
+	}
+	admin := createAdminUser()
+
+	req, _ := http.NewRequest("GET", "/api/admin/comments", nil)
+	common.HeaderTokenMock(req, commenter.ID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusForbidden, w.Code, "Non-admins should not list all comments")
+
+	req, _ = http.NewRequest("GET", "/api/admin/comments?limit=2&offset=1", nil)
+	common.HeaderTokenMock(req, admin.ID)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	var response struct {
+		Comments []struct {
+			Body   string `json:"body"`
+			Author struct {
+				Username string `json:"username"`
+			} `json:"author"`
+			Article struct {
+				Slug string `json:"slug"`
+			} `json:"article"`
+		} `json:"comments"`
+		CommentsCount int `json:"commentsCount"`
+	}
+	asserts.NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	asserts.Equal(3, response.CommentsCount)
+	if asserts.Len(response.Comments, 2) {
+		asserts.Equal("comment 1", response.Comments[0].Body, "Comments should be listed newest first")
+		asserts.Equal("comment 0", response.Comments[1].Body)
+		asserts.Equal(commenter.Username, response.Comments[0].Author.Username)
+		asserts.Equal("moderated-article", response.Comments[0].Article.Slug)
+	}
+}
+
+func TestForYou(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	r := setupRouter()
+	viewer := createTestUser()
+	viewerArticleUser := GetArticleUserModel(viewer)
+	tagged := func(slug string, tags ...string) ArticleModel {
+		article, _ := createArticleWithUser(slug, slug)
+		asserts.NoError(article.setTags(tags))
+		asserts.NoError(SaveOne(&article))
+		return article
+	}
+	favorited := tagged("favorited-go-db", "go", "db")
+	asserts.NoError(favorited.favoriteBy(viewerArticleUser))
+	oneTag := tagged("one-tag", "go")
+	bothTags := tagged("both-tags", "go", "db")
+	tagged("unrelated", "cooking")
+	own := ArticleModel{Slug: "own-go-db", Title: "Own", Body: "Test Body", Author: viewerArticleUser, AuthorID: viewerArticleUser.ID}
+	asserts.NoError(SaveOne(&own))
+	asserts.NoError(own.setTags([]string{"go", "db"}))
+	asserts.NoError(SaveOne(&own))
+	newerOneTag := tagged("newer-one-tag", "db")
+	test_db.Model(&oneTag).UpdateColumn("created_at", time.Now().Add(-time.Hour))
+	test_db.Model(&newerOneTag).UpdateColumn("created_at", time.Now())
+	test_db.Model(&bothTags).UpdateColumn("created_at", time.Now().Add(-2*time.Hour))
+
+	models, err := ForYou(viewer.ID, 20)
+	asserts.NoError(err)
+	slugs := make([]string, len(models))
+	for i, model := range models {
+		slugs[i] = model.Slug
+	}
+	asserts.Equal([]string{"both-tags", "newer-one-tag", "one-tag"}, slugs,
+		"More shared tags should rank first then newer articles, without favorited, own or unrelated ones")
+
+	models, err = ForYou(createTestUser().ID, 20)
+	asserts.NoError(err)
+	asserts.Empty(models, "Users without favorites have no interests yet")
+
+	req, _ := http.NewRequest("GET", "/api/articles/for-you?limit=1", nil)
+	common.HeaderTokenMock(req, viewer.ID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Regexp(`"slug":"both-tags".*"articlesCount":1`, w.Body.String())
+
+	req, _ = http.NewRequest("GET", "/api/articles/for-you", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusUnauthorized, w.Code)
+}
+
+func TestArticleFavoritesDelta(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	article, _ := createArticleWithUser("Delta Article", fmt.Sprintf("delta-article-%d", common.RandInt()))
+	fanA := GetArticleUserModel(createTestUser())
+	fanB := GetArticleUserModel(createTestUser())
+	delta := func(query string) (int, string) {
+		req, _ := http.NewRequest("GET", "/api/articles/"+article.Slug+"/favorites/delta"+query, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code, w.Body.String()
+	}
+
+	asserts.NoError(article.favoriteBy(fanA))
+	asserts.NoError(article.favoriteBy(fanB))
+	code, body := delta("?since=0")
+	asserts.Equal(http.StatusOK, code)
+	asserts.Equal(`{"current":2,"delta":2}`, body, "New favorites give a positive delta")
+
+	asserts.NoError(article.unFavoriteBy(fanA))
+	code, body = delta("?since=2")
+	asserts.Equal(http.StatusOK, code)
+	asserts.Equal(`{"current":1,"delta":-1}`, body, "Removed favorites give a negative delta")
+
+	code, body = delta("")
+	asserts.Equal(`{"current":1,"delta":1}`, body, "A missing since counts from 0")
+
+	code, _ = delta("?since=abc")
+	asserts.Equal(http.StatusUnprocessableEntity, code)
+
+	req, _ := http.NewRequest("GET", "/api/articles/missing-delta-article/favorites/delta", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusNotFound, w.Code)
+}
+
+func TestFindArticlesByCursor(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	r := setupRouter()
+	base := time.Now().Add(-time.Hour)
+	for i := 0; i < 5; i++ {
+		article, _ := createArticleWithUser(fmt.Sprintf("Cursor %d", i), fmt.Sprintf("cursor-%d", i))
+		test_db.Model(&article).UpdateColumn("updated_at", base.Add(time.Duration(i)*time.Minute))
+	}
+
+	var seen []string
+	page, cursor, err := FindArticlesByCursor("", 2)
+	asserts.NoError(err)
+	for _, article := range page {
+		seen = append(seen, article.Slug)
+	}
+	asserts.NotEmpty(cursor)
+
+	// An article created between two pages must not shift the next ones
+	createArticleWithUser("Cursor New", "cursor-new")
+
+	for cursor != "" {
+		page, cursor, err = FindArticlesByCursor(cursor, 2)
+		asserts.NoError(err)
+		for _, article := range page {
+			seen = append(seen, article.Slug)
+		}
+	}
+	asserts.Equal([]string{"cursor-4", "cursor-3", "cursor-2", "cursor-1", "cursor-0"}, seen,
+		"Paging should neither skip nor repeat articles")
+
+	_, _, err = FindArticlesByCursor("not a cursor", 2)
+	asserts.ErrorIs(err, ErrInvalidCursor)
+
+	req, _ := http.NewRequest("GET", "/api/articles?cursor=&limit=4", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	var response struct {
+		Articles []struct {
+			Slug string `json:"slug"`
+		} `json:"articles"`
+		NextCursor string `json:"nextCursor"`
+	}
+	asserts.NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	asserts.Len(response.Articles, 4)
+	asserts.Equal("cursor-new", response.Articles[0].Slug)
+
+	req, _ = http.NewRequest("GET", "/api/articles?cursor="+response.NextCursor+"&limit=4", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Regexp(`"slug":"cursor-1".*"slug":"cursor-0".*"articlesCount":2,"nextCursor":""`, w.Body.String())
+
+	req, _ = http.NewRequest("GET", "/api/articles?cursor=%25%25", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestAuditFavorites(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	article, _ := createArticleWithUser("Audited Article", fmt.Sprintf("audited-article-%d", common.RandInt()))
+	fanA := GetArticleUserModel(createTestUser())
+	fanB := GetArticleUserModel(createTestUser())
+	asserts.NoError(article.favoriteBy(fanA))
+	asserts.NoError(article.favoriteBy(fanB))
+
+	rows, distinct := AuditFavorites(article.ID)
+	asserts.Equal(2, rows)
+	asserts.Equal(2, distinct, "Without duplicates rows and users match")
+
+	// favoriteBy never duplicates a row, so insert one directly
+	asserts.NoError(test_db.Create(&FavoriteModel{FavoriteID: article.ID, FavoriteByID: fanA.ID}).Error)
+	rows, distinct = AuditFavorites(article.ID)
+	asserts.Greater(rows, distinct, "A duplicate row should show up as drift")
+
+	req, _ := http.NewRequest("GET", "/api/articles/"+article.Slug+"/favorites/audit", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal(`{"distinctUsers":2,"rows":3}`, w.Body.String())
+
+	req, _ = http.NewRequest("GET", "/api/articles/missing-audited-article/favorites/audit", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusNotFound, w.Code)
+}
+
+func TestAdminCreateTags(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	r := setupRouter()
+	article, _ := createArticleWithUser("Tagged Article", "tagged-article")
+	asserts.NoError(article.setTags([]string{"golang"}))
+	asserts.NoError(SaveOne(&article))
+
+	created, err := CreateTags([]string{"golang", " databases ", "", "databases", "testing"})
+	asserts.NoError(err)
+	asserts.Equal([]string{"databases", "testing"}, created, "Only new tags should be created, trimmed and once")
+
+	created, err = CreateTags([]string{"databases", "testing"})
+	asserts.NoError(err)
+	asserts.Empty(created, "Seeding the same tags again should create nothing")
+
+	var count int64
+	test_db.Model(&TagModel{}).Where("tag IN ?", []string{"golang", "databases", "testing"}).Where("seeded = ?", true).Count(&count)
+	asserts.Equal(int64(3), count, "Seeding should also mark the tags that already existed")
+
+	admin := createAdminUser()
+	seed := func(userID uint, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/api/admin/tags", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		common.HeaderTokenMock(req, userID)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	w := seed(admin.ID, `{"tags":["testing","cooking"]}`)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal(`{"created":["cooking"],"existing":["testing"]}`, w.Body.String())
+
+	req, _ := http.NewRequest("GET", "/api/tags", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Contains(w.Body.String(), "cooking", "Seeded tags should be listed right away")
+
+	w = seed(admin.ID, `{"tags":[]}`)
+	asserts.Equal(http.StatusUnprocessableEntity, w.Code)
+
+	w = seed(createTestUser().ID, `{"tags":["blocked"]}`)
+	asserts.Equal(http.StatusForbidden, w.Code)
+}
+
+func TestDraftSummary(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	writer := createTestUser()
+	summary, err := DraftSummary(writer.ID)
+	asserts.NoError(err)
+	asserts.Equal(0, summary.Count)
+	asserts.Nil(summary.LastEdited, "Without drafts there is nothing to resume")
+
+	create := func(title string, draft bool) string {
+		body := fmt.Sprintf(`{"article":{"title":"%s","description":"d","body":"b","draft":%t}}`, title, draft)
+		req, _ := http.NewRequest("POST", "/api/articles", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		common.HeaderTokenMock(req, writer.ID)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		asserts.Equal(http.StatusCreated, w.Code)
+		var response struct {
+			Article struct {
+				Slug string `json:"slug"`
+			} `json:"article"`
+		}
+		asserts.NoError(json.Unmarshal(w.Body.Bytes(), &response))
+		return response.Article.Slug
+	}
+	suffix := common.RandInt()
+	olderDraft := create(fmt.Sprintf("Older Draft %d", suffix), true)
+	newerDraft := create(fmt.Sprintf("Newer Draft %d", suffix), true)
+	create(fmt.Sprintf("Published %d", suffix), false)
+	test_db.Model(&ArticleModel{}).Where("slug = ?", olderDraft).UpdateColumn("updated_at", time.Now().Add(-time.Hour))
+
+	summary, err = DraftSummary(writer.ID)
+	asserts.NoError(err)
+	asserts.Equal(2, summary.Count, "Published articles are not drafts")
+	if asserts.NotNil(summary.LastEdited) {
+		asserts.Equal(newerDraft, summary.LastEdited.Slug)
+	}
+
+	req, _ := http.NewRequest("GET", "/api/user/drafts/summary", nil)
+	common.HeaderTokenMock(req, writer.ID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Regexp(fmt.Sprintf(`^{"drafts":{"count":2,"lastEdited":{"slug":"%s","title":"Newer Draft %d","updatedAt":"[^"]+"}}}$`, newerDraft, suffix), w.Body.String())
+
+	req, _ = http.NewRequest("GET", "/api/articles?author="+writer.Username, nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.NotContains(w.Body.String(), "Draft", "Drafts should not be listed publicly")
+	asserts.Contains(w.Body.String(), `"articlesCount":1`)
+
+	req, _ = http.NewRequest("GET", "/api/user/drafts/summary", nil)
+	common.HeaderTokenMock(req, createTestUser().ID)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(`{"drafts":{"count":0,"lastEdited":null}}`, w.Body.String())
+}
+
+func TestDeleteAuthorArticlesByTag(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	suffix := common.RandInt()
+	obsolete := fmt.Sprintf("obsolete-%d", suffix)
+	author := createTestUser()
+	authorArticleUser := GetArticleUserModel(author)
+	fan := GetArticleUserModel(createTestUser())
+	create := func(slug string, tags ...string) ArticleModel {
+		article := ArticleModel{Slug: fmt.Sprintf("%s-%d", slug, suffix), Title: slug, Body: "Test Body", Author: authorArticleUser, AuthorID: authorArticleUser.ID}
+		asserts.NoError(article.setTags(tags))
+		asserts.NoError(SaveOne(&article))
+		return article
+	}
+	oldOne := create("old-one", obsolete)
+	oldTwo := create("old-two", obsolete, "keep")
+	kept := create("kept", "keep")
+	othersArticle, _ := createArticleWithUser("Others Obsolete", fmt.Sprintf("others-obsolete-%d", suffix))
+	asserts.NoError(othersArticle.setTags([]string{obsolete}))
+	asserts.NoError(SaveOne(&othersArticle))
+	asserts.NoError(oldOne.favoriteBy(fan))
+	test_db.Create(&CommentModel{ArticleID: oldTwo.ID, AuthorID: fan.ID, Body: "goes too"})
+	test_db.Create(&CommentModel{ArticleID: kept.ID, AuthorID: fan.ID, Body: "stays"})
+
+	req, _ := http.NewRequest("DELETE", "/api/user/articles?tag="+obsolete, nil)
+	common.HeaderTokenMock(req, author.ID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal(`{"deleted":2}`, w.Body.String())
+
+	var remaining []string
+	test_db.Model(&ArticleModel{}).Where("id IN ?", []uint{oldOne.ID, oldTwo.ID, kept.ID, othersArticle.ID}).Order("id").Pluck("slug", &remaining)
+	asserts.Equal([]string{kept.Slug, othersArticle.Slug}, remaining, "Only the author's tagged articles should go")
+	asserts.Equal(uint(0), oldOne.favoritesCount(), "Favorites of deleted articles should go")
+	var comments int64
+	test_db.Model(&CommentModel{}).Where("article_id IN ?", []uint{oldTwo.ID, kept.ID}).Count(&comments)
+	asserts.Equal(int64(1), comments, "Comments of deleted articles should go")
+	var softDeleted int64
+	test_db.Unscoped().Model(&ArticleModel{}).Where("id = ? AND deleted_at IS NOT NULL", oldOne.ID).Count(&softDeleted)
+	asserts.Equal(int64(1), softDeleted, "Articles should be soft deleted")
+
+	deleted, err := DeleteAuthorArticlesByTag(author.ID, obsolete)
+	asserts.NoError(err)
+	asserts.Equal(0, deleted, "Nothing is left to delete")
+
+	req, _ = http.NewRequest("DELETE", "/api/user/articles", nil)
+	common.HeaderTokenMock(req, author.ID)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestReadHistory(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	reader := createTestUser()
+	suffix := common.RandInt()
+	var articles []ArticleModel
+	for i := 0; i < 3; i++ {
+		article, _ := createArticleWithUser(fmt.Sprintf("History %d", i), fmt.Sprintf("history-%d-%d", i, suffix))
+		articles = append(articles, article)
+	}
+	createArticleWithUser("Unread", fmt.Sprintf("history-unread-%d", suffix))
+	// Read in the order 1, 0, 2, then 1 again
+	for i, index := range []int{1, 0, 2} {
+		asserts.NoError(articles[index].markReadBy(reader.ID))
+		test_db.Model(&ReadModel{}).Where("user_id = ? AND article_id = ?", reader.ID, articles[index].ID).
+			UpdateColumn("read_at", time.Now().Add(time.Duration(i-10)*time.Minute))
+	}
+	asserts.NoError(articles[1].markReadBy(reader.ID))
+
+	models, count, err := ReadHistory(reader.ID, 20, 0)
+	asserts.NoError(err)
+	asserts.Equal(3, count)
+	slugs := make([]string, len(models))
+	for i, model := range models {
+		slugs[i] = model.Slug
+	}
+	asserts.Equal([]string{articles[1].Slug, articles[2].Slug, articles[0].Slug}, slugs,
+		"Articles should be listed by when they were last read")
+
+	req, _ := http.NewRequest("GET", "/api/user/history?limit=1&offset=1", nil)
+	common.HeaderTokenMock(req, reader.ID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Regexp(fmt.Sprintf(`"slug":"%s".*"articlesCount":3`, articles[2].Slug), w.Body.String())
+
+	req, _ = http.NewRequest("GET", "/api/user/history", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusUnauthorized, w.Code)
+}
+
+func TestTagSubscriptionFeed(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	suffix := common.RandInt()
+	subscribed := fmt.Sprintf("subscribed-%d", suffix)
+	viewer := createTestUser()
+	viewerArticleUser := GetArticleUserModel(viewer)
+	tagged := func(slug string, author ArticleUserModel, tags ...string) ArticleModel {
+		article := ArticleModel{Slug: fmt.Sprintf("%s-%d", slug, suffix), Title: slug, Body: "Test Body", Author: author, AuthorID: author.ID}
+		asserts.NoError(article.setTags(tags))
+		asserts.NoError(SaveOne(&article))
+		return article
+	}
+	followed := createTestUser()
+	asserts.NoError(followUser(viewer, followed))
+	stranger := GetArticleUserModel(createTestUser())
+	both := tagged("followed-and-subscribed", GetArticleUserModel(followed), subscribed)
+	byTag := tagged("stranger-subscribed", stranger, subscribed)
+	tagged("stranger-other", stranger, "other")
+	tagged("own-subscribed", viewerArticleUser, subscribed)
+
+	subscription := func(method, tag string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, "/api/user/subscriptions/tags/"+tag, nil)
+		common.HeaderTokenMock(req, viewer.ID)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	feedSlugs := func() []string {
+		models, count, err := GetCombinedFeed(viewer.ID, 20, 0)
+		asserts.NoError(err)
+		asserts.Equal(len(models), count)
+		slugs := make([]string, len(models))
+		for i, model := range models {
+			slugs[i] = model.Slug
+		}
+		return slugs
+	}
+
+	asserts.Equal([]string{both.Slug}, feedSlugs(), "Without subscriptions only followed authors show up")
+
+	w := subscription("POST", subscribed)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal(fmt.Sprintf(`{"subscribed":true,"tag":"%s"}`, subscribed), w.Body.String())
+	asserts.Equal(http.StatusOK, subscription("POST", subscribed).Code, "Subscribing twice is harmless")
+	asserts.ElementsMatch([]string{both.Slug, byTag.Slug}, feedSlugs(),
+		"Subscribed tag articles join the feed once, without the viewer's own")
+
+	req, _ := http.NewRequest("GET", "/api/articles/feed", nil)
+	common.HeaderTokenMock(req, viewer.ID)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Contains(w.Body.String(), byTag.Slug)
+	asserts.Contains(w.Body.String(), `"articlesCount":2`)
+
+	asserts.Equal(http.StatusOK, subscription("DELETE", subscribed).Code)
+	asserts.Equal([]string{both.Slug}, feedSlugs(), "Unsubscribing removes the tag articles")
+
+	asserts.Equal(http.StatusNotFound, subscription("POST", "no-such-tag").Code)
+}
+
+func TestListTagSubscriptions(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	viewer := createTestUser()
+	suffix := common.RandInt()
+	first := fmt.Sprintf("a-subscription-%d", suffix)
+	second := fmt.Sprintf("b-subscription-%d", suffix)
+	_, err := CreateTags([]string{second, first})
+	asserts.NoError(err)
+
+	tags, err := ListTagSubscriptions(viewer.ID)
+	asserts.NoError(err)
+	asserts.Empty(tags)
+
+	for _, tag := range []string{second, first} {
+		tagModel, err := FindOneTag(tag)
+		asserts.NoError(err)
+		asserts.NoError(SubscribeTag(viewer.ID, tagModel))
+	}
+	list := func() string {
+		req, _ := http.NewRequest("GET", "/api/user/subscriptions/tags", nil)
+		common.HeaderTokenMock(req, viewer.ID)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		asserts.Equal(http.StatusOK, w.Code)
+		return w.Body.String()
+	}
+	asserts.Equal(fmt.Sprintf(`{"tags":["%s","%s"]}`, first, second), list())
+
+	req, _ := http.NewRequest("DELETE", "/api/user/subscriptions/tags/"+first, nil)
+	common.HeaderTokenMock(req, viewer.ID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal(fmt.Sprintf(`{"tags":["%s"]}`, second), list(), "Unsubscribed tags should not be listed")
+}
+
+func TestCreateArticleWithTagsRollsBack(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	author := createTestUser()
+	authorArticleUser := GetArticleUserModel(author)
+	suffix := common.RandInt()
+	goodTag := fmt.Sprintf("rollback-good-%d", suffix)
+	badTag := fmt.Sprintf("rollback-bad-%d", suffix)
+	errForced := errors.New("forced tag failure")
+	test_db.Callback().Create().Before("gorm:create").Register("test:fail_tag", func(db *gorm.DB) {
+		if tag, ok := db.Statement.Dest.(*TagModel); ok && tag.Tag == badTag {
+			db.AddError(errForced)
+		}
+	})
+	defer test_db.Callback().Create().Remove("test:fail_tag")
+
+	slug := fmt.Sprintf("rollback-article-%d", suffix)
+	article := ArticleModel{Slug: slug, Title: "Rollback", Body: "Test Body", Author: authorArticleUser, AuthorID: authorArticleUser.ID}
+	err := CreateArticleWithTags(&article, []string{goodTag, badTag})
+	asserts.ErrorIs(err, errForced)
+	var articles, tags int64
+	test_db.Unscoped().Model(&ArticleModel{}).Where("slug = ?", slug).Count(&articles)
+	asserts.Equal(int64(0), articles, "No article row should persist")
+	test_db.Model(&TagModel{}).Where("tag = ?", goodTag).Count(&tags)
+	asserts.Equal(int64(0), tags, "Tags created before the failure should be rolled back")
+
+	article = ArticleModel{Slug: slug, Title: "Rollback", Body: "Test Body", Author: authorArticleUser, AuthorID: authorArticleUser.ID}
+	asserts.NoError(CreateArticleWithTags(&article, []string{goodTag}))
+	created, err := FindOneArticle(&ArticleModel{Slug: slug})
+	asserts.NoError(err)
+	if asserts.Len(created.Tags, 1) {
+		asserts.Equal(goodTag, created.Tags[0].Tag)
+	}
+
+	body := fmt.Sprintf(`{"article":{"title":"Rollback Handler %d","description":"d","body":"b","tagList":["%s"]}}`, suffix, badTag)
+	req, _ := http.NewRequest("POST", "/api/articles", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	common.HeaderTokenMock(req, author.ID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusUnprocessableEntity, w.Code)
+	test_db.Unscoped().Model(&ArticleModel{}).Where("title = ?", fmt.Sprintf("Rollback Handler %d", suffix)).Count(&articles)
+	asserts.Equal(int64(0), articles, "The create handler should not leave a half created article")
+}
+
+func TestAdminFavoriteMatrix(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	article, _ := createArticleWithUser("Matrix Article", fmt.Sprintf("matrix-article-%d", common.RandInt()))
+	fan := createTestUser()
+	otherFan := createTestUser()
+	bystander := createTestUser()
+	asserts.NoError(article.favoriteBy(GetArticleUserModel(fan)))
+	asserts.NoError(article.favoriteBy(GetArticleUserModel(otherFan)))
+
+	asserts.Equal(map[uint]bool{fan.ID: true, bystander.ID: false},
+		FavoriteMatrix(article.ID, []uint{fan.ID, bystander.ID}))
+
+	matrix := func(userID uint, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/api/admin/articles/"+article.Slug+"/favorite-matrix", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		common.HeaderTokenMock(req, userID)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	admin := createAdminUser()
+	body := fmt.Sprintf(`{"userIds":[%d,%d,%d]}`, fan.ID, bystander.ID, admin.ID)
+	w := matrix(admin.ID, body)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.JSONEq(fmt.Sprintf(`{"favorited":{"%d":true,"%d":false,"%d":false},"favoritesCount":2}`, fan.ID, bystander.ID, admin.ID), w.Body.String())
+
+	asserts.Equal(http.StatusForbidden, matrix(fan.ID, body).Code)
+	asserts.Equal(http.StatusUnprocessableEntity, matrix(admin.ID, `{"userIds":[]}`).Code)
+}
+
+func TestTopArticlePerTag(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	r := setupRouter()
+	tagged := func(slug string, age time.Duration, favorites int, tags ...string) ArticleModel {
+		article, _ := createArticleWithUser(slug, slug)
+		asserts.NoError(article.setTags(tags))
+		asserts.NoError(SaveOne(&article))
+		test_db.Model(&article).UpdateColumn("created_at", time.Now().Add(-age))
+		for i := 0; i < favorites; i++ {
+			asserts.NoError(article.favoriteBy(GetArticleUserModel(createTestUser())))
+		}
+		return article
+	}
+	tagged("go-popular", 3*time.Hour, 3, "go", "db")
+	tagged("go-newer", time.Hour, 1, "go")
+	tagged("db-favorite", 2*time.Hour, 4, "db")
+	tagged("quiet-old", 2*time.Hour, 0, "quiet")
+	tagged("quiet-new", time.Hour, 0, "quiet")
+
+	top, err := TopArticlePerTag()
+	asserts.NoError(err)
+	slugs := make(map[string]string, len(top))
+	for tag, article := range top {
+		slugs[tag] = article.Slug
+	}
+	asserts.Equal(map[string]string{"go": "go-popular", "db": "db-favorite", "quiet": "quiet-new"}, slugs,
+		"The most favorited article wins, the newest one without favorites")
+
+	req, _ := http.NewRequest("GET", "/api/tags/top-articles?limit=2", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	var response struct {
+		Tags map[string][]struct {
+			Slug string `json:"slug"`
+		} `json:"tags"`
+	}
+	asserts.NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	if asserts.Len(response.Tags["go"], 2) {
+		asserts.Equal("go-popular", response.Tags["go"][0].Slug)
+		asserts.Equal("go-newer", response.Tags["go"][1].Slug)
+	}
+}
+
+func TestSitemap(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	r := setupRouter()
+	r.GET("/sitemap.xml", Sitemap)
+	published, _ := createArticleWithUser("Published", "sitemap-published")
+	updatedAt := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	test_db.Model(&published).UpdateColumn("updated_at", updatedAt)
+	draft, _ := createArticleWithUser("Draft", "sitemap-draft")
+	test_db.Model(&draft).UpdateColumn("draft", true)
+	deleted, _ := createArticleWithUser("Deleted", "sitemap-deleted")
+	asserts.NoError(DeleteArticleModel(&ArticleModel{Slug: deleted.Slug}))
+
+	os.Unsetenv("SITE_BASE_URL")
+	req, _ := http.NewRequest("GET", "/sitemap.xml", nil)
+	req.Host = "evil.example.com"
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusServiceUnavailable, w.Code, "Links should never be built from the Host header")
+	asserts.Equal(`{"errors":{"sitemap":"SITE_BASE_URL is not set"}}`, w.Body.String())
+
+	os.Setenv("SITE_BASE_URL", "https://blog.example.com/")
+	defer os.Unsetenv("SITE_BASE_URL")
+	req, _ = http.NewRequest("GET", "/sitemap.xml", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal("application/xml; charset=utf-8", w.Header().Get("Content-Type"))
+
+	var sitemap struct {
+		XMLName xml.Name `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+		URLs    []struct {
+			Loc     string `xml:"loc"`
+			LastMod string `xml:"lastmod"`
+		} `xml:"url"`
+	}
+	asserts.NoError(xml.Unmarshal(w.Body.Bytes(), &sitemap), "The sitemap should be valid XML")
+	lastMods := make(map[string]string, len(sitemap.URLs))
+	for _, u := range sitemap.URLs {
+		lastMods[u.Loc] = u.LastMod
+	}
+	asserts.Equal("2024-05-06T07:08:09Z", lastMods["https://blog.example.com/article/sitemap-published"])
+	asserts.NotContains(lastMods, "https://blog.example.com/article/sitemap-draft", "Drafts should be left out")
+	asserts.NotContains(lastMods, "https://blog.example.com/article/sitemap-deleted", "Deleted articles should be left out")
+
+	rendered, err := RenderSitemap("https://example.com", []ArticleModel{{Slug: "a&b", Model: gorm.Model{UpdatedAt: updatedAt}}})
+	asserts.NoError(err)
+	asserts.Contains(string(rendered), "<url><loc>https://example.com/article/a&amp;b</loc><lastmod>2024-05-06T07:08:09Z</lastmod></url>")
+}
+
+func TestArticleSchema(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	SchemaRegister(r.Group("/api/schema"))
+	retrieve := func() map[string]interface{} {
+		req, _ := http.NewRequest("GET", "/api/schema/article", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		asserts.Equal(http.StatusOK, w.Code)
+		var schema struct {
+			Properties struct {
+				Article map[string]interface{} `json:"article"`
+			} `json:"properties"`
+		}
+		asserts.NoError(json.Unmarshal(w.Body.Bytes(), &schema))
+		return schema.Properties.Article
+	}
+
+	article := retrieve()
+	asserts.ElementsMatch([]interface{}{"title", "body", "description"}, article["required"])
+	properties := article["properties"].(map[string]interface{})
+	asserts.Equal(map[string]interface{}{"type": "string", "minLength": float64(4)}, properties["title"])
+	asserts.Equal(map[string]interface{}{"type": "string", "maxLength": float64(2048)}, properties["body"])
+	asserts.Equal(map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}}, properties["tagList"])
+
+	os.Setenv("REQUIRE_DESCRIPTION", "false")
+	defer os.Unsetenv("REQUIRE_DESCRIPTION")
+	asserts.ElementsMatch([]interface{}{"title", "body"}, retrieve()["required"], "An optional description is not required")
+}
+
+func TestBatchGetCommentCounts(t *testing.T) {
+	asserts := assert.New(t)
+
+	quiet, author := createArticleWithUser("Quiet Article", fmt.Sprintf("quiet-article-%d", common.RandInt()))
+	single, _ := createArticleWithUser("Single Comment Article", fmt.Sprintf("single-comment-%d", common.RandInt()))
+	busy, _ := createArticleWithUser("Busy Article", fmt.Sprintf("busy-article-%d", common.RandInt()))
+	commenter := GetArticleUserModel(createTestUser())
+	asserts.NoError(test_db.Create(&CommentModel{ArticleID: single.ID, AuthorID: commenter.ID, Body: "one"}).Error)
+	for i := 0; i < 3; i++ {
+		asserts.NoError(test_db.Create(&CommentModel{ArticleID: busy.ID, AuthorID: commenter.ID, Body: fmt.Sprintf("busy %d", i)}).Error)
+	}
+	asserts.NoError(test_db.Create(&CommentModel{ArticleID: busy.ID, AuthorID: commenter.ID, Body: "removed", Deleted: true}).Error)
+
+	counts := BatchGetCommentCounts([]uint{quiet.ID, single.ID, busy.ID})
+	asserts.Equal(uint(0), counts[quiet.ID])
+	_, ok := counts[quiet.ID]
+	asserts.False(ok, "Articles without comments should be absent rather than stored as zero")
+	asserts.Equal(uint(1), counts[single.ID])
+	asserts.Equal(uint(3), counts[busy.ID], "Tombstoned comments should not be counted")
+
+	asserts.Empty(BatchGetCommentCounts(nil))
+	asserts.Empty(BatchGetCommentCounts([]uint{0}))
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set("my_user_model", author)
+	serializer := ArticlesSerializer{c, []ArticleModel{quiet, single, busy}}
+	response := serializer.Response()
+	asserts.Len(response, 3)
+	asserts.Equal(uint(0), response[0].CommentsCount)
+	asserts.Equal(uint(1), response[1].CommentsCount)
+	asserts.Equal(uint(3), response[2].CommentsCount)
+	asserts.Equal(uint(1), (&ArticleSerializer{c, single}).Response().CommentsCount)
+}
+
+func TestArticleFeedStream(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	server := httptest.NewServer(r)
+	defer server.Close()
+	viewer := createTestUser()
+	followed := createTestUser()
+	stranger := createTestUser()
+	followUser(viewer, followed)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/api/articles/feed/stream", nil)
+	common.HeaderTokenMock(req, viewer.ID)
+	resp, err := http.DefaultClient.Do(req)
+	asserts.NoError(err)
+	defer resp.Body.Close()
+	asserts.Equal(http.StatusOK, resp.StatusCode)
+	asserts.Equal("text/event-stream", resp.Header.Get("Content-Type"))
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	for _, author := range []users.UserModel{stranger, followed} {
+		body := fmt.Sprintf(`{"article":{"title":"Streamed by %s","description":"Test Description","body":"Test Body"}}`, author.Username)
+		req, _ := http.NewRequest("POST", "/api/articles", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		common.HeaderTokenMock(req, author.ID)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		asserts.Equal(http.StatusCreated, w.Code)
+	}
+
+	var received []string
+	timeout := time.After(5 * time.Second)
+	for len(received) < 2 {
+		select {
+		case line := <-lines:
+			if line != "" {
+				received = append(received, line)
+			}
+		case <-timeout:
+			t.Fatalf("no feed event received, got %v", received)
+		}
+	}
+	asserts.Equal("event:article", received[0])
+	asserts.Contains(received[1], "Streamed by "+followed.Username)
+	asserts.NotContains(received[1], stranger.Username, "Articles of authors the viewer does not follow should not be streamed")
+
+	cancel()
+	asserts.Eventually(func() bool { return feedBroker.Subscribers() == 0 }, 5*time.Second, 10*time.Millisecond,
+		"The subscription should end with the client")
+
+	req, _ = http.NewRequest("GET", "/api/articles/feed/stream", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusUnauthorized, w.Code)
+}
+
+func TestArticleUnpublishAndRepublish(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := setupRouter()
+	article, author := createArticleWithUser("Unpublish Me", fmt.Sprintf("unpublish-me-%d", common.RandInt()))
+	reader := createTestUser()
+	followUser(reader, author)
+	asserts.NoError(article.favoriteBy(GetArticleUserModel(reader)))
+	asserts.NoError(test_db.Create(&CommentModel{ArticleID: article.ID, AuthorID: GetArticleUserModel(reader).ID, Body: "kept"}).Error)
+
+	listed := func() bool {
+		req, _ := http.NewRequest("GET", "/api/articles?limit=1000", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return strings.Contains(w.Body.String(), article.Slug)
+	}
+	post := func(action string, userID uint) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/api/articles/"+article.Slug+"/"+action, nil)
+		common.HeaderTokenMock(req, userID)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	asserts.True(listed())
+
+	w := post("unpublish", reader.ID)
+	asserts.Equal(http.StatusForbidden, w.Code, "Only the author can unpublish")
+
+	w = post("unpublish", author.ID)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Contains(w.Body.String(), `"draft":true`)
+	asserts.False(listed(), "An unpublished article should leave the public list")
+	latest, err := LatestPerAuthor(reader.ID)
+	asserts.NoError(err)
+	asserts.Empty(latest, "An unpublished article should leave the feeds")
+	asserts.Equal(uint(1), article.favoritesCount(), "Unpublishing should keep favorites")
+	asserts.Equal(uint(1), article.commentsCount(), "Unpublishing should keep comments")
+
+	w = post("publish", author.ID)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.NotContains(w.Body.String(), `"draft"`)
+	asserts.True(listed(), "A republished article should be listed again")
+
+	asserts.NoError(SetPublished(article.ID, false))
+	stored, _ := FindOneArticle(&ArticleModel{Slug: article.Slug})
+	asserts.True(stored.Draft)
+
+	w = post("publish", author.ID)
+	asserts.Equal(http.StatusOK, w.Code)
+	req, _ := http.NewRequest("POST", "/api/articles/missing-slug/unpublish", nil)
+	common.HeaderTokenMock(req, author.ID)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusNotFound, w.Code)
+}
+
+func TestTopArticles(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDB()
+	r := setupRouter()
+	older, _ := createArticleWithUser("Older Pair", "top-older-pair")
+	silent, _ := createArticleWithUser("Silent", "top-silent")
+	newer, _ := createArticleWithUser("Newer Pair", "top-newer-pair")
+	leader, _ := createArticleWithUser("Leader", "top-leader")
+	draft, _ := createArticleWithUser("Draft", "top-draft")
+	gone, _ := createArticleWithUser("Gone", "top-gone")
+	base := time.Now().Add(-time.Hour)
+	for i, article := range []ArticleModel{older, silent, newer, leader, draft, gone} {
+		test_db.Model(&ArticleModel{}).Where("id = ?", article.ID).Update("created_at", base.Add(time.Duration(i)*time.Minute))
+	}
+	fans := make([]ArticleUserModel, 4)
+	for i := range fans {
+		fans[i] = GetArticleUserModel(createTestUser())
+	}
+	favorite := func(article ArticleModel, n int) {
+		for _, fan := range fans[:n] {
+			asserts.NoError(article.favoriteBy(fan))
+		}
+	}
+	favorite(leader, 3)
+	favorite(older, 2)
+	favorite(newer, 2)
+	favorite(draft, 4)
+	favorite(gone, 4)
+	asserts.NoError(SetPublished(draft.ID, false))
+	asserts.NoError(DeleteArticleModel(&ArticleModel{Slug: gone.Slug}))
+
+	slugs := func(articles []ArticleModel) []string {
+		result := make([]string, 0, len(articles))
+		for _, article := range articles {
+			result = append(result, article.Slug)
+		}
+		return result
+	}
+	top, count, err := TopArticles(-1, 0)
+	asserts.NoError(err)
+	asserts.Equal(4, count, "Drafts and deleted articles should not be counted")
+	asserts.Equal([]string{"top-leader", "top-newer-pair", "top-older-pair", "top-silent"}, slugs(top),
+		"Articles should rank by favorites, the newest first among equals")
+
+	top, count, err = TopArticles(2, 1)
+	asserts.NoError(err)
+	asserts.Equal(4, count)
+	asserts.Equal([]string{"top-newer-pair", "top-older-pair"}, slugs(top))
+
+	top, count, err = TopArticles(2, 10)
+	asserts.NoError(err)
+	asserts.Equal(4, count)
+	asserts.Empty(top)
+
+	req, _ := http.NewRequest("GET", "/api/articles/top?limit=1&offset=0", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	var response struct {
+		Articles []struct {
+			Slug           string `json:"slug"`
+			FavoritesCount uint   `json:"favoritesCount"`
+		} `json:"articles"`
+		ArticlesCount int `json:"articlesCount"`
+	}
+	asserts.NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	asserts.Equal(4, response.ArticlesCount)
+	asserts.Len(response.Articles, 1)
+	asserts.Equal("top-leader", response.Articles[0].Slug)
+	asserts.Equal(uint(3), response.Articles[0].FavoritesCount)
+}
+
+func TestDraftsHiddenFromPublicQueries(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDB()
+	r := setupRouter()
+	reader := createTestUser()
+	draft, author := createArticleWithUser("Hidden Draft", "hidden-draft")
+	tag := fmt.Sprintf("drafttag%d", common.RandInt())
+	asserts.NoError(draft.setTags([]string{tag, tag + "-other"}))
+	asserts.NoError(test_db.Model(&draft).Association("Tags").Replace(draft.Tags))
+	test_db.Model(&draft).Update("body", "Hello @"+reader.Username)
+	fan := createTestUser()
+	followUser(reader, fan)
+	asserts.NoError(draft.favoriteBy(GetArticleUserModel(fan)))
+	asserts.NoError(draft.markReadBy(reader.ID))
+	asserts.NoError(SetPublished(draft.ID, false))
+
+	found, err := FindArticlesBySlugs([]string{draft.Slug})
+	asserts.NoError(err)
+	asserts.Empty(found)
+	network, err := NetworkFavorites(reader.ID, 10)
+	asserts.NoError(err)
+	asserts.Empty(network)
+	mentions, _, err := FindMentions(reader.Username, 10, 0)
+	asserts.NoError(err)
+	asserts.Empty(mentions)
+	history, _, err := ReadHistory(reader.ID, 10, 0)
+	asserts.NoError(err)
+	asserts.Empty(history)
+	asserts.Equal(0, BatchCountArticlesByAuthor([]string{author.Username})[author.Username])
+	trending, err := TrendingTags(7, 10)
+	asserts.NoError(err)
+	asserts.Empty(trending)
+	related, err := RelatedTags(tag, 10)
+	asserts.NoError(err)
+	asserts.Empty(related)
+	breakdown, err := AuthorTagBreakdown(author.ID)
+	asserts.NoError(err)
+	asserts.Empty(breakdown)
+	archive, err := ArticleArchiveCounts()
+	asserts.NoError(err)
+	asserts.Empty(archive)
+	cadence, err := PublishingCadence(author.ID)
+	asserts.NoError(err)
+	asserts.Zero(cadence.CurrentStreakDays)
+	tags, err := TagsForArticles([]uint{draft.ID})
+	asserts.NoError(err)
+	asserts.Empty(tags)
+
+	liker, _ := createArticleWithUser("Liked", "draft-liked")
+	asserts.NoError(liker.setTags([]string{tag}))
+	asserts.NoError(test_db.Model(&liker).Association("Tags").Replace(liker.Tags))
+	asserts.NoError(liker.favoriteBy(GetArticleUserModel(reader)))
+	forYou, err := ForYou(reader.ID, 10)
+	asserts.NoError(err)
+	asserts.Empty(forYou)
+
+	get := func(path string, userID uint) int {
+		req, _ := http.NewRequest("GET", path, nil)
+		if userID != 0 {
+			common.HeaderTokenMock(req, userID)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	for _, path := range []string{"/api/articles/" + draft.Slug, fmt.Sprintf("/api/articles/id/%d", draft.ID)} {
+		asserts.Equal(http.StatusNotFound, get(path, 0), path)
+		asserts.Equal(http.StatusNotFound, get(path, reader.ID), path)
+		asserts.Equal(http.StatusOK, get(path, author.ID), "The author should still see their draft at "+path)
+	}
+}
+
+func TestDraftRoutesHiddenFromNonAuthors(t *testing.T) {
+	asserts := assert.New(t)
+
+	resetDBWithMock()
+	r := setupRouter()
+	draft, author := createArticleWithUser("Route Draft", "route-draft")
+	comment := CommentModel{ArticleID: draft.ID, AuthorID: draft.AuthorID, Body: "author note"}
+	asserts.NoError(test_db.Create(&comment).Error)
+	asserts.NoError(SetPublished(draft.ID, false))
+	stranger := createTestUser()
+
+	send := func(method, path, body string, userID uint) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if userID != 0 {
+			common.HeaderTokenMock(req, userID)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	base := "/api/articles/" + draft.Slug
+	routes := []struct{ method, path, body string }{
+		{"GET", base + "/comments", ""},
+		{"GET", base + "/favorites/timeline", ""},
+		{"GET", base + "/history", ""},
+		{"GET", base + "/permissions", ""},
+		{"GET", base + "/counts", ""},
+		{"GET", base + "/favorites/delta", ""},
+		{"GET", base + "/favorites/audit", ""},
+		{"POST", base + "/favorite", ""},
+		{"DELETE", base + "/favorite", ""},
+		{"POST", base + "/read", ""},
+		{"POST", base + "/comments", `{"comment":{"body":"sneaky"}}`},
+		{"POST", fmt.Sprintf("%s/comments/%d/flag", base, comment.ID), `{"flag":{"reason":"spam"}}`},
+		{"PUT", base, `{"article":{"body":"edited"}}`},
+		{"POST", base + "/lock", ""},
+		{"POST", base + "/publish", ""},
+		{"POST", base + "/revert/1", ""},
+	}
+	for _, route := range routes {
+		w := send(route.method, route.path, route.body, stranger.ID)
+		asserts.Equal(http.StatusNotFound, w.Code, route.method+" "+route.path)
+		asserts.NotContains(w.Body.String(), "Test Body", route.method+" "+route.path)
+	}
+	for _, route := range routes[:7] {
+		asserts.Equal(http.StatusNotFound, send(route.method, route.path, "", 0).Code, "anonymous "+route.path)
+	}
+
+	var favorites, comments, reads int64
+	test_db.Model(&FavoriteModel{}).Where("favorite_id = ?", draft.ID).Count(&favorites)
+	test_db.Model(&CommentModel{}).Where("article_id = ?", draft.ID).Count(&comments)
+	test_db.Model(&ReadModel{}).Where("article_id = ?", draft.ID).Count(&reads)
+	asserts.Zero(favorites)
+	asserts.Equal(int64(1), comments)
+	asserts.Zero(reads)
+
+	asserts.Equal(http.StatusOK, send("GET", base+"/comments", "", author.ID).Code, "The author should still reach their draft")
+	asserts.Equal(http.StatusOK, send("GET", base+"/counts", "", author.ID).Code)
+	asserts.Equal(http.StatusOK, send("POST", base+"/read", "", author.ID).Code)
+}
+
 // This is a hack way to add test database for each case
 func TestMain(m *testing.M) {
 	test_db = common.TestDBInit()
@@ -1599,6 +5446,11 @@ func TestMain(m *testing.M) {
 	test_db.AutoMigrate(&FavoriteModel{})
 	test_db.AutoMigrate(&ArticleUserModel{})
 	test_db.AutoMigrate(&CommentModel{})
+	test_db.AutoMigrate(&ArticleRevisionModel{})
+	test_db.AutoMigrate(&ReadModel{})
+	test_db.AutoMigrate(&SlugHistoryModel{})
+	test_db.AutoMigrate(&CommentFlagModel{})
+	test_db.AutoMigrate(&TagSubscriptionModel{})
 	exitVal := m.Run()
 	common.TestDBFree(test_db)
 	os.Exit(exitVal)
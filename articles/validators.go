@@ -2,7 +2,7 @@ package articles
 
 import (
 	"github.com/gin-gonic/gin"
-	"github.com/gosimple/slug"
+	"github.com/go-playground/validator/v10"
 	"github.com/gothinkster/golang-gin-realworld-example-app/common"
 	"github.com/gothinkster/golang-gin-realworld-example-app/users"
 )
@@ -10,13 +10,28 @@ import (
 type ArticleModelValidator struct {
 	Article struct {
 		Title       string   `form:"title" json:"title" binding:"required,min=4"`
-		Description string   `form:"description" json:"description" binding:"required,max=2048"`
+		Description string   `form:"description" json:"description" binding:"required_description,max=2048"`
 		Body        string   `form:"body" json:"body" binding:"required,max=2048"`
 		Tags        []string `form:"tagList" json:"tagList"`
+		// Draft is only honored on create, updates keep the article's draft state.
+		Draft bool `form:"draft" json:"draft"`
 	} `json:"article"`
 	articleModel ArticleModel `json:"-"`
 }
 
+func init() {
+	common.MustRegisterValidation("required_description", requiredDescription, "required")
+}
+
+// requiredDescription behaves like `required`, errors included, unless REQUIRE_DESCRIPTION
+// is set to false, for forks that treat the article description as optional.
+func requiredDescription(fl validator.FieldLevel) bool {
+	if !common.GetEnvBool("REQUIRE_DESCRIPTION", true) {
+		return true
+	}
+	return fl.Field().String() != ""
+}
+
 func NewArticleModelValidator() ArticleModelValidator {
 	return ArticleModelValidator{}
 }
@@ -33,18 +48,26 @@ func NewArticleModelValidatorFillWith(articleModel ArticleModel) ArticleModelVal
 }
 
 func (s *ArticleModelValidator) Bind(c *gin.Context) error {
+	if err := s.bind(c); err != nil {
+		return err
+	}
+	return s.articleModel.setTags(s.Article.Tags)
+}
+
+// bind is Bind without setting the tags, for callers that tag the article in their own transaction.
+func (s *ArticleModelValidator) bind(c *gin.Context) error {
 	myUserModel := c.MustGet("my_user_model").(users.UserModel)
 
 	err := common.Bind(c, s)
 	if err != nil {
 		return err
 	}
-	s.articleModel.Slug = slug.Make(s.Article.Title)
+	s.articleModel.Slug = MakeSlug(s.Article.Title)
 	s.articleModel.Title = s.Article.Title
 	s.articleModel.Description = s.Article.Description
 	s.articleModel.Body = s.Article.Body
+	s.articleModel.Draft = s.Article.Draft
 	s.articleModel.Author = GetArticleUserModel(myUserModel)
-	s.articleModel.setTags(s.Article.Tags)
 	return nil
 }
 
@@ -70,3 +93,56 @@ func (s *CommentModelValidator) Bind(c *gin.Context) error {
 	s.commentModel.Author = GetArticleUserModel(myUserModel)
 	return nil
 }
+
+type CommentFlagValidator struct {
+	Flag struct {
+		Reason string `form:"reason" json:"reason" binding:"required,max=255"`
+	} `json:"flag"`
+}
+
+func NewCommentFlagValidator() CommentFlagValidator {
+	return CommentFlagValidator{}
+}
+
+func (s *CommentFlagValidator) Bind(c *gin.Context) error {
+	return common.Bind(c, s)
+}
+
+// ArticleIDsValidator binds a list of article ids for the batch article endpoints.
+type ArticleIDsValidator struct {
+	IDs []uint `form:"ids" json:"ids" binding:"required,min=1"`
+}
+
+func (s *ArticleIDsValidator) Bind(c *gin.Context) error {
+	return common.Bind(c, s)
+}
+
+func NewArticleIDsValidator() ArticleIDsValidator {
+	return ArticleIDsValidator{}
+}
+
+// UserIDsValidator binds a list of user ids for the admin endpoints inspecting several users at once.
+type UserIDsValidator struct {
+	UserIDs []uint `form:"userIds" json:"userIds" binding:"required,min=1"`
+}
+
+func (s *UserIDsValidator) Bind(c *gin.Context) error {
+	return common.Bind(c, s)
+}
+
+func NewUserIDsValidator() UserIDsValidator {
+	return UserIDsValidator{}
+}
+
+// TagsValidator binds a list of tags for the admin tag endpoints.
+type TagsValidator struct {
+	Tags []string `form:"tags" json:"tags" binding:"required,min=1"`
+}
+
+func (s *TagsValidator) Bind(c *gin.Context) error {
+	return common.Bind(c, s)
+}
+
+func NewTagsValidator() TagsValidator {
+	return TagsValidator{}
+}
@@ -0,0 +1,59 @@
+package common
+
+import "sync"
+
+// brokerBuffer is how many events a subscriber may fall behind before new ones are dropped for it.
+const brokerBuffer = 16
+
+// Broker is an in-process publish/subscribe hub: every event published reaches every current
+// subscriber. It only spans one process, subscribers of another replica see nothing.
+type Broker struct {
+	mu          sync.Mutex
+	subscribers map[chan interface{}]struct{}
+}
+
+// NewBroker returns a Broker without subscribers.
+func NewBroker() *Broker {
+	return &Broker{subscribers: make(map[chan interface{}]struct{})}
+}
+
+// Subscribe registers a subscriber and returns its events along with the function that
+// unsubscribes it, which closes the channel and is safe to call more than once.
+//
+//	events, unsubscribe := broker.Subscribe()
+//	defer unsubscribe()
+func (b *Broker) Subscribe() (<-chan interface{}, func()) {
+	ch := make(chan interface{}, brokerBuffer)
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Publish hands event to every subscriber without blocking: a subscriber whose buffer is
+// full misses it rather than stalling the publisher.
+func (b *Broker) Publish(event interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// Subscribers returns how many subscribers are registered.
+func (b *Broker) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers)
+}
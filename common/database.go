@@ -1,9 +1,11 @@
 package common
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/glebarez/sqlite"
 	"gorm.io/gorm"
@@ -16,6 +18,9 @@ type Database struct {
 
 var DB *gorm.DB
 
+// ErrDBNotInitialized is the panic value of MustGetDB when neither Init nor TestDBInit has run.
+var ErrDBNotInitialized = errors.New("database is not initialized: call common.Init or common.TestDBInit first")
+
 // GetDBPath returns the database path from environment or default.
 // Exported for use in tests.
 func GetDBPath() string {
@@ -111,3 +116,65 @@ func TestDBFree(test_db *gorm.DB) error {
 func GetDB() *gorm.DB {
 	return DB
 }
+
+// MustGetDB is GetDB for the model helpers, it panics with ErrDBNotInitialized
+// instead of leaving a nil pointer dereference deep inside gorm.
+func MustGetDB() *gorm.DB {
+	if DB == nil {
+		panic(ErrDBNotInitialized)
+	}
+	return DB
+}
+
+// WithTx runs fn in a transaction on the shared database, committed when fn returns nil
+// and rolled back when it returns an error or panics.
+//
+//	err := common.WithTx(func(tx *gorm.DB) error {
+//		return tx.Create(&model).Error
+//	})
+func WithTx(fn func(tx *gorm.DB) error) error {
+	return MustGetDB().Transaction(fn)
+}
+
+// HoursSinceExpr returns a SQL expression for the hours elapsed since the timestamp column,
+// for both SQLite and Postgres.
+func HoursSinceExpr(db *gorm.DB, column string) string {
+	if db.Dialector.Name() == "postgres" {
+		return fmt.Sprintf("(EXTRACT(EPOCH FROM (NOW() - %s)) / 3600)", column)
+	}
+	return fmt.Sprintf("((julianday('now') - julianday(%s)) * 24)", column)
+}
+
+// DateBucketExpr returns a SQL expression formatting column as the start of its
+// "day", "week" (Monday) or "month" bucket, for both SQLite and Postgres.
+func DateBucketExpr(db *gorm.DB, column, bucket string) string {
+	if db.Dialector.Name() == "postgres" {
+		switch bucket {
+		case "month":
+			return fmt.Sprintf("to_char(%s, 'YYYY-MM')", column)
+		case "week":
+			return fmt.Sprintf("to_char(date_trunc('week', %s), 'YYYY-MM-DD')", column)
+		default:
+			return fmt.Sprintf("to_char(%s, 'YYYY-MM-DD')", column)
+		}
+	}
+	switch bucket {
+	case "month":
+		return fmt.Sprintf("strftime('%%Y-%%m', %s)", column)
+	case "week":
+		return fmt.Sprintf("date(%s, '-6 days', 'weekday 1')", column)
+	default:
+		return fmt.Sprintf("date(%s)", column)
+	}
+}
+
+// likeEscaper escapes the LIKE wildcards and the escape character itself.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// EscapeLike makes value match literally inside a LIKE pattern, the query must
+// declare the escape character with ESCAPE '\'.
+//
+//	db.Where("body LIKE ? ESCAPE '\\'", "%"+common.EscapeLike(term)+"%")
+func EscapeLike(value string) string {
+	return likeEscaper.Replace(value)
+}
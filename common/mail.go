@@ -0,0 +1,51 @@
+package common
+
+import (
+	"errors"
+	"net"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrMailNotConfigured is returned by SendMail when SMTP_HOST is unset.
+var ErrMailNotConfigured = errors.New("SMTP_HOST is not set")
+
+// MailConfigured reports whether SendMail has an SMTP server to talk to.
+func MailConfigured() bool {
+	return os.Getenv("SMTP_HOST") != ""
+}
+
+// headerSanitizer keeps header values on one line, so they cannot inject headers of their own.
+var headerSanitizer = strings.NewReplacer("\r", "", "\n", "")
+
+// formatMail builds a plain text message with its From, To and Subject headers.
+func formatMail(from, to, subject, body string) []byte {
+	var msg strings.Builder
+	msg.WriteString("From: " + headerSanitizer.Replace(from) + "\r\n")
+	msg.WriteString("To: " + headerSanitizer.Replace(to) + "\r\n")
+	msg.WriteString("Subject: " + headerSanitizer.Replace(subject) + "\r\n")
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.WriteString(body)
+	return []byte(msg.String())
+}
+
+// SendMail sends a plain text email through the SMTP server set by SMTP_HOST and SMTP_PORT
+// (587 by default), from SMTP_FROM. SMTP_USERNAME and SMTP_PASSWORD, when set, authenticate.
+//
+//	err := common.SendMail("jake@jake.jake", "Welcome", "Hello Jake")
+func SendMail(to, subject, body string) error {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return ErrMailNotConfigured
+	}
+	from := os.Getenv("SMTP_FROM")
+	var auth smtp.Auth
+	if username := os.Getenv("SMTP_USERNAME"); username != "" {
+		auth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(GetEnvInt("SMTP_PORT", 587)))
+	return smtp.SendMail(addr, auth, from, []string{to}, formatMail(from, to, subject, body))
+}
@@ -0,0 +1,134 @@
+package common
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const defaultCORSMethods = "GET,POST,PUT,DELETE,OPTIONS"
+
+// corsMethods reads CORS_ALLOWED_METHODS, a comma separated list such as "GET,POST",
+// so strict deployments can keep browsers away from DELETE.
+func corsMethods() []string {
+	value := os.Getenv("CORS_ALLOWED_METHODS")
+	if strings.TrimSpace(value) == "" {
+		value = defaultCORSMethods
+	}
+	var methods []string
+	for _, method := range strings.Split(value, ",") {
+		if method = strings.ToUpper(strings.TrimSpace(method)); method != "" {
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
+
+// CORSMiddleware answers preflight requests and adds the CORS headers to every response.
+// CORS_MAX_AGE (a duration, 12h by default) sets how long browsers cache a preflight.
+//
+//	r.Use(common.CORSMiddleware())
+func CORSMiddleware() gin.HandlerFunc {
+	methods := strings.Join(corsMethods(), ",")
+	maxAge := strconv.Itoa(int(GetEnvDuration("CORS_MAX_AGE", 12*time.Hour).Seconds()))
+	return func(c *gin.Context) {
+		header := c.Writer.Header()
+		header.Set("Access-Control-Allow-Origin", "*")
+		header.Set("Access-Control-Allow-Methods", methods)
+		header.Set("Access-Control-Allow-Headers", "Authorization,Content-Type")
+		header.Set("Access-Control-Max-Age", maxAge)
+		if c.Request.Method == http.MethodOptions {
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequestIDHeader carries the request id in both directions.
+const RequestIDHeader = "X-Request-ID"
+
+// RequestIDMiddleware keeps the X-Request-ID sent by the client, or makes one up, stores it
+// as "request_id" in the context and echoes it in the response.
+//
+//	r.Use(common.RequestIDMiddleware())
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if requestID == "" {
+			requestID = RandString(16)
+		}
+		c.Set("request_id", requestID)
+		c.Header(RequestIDHeader, requestID)
+		c.Next()
+	}
+}
+
+// requestIDLogger prefixes every line of the wrapped gorm logger with the request id.
+type requestIDLogger struct {
+	logger.Interface
+	requestID string
+}
+
+func (l requestIDLogger) LogMode(level logger.LogLevel) logger.Interface {
+	return requestIDLogger{l.Interface.LogMode(level), l.requestID}
+}
+
+func (l requestIDLogger) prefix() string {
+	return "[request_id=" + l.requestID + "] "
+}
+
+func (l requestIDLogger) Info(ctx context.Context, msg string, data ...interface{}) {
+	l.Interface.Info(ctx, l.prefix()+msg, data...)
+}
+
+func (l requestIDLogger) Warn(ctx context.Context, msg string, data ...interface{}) {
+	l.Interface.Warn(ctx, l.prefix()+msg, data...)
+}
+
+func (l requestIDLogger) Error(ctx context.Context, msg string, data ...interface{}) {
+	l.Interface.Error(ctx, l.prefix()+msg, data...)
+}
+
+func (l requestIDLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	l.Interface.Trace(ctx, begin, func() (string, int64) {
+		sql, rows := fc()
+		return l.prefix() + sql, rows
+	}, err)
+}
+
+// TracingDB returns a session whose logged SQL is prefixed with the request id set by
+// RequestIDMiddleware, so slow queries can be traced back to their request.
+//
+//	articleModel, err := findOneArticle(common.TracingDB(c), &ArticleModel{Slug: slug})
+func TracingDB(c *gin.Context) *gorm.DB {
+	db := MustGetDB()
+	requestID := c.GetString("request_id")
+	if requestID == "" {
+		return db
+	}
+	return db.Session(&gorm.Session{Logger: requestIDLogger{db.Logger, requestID}})
+}
+
+// PrivateModeMiddleware rejects anonymous requests with 401 when PRIVATE_MODE=true, for
+// instances that should not be readable without an account. Put it after an optional
+// auth middleware, it relies on the "my_user_id" that one sets.
+//
+//	v1.Use(users.AuthMiddleware(false), common.PrivateModeMiddleware())
+func PrivateModeMiddleware() gin.HandlerFunc {
+	private := GetEnvBool("PRIVATE_MODE", false)
+	return func(c *gin.Context) {
+		if private && c.GetUint("my_user_id") == 0 {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.Next()
+	}
+}
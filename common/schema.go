@@ -0,0 +1,96 @@
+package common
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// JSONSchemaForStruct describes the JSON payload v binds to as a JSON Schema, so clients can
+// validate before sending. Field names come from the json tags and constraints from the
+// binding tags: required, min, max and email. Custom validators are not described.
+//
+//	schema := common.JSONSchemaForStruct(ArticleModelValidator{})
+func JSONSchemaForStruct(v interface{}) map[string]interface{} {
+	schema := jsonSchemaForType(reflect.TypeOf(v))
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	return schema
+}
+
+func jsonSchemaForType(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		required := []string{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if !field.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			property := jsonSchemaForType(field.Type)
+			for _, rule := range strings.Split(field.Tag.Get("binding"), ",") {
+				if rule == "required" {
+					required = append(required, name)
+				}
+				applyBindingRule(property, rule)
+			}
+			properties[name] = property
+		}
+		schema := map[string]interface{}{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": jsonSchemaForType(t.Elem())}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	}
+	return map[string]interface{}{}
+}
+
+// applyBindingRule adds the JSON Schema keyword matching a min, max or email binding rule to property.
+func applyBindingRule(property map[string]interface{}, rule string) {
+	if rule == "email" {
+		property["format"] = "email"
+		return
+	}
+	name, value, ok := strings.Cut(rule, "=")
+	if !ok || (name != "min" && name != "max") {
+		return
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil {
+		return
+	}
+	keywords := map[string][2]string{
+		"string":  {"minLength", "maxLength"},
+		"array":   {"minItems", "maxItems"},
+		"integer": {"minimum", "maximum"},
+		"number":  {"minimum", "maximum"},
+	}
+	kind, _ := property["type"].(string)
+	keyword, known := keywords[kind]
+	if !known {
+		return
+	}
+	if name == "min" {
+		property[keyword[0]] = limit
+	} else {
+		property[keyword[1]] = limit
+	}
+}
@@ -21,15 +21,7 @@ func ExtractTokenFromHeader(authHeader string) string {
 	return ""
 }
 
-// VerifyTokenClaims verifies a JWT token and returns claims for testing
+// VerifyTokenClaims verifies a JWT token and returns claims for testing, see VerifyToken
 func VerifyTokenClaims(tokenString string) (jwt.MapClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, jwt.MapClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(JWTSecret), nil
-	})
-
-	if err != nil {
-		return nil, err
-	}
-
-	return token.Claims.(jwt.MapClaims), nil
+	return VerifyToken(tokenString)
 }
@@ -2,15 +2,26 @@ package common
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm/logger"
 )
 
 func TestConnectingDatabase(t *testing.T) {
@@ -122,7 +133,10 @@ func TestGenToken(t *testing.T) {
 	token := GenToken(2)
 
 	asserts.IsType(token, string("token"), "token type should be string")
-	asserts.Len(token, 115, "JWT's length should be 115")
+	asserts.Len(token, 137, "JWT's length should be 137")
+	claims, err := VerifyTokenClaims(token)
+	asserts.NoError(err)
+	asserts.Contains(claims, "iat", "Tokens should carry their issue time")
 }
 
 func TestGenTokenMultipleUsers(t *testing.T) {
@@ -135,10 +149,10 @@ func TestGenTokenMultipleUsers(t *testing.T) {
 	asserts.NotEqual(token1, token2, "Different user IDs should generate different tokens")
 	asserts.NotEqual(token2, token100, "Different user IDs should generate different tokens")
 	// Token length can vary by 1 character due to timestamp changes
-	asserts.GreaterOrEqual(len(token1), 114, "JWT's length should be >= 114 for user 1")
-	asserts.LessOrEqual(len(token1), 120, "JWT's length should be <= 120 for user 1")
-	asserts.GreaterOrEqual(len(token100), 114, "JWT's length should be >= 114 for user 100")
-	asserts.LessOrEqual(len(token100), 120, "JWT's length should be <= 120 for user 100")
+	asserts.GreaterOrEqual(len(token1), 136, "JWT's length should be >= 136 for user 1")
+	asserts.LessOrEqual(len(token1), 142, "JWT's length should be <= 142 for user 1")
+	asserts.GreaterOrEqual(len(token100), 136, "JWT's length should be >= 136 for user 100")
+	asserts.LessOrEqual(len(token100), 142, "JWT's length should be <= 142 for user 100")
 }
 
 func TestHeaderTokenMock(t *testing.T) {
@@ -185,6 +199,31 @@ func TestVerifyTokenClaims(t *testing.T) {
 	asserts.Error(err, "VerifyTokenClaims should error for invalid token")
 }
 
+func TestVerifyToken(t *testing.T) {
+	asserts := assert.New(t)
+
+	claims, err := VerifyToken(GenToken(7))
+	asserts.NoError(err)
+	asserts.Equal(float64(7), claims["id"])
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	asserts.NoError(err)
+	rsaToken, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"id": 7}).SignedString(key)
+	asserts.NoError(err)
+	_, err = VerifyToken(rsaToken)
+	asserts.ErrorIs(err, jwt.ErrSignatureInvalid, "Only HMAC signed tokens should be accepted")
+
+	forged, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"id": 7}).SignedString([]byte("another secret"))
+	asserts.NoError(err)
+	_, err = VerifyToken(forged)
+	asserts.Error(err, "A token signed with another secret should be rejected")
+
+	expired, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"id": 7, "exp": time.Now().Add(-time.Minute).Unix()}).SignedString([]byte(JWTSecret))
+	asserts.NoError(err)
+	_, err = VerifyToken(expired)
+	asserts.ErrorIs(err, jwt.ErrTokenExpired)
+}
+
 func TestNewValidatorError(t *testing.T) {
 	asserts := assert.New(t)
 
@@ -254,6 +293,25 @@ func TestNewValidatorError(t *testing.T) {
 	}
 }
 
+func TestMustRegisterValidation(t *testing.T) {
+	asserts := assert.New(t)
+
+	MustRegisterValidation("test_nonempty", func(fl validator.FieldLevel) bool {
+		return fl.Field().String() != ""
+	}, "required")
+	var form struct {
+		Name string `json:"name" binding:"test_nonempty"`
+	}
+	err := binding.Validator.ValidateStruct(&form)
+	asserts.Error(err)
+	asserts.Equal(map[string]interface{}{"Name": "{key: required}"}, NewValidatorError(err).Errors,
+		"A rule standing in for a built-in one should keep its key")
+
+	asserts.Panics(func() {
+		MustRegisterValidation("", func(validator.FieldLevel) bool { return true }, "")
+	}, "A rule that can't be registered should fail loudly")
+}
+
 func TestNewError(t *testing.T) {
 	assert := assert.New(t)
 
@@ -366,3 +424,322 @@ func TestDatabaseWithCurrentDirectory(t *testing.T) {
 	sqlDB.Close()
 	os.Remove("test_simple.db")
 }
+
+func TestGetEnvBool(t *testing.T) {
+	asserts := assert.New(t)
+
+	os.Unsetenv("TEST_ENV_BOOL")
+	asserts.True(GetEnvBool("TEST_ENV_BOOL", true), "Unset value should use fallback")
+
+	os.Setenv("TEST_ENV_BOOL", "false")
+	defer os.Unsetenv("TEST_ENV_BOOL")
+	asserts.False(GetEnvBool("TEST_ENV_BOOL", true), "Set value should be parsed")
+
+	os.Setenv("TEST_ENV_BOOL", "not-a-bool")
+	asserts.True(GetEnvBool("TEST_ENV_BOOL", true), "Invalid value should use fallback")
+}
+
+func TestBuildInfo(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := gin.New()
+	r.GET("/version", VersionRetrieve)
+	retrieve := func() map[string]string {
+		req, _ := http.NewRequest("GET", "/version", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		asserts.Equal(http.StatusOK, w.Code)
+		var info map[string]string
+		asserts.NoError(json.Unmarshal(w.Body.Bytes(), &info))
+		return info
+	}
+
+	info := retrieve()
+	asserts.Equal(map[string]string{"version": "dev", "schema": SchemaVersion, "go": runtime.Version()}, info)
+
+	BuildVersion = "v1.2.3"
+	defer func() { BuildVersion = "" }()
+	asserts.Equal("v1.2.3", retrieve()["version"])
+}
+
+func TestGetEnvFloat(t *testing.T) {
+	asserts := assert.New(t)
+
+	os.Unsetenv("TEST_ENV_FLOAT")
+	asserts.Equal(1.5, GetEnvFloat("TEST_ENV_FLOAT", 1.5), "Unset value should use fallback")
+
+	os.Setenv("TEST_ENV_FLOAT", "0.25")
+	defer os.Unsetenv("TEST_ENV_FLOAT")
+	asserts.Equal(0.25, GetEnvFloat("TEST_ENV_FLOAT", 1.5), "Set value should be parsed")
+
+	os.Setenv("TEST_ENV_FLOAT", "steep")
+	asserts.Equal(1.5, GetEnvFloat("TEST_ENV_FLOAT", 1.5), "Invalid value should use fallback")
+}
+
+func TestGetEnvDuration(t *testing.T) {
+	asserts := assert.New(t)
+
+	os.Unsetenv("TEST_ENV_DURATION")
+	asserts.Equal(time.Minute, GetEnvDuration("TEST_ENV_DURATION", time.Minute), "Unset value should use fallback")
+
+	os.Setenv("TEST_ENV_DURATION", "5s")
+	defer os.Unsetenv("TEST_ENV_DURATION")
+	asserts.Equal(5*time.Second, GetEnvDuration("TEST_ENV_DURATION", time.Minute), "Set value should be parsed")
+
+	os.Setenv("TEST_ENV_DURATION", "soon")
+	asserts.Equal(time.Minute, GetEnvDuration("TEST_ENV_DURATION", time.Minute), "Invalid value should use fallback")
+}
+
+func TestSecureCompare(t *testing.T) {
+	asserts := assert.New(t)
+
+	token := RandString(32)
+	asserts.True(SecureCompare(token, token))
+	asserts.False(SecureCompare(token, token[:31]+"!"), "A different last byte should not match")
+	asserts.False(SecureCompare(token[:16], token), "A prefix should not match")
+	asserts.False(SecureCompare("", token))
+	asserts.True(SecureCompare("", ""))
+}
+
+func TestEscapeLike(t *testing.T) {
+	asserts := assert.New(t)
+
+	asserts.Equal("plain", EscapeLike("plain"))
+	asserts.Equal(`100\%\_done\\`, EscapeLike(`100%_done\`))
+}
+
+func TestDateBucketExpr(t *testing.T) {
+	asserts := assert.New(t)
+	db := TestDBInit()
+	defer TestDBFree(db)
+
+	var values []string
+	for _, bucket := range []string{"day", "week", "month"} {
+		var value string
+		expr := DateBucketExpr(db, "?", bucket)
+		asserts.NoError(db.Raw("SELECT "+expr, "2024-03-14 10:30:00+00:00").Scan(&value).Error)
+		values = append(values, value)
+	}
+	asserts.Equal([]string{"2024-03-14", "2024-03-11", "2024-03"}, values, "Thursday should fall into the week of Monday the 11th")
+}
+
+func TestGetEnvInt(t *testing.T) {
+	asserts := assert.New(t)
+
+	os.Unsetenv("TEST_ENV_INT")
+	asserts.Equal(10, GetEnvInt("TEST_ENV_INT", 10), "Unset value should use fallback")
+
+	os.Setenv("TEST_ENV_INT", "3")
+	defer os.Unsetenv("TEST_ENV_INT")
+	asserts.Equal(3, GetEnvInt("TEST_ENV_INT", 10), "Set value should be parsed")
+
+	os.Setenv("TEST_ENV_INT", "three")
+	asserts.Equal(10, GetEnvInt("TEST_ENV_INT", 10), "Invalid value should use fallback")
+}
+
+func TestMustGetDB(t *testing.T) {
+	asserts := assert.New(t)
+
+	db := DB
+	defer func() { DB = db }()
+
+	DB = nil
+	asserts.PanicsWithError(ErrDBNotInitialized.Error(), func() { MustGetDB() })
+
+	DB = TestDBInit()
+	defer TestDBFree(DB)
+	asserts.Equal(GetDB(), MustGetDB())
+}
+
+func TestRespondError(t *testing.T) {
+	asserts := assert.New(t)
+	gin.SetMode(gin.TestMode)
+
+	var requestTests = []struct {
+		err            error
+		expectedCode   int
+		responseString string
+	}{
+		{ErrNotFound, http.StatusNotFound, `{"errors":{"error":"not found"}}`},
+		{ErrForbidden, http.StatusForbidden, `{"errors":{"error":"forbidden"}}`},
+		{ErrConflict, http.StatusConflict, `{"errors":{"error":"conflict"}}`},
+		{fmt.Errorf("%w: article", ErrNotFound), http.StatusNotFound, `{"errors":{"error":"not found: article"}}`},
+		{NewAPIError(http.StatusNotFound, "articles", errors.New("Invalid slug")), http.StatusNotFound, `{"errors":{"articles":"Invalid slug"}}`},
+		{fmt.Errorf("wrapped: %w", NewAPIError(http.StatusForbidden, "article", errors.New("you are not the author"))), http.StatusForbidden, `{"errors":{"article":"you are not the author"}}`},
+		{errors.New("no such table: users"), http.StatusUnprocessableEntity, `{"errors":{"database":"no such table: users"}}`},
+	}
+	for _, testData := range requestTests {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		RespondError(c, testData.err)
+		asserts.Equal(testData.expectedCode, w.Code, testData.err.Error())
+		asserts.Equal(testData.responseString, w.Body.String(), testData.err.Error())
+	}
+
+	asserts.ErrorIs(NewAPIError(http.StatusNotFound, "articles", errors.New("Invalid slug")), ErrNotFound)
+	asserts.NotErrorIs(NewAPIError(http.StatusNotFound, "articles", errors.New("Invalid slug")), ErrForbidden)
+	asserts.ErrorIs(NewAPIError(http.StatusConflict, "username", errors.New("taken")), ErrConflict)
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	asserts := assert.New(t)
+	gin.SetMode(gin.TestMode)
+
+	preflight := func() *httptest.ResponseRecorder {
+		r := gin.New()
+		r.Use(CORSMiddleware())
+		r.DELETE("/articles/:slug", func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+		req, _ := http.NewRequest("OPTIONS", "/articles/hello", nil)
+		req.Header.Set("Origin", "https://example.com")
+		req.Header.Set("Access-Control-Request-Method", "DELETE")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := preflight()
+	asserts.Equal(http.StatusNoContent, w.Code)
+	asserts.Equal("43200", w.Header().Get("Access-Control-Max-Age"))
+	asserts.Contains(w.Header().Get("Access-Control-Allow-Methods"), "DELETE")
+
+	os.Setenv("CORS_MAX_AGE", "10m")
+	defer os.Unsetenv("CORS_MAX_AGE")
+	os.Setenv("CORS_ALLOWED_METHODS", "get, post")
+	defer os.Unsetenv("CORS_ALLOWED_METHODS")
+	w = preflight()
+	asserts.Equal("600", w.Header().Get("Access-Control-Max-Age"))
+	asserts.Equal("GET,POST", w.Header().Get("Access-Control-Allow-Methods"))
+	asserts.NotContains(w.Header().Get("Access-Control-Allow-Methods"), "DELETE", "A disallowed method should not be advertised")
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	asserts := assert.New(t)
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.Use(RequestIDMiddleware())
+	r.GET("/ping", func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString("request_id"))
+	})
+
+	req, _ := http.NewRequest("GET", "/ping", nil)
+	req.Header.Set(RequestIDHeader, "client-id")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal("client-id", w.Body.String())
+	asserts.Equal("client-id", w.Header().Get(RequestIDHeader))
+
+	req, _ = http.NewRequest("GET", "/ping", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Len(w.Body.String(), 16, "A missing id should be generated")
+	asserts.Equal(w.Body.String(), w.Header().Get(RequestIDHeader))
+}
+
+func TestTracingDB(t *testing.T) {
+	asserts := assert.New(t)
+	gin.SetMode(gin.TestMode)
+
+	db := TestDBInit()
+	defer TestDBFree(db)
+	var logs bytes.Buffer
+	db.Logger = logger.New(log.New(&logs, "", 0), logger.Config{LogLevel: logger.Info})
+
+	r := gin.New()
+	r.Use(RequestIDMiddleware())
+	r.GET("/query", func(c *gin.Context) {
+		var one int
+		TracingDB(c).Raw("SELECT 1").Scan(&one)
+		c.Status(http.StatusOK)
+	})
+	req, _ := http.NewRequest("GET", "/query", nil)
+	req.Header.Set(RequestIDHeader, "trace-me")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Regexp(`\[request_id=trace-me\] SELECT 1`, logs.String())
+
+	// Without a request id the plain session is used
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	asserts.Equal(db, TracingDB(c))
+}
+
+func TestJSONSchemaForStruct(t *testing.T) {
+	asserts := assert.New(t)
+
+	type payload struct {
+		User struct {
+			Name   string   `json:"name" binding:"required,min=4,max=255"`
+			Email  string   `json:"email" binding:"required,email"`
+			Age    *int     `json:"age" binding:"min=18"`
+			Admin  bool     `json:"admin"`
+			Score  float64  `json:"score,omitempty"`
+			Tags   []string `json:"tagList" binding:"max=3"`
+			Secret string   `json:"-"`
+			hidden string
+		} `json:"user"`
+	}
+	schema := JSONSchemaForStruct(payload{})
+	encoded, err := json.Marshal(schema)
+	asserts.NoError(err)
+	asserts.JSONEq(`{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"type": "object",
+		"properties": {
+			"user": {
+				"type": "object",
+				"required": ["name", "email"],
+				"properties": {
+					"name": {"type": "string", "minLength": 4, "maxLength": 255},
+					"email": {"type": "string", "format": "email"},
+					"age": {"type": "integer", "minimum": 18},
+					"admin": {"type": "boolean"},
+					"score": {"type": "number"},
+					"tagList": {"type": "array", "items": {"type": "string"}, "maxItems": 3}
+				}
+			}
+		}
+	}`, string(encoded))
+}
+
+func TestBroker(t *testing.T) {
+	asserts := assert.New(t)
+
+	broker := NewBroker()
+	first, unsubscribeFirst := broker.Subscribe()
+	second, unsubscribeSecond := broker.Subscribe()
+	asserts.Equal(2, broker.Subscribers())
+
+	broker.Publish("hello")
+	asserts.Equal("hello", <-first)
+	asserts.Equal("hello", <-second)
+
+	unsubscribeFirst()
+	unsubscribeFirst()
+	_, open := <-first
+	asserts.False(open, "Unsubscribing should close the channel")
+	asserts.Equal(1, broker.Subscribers())
+
+	for i := 0; i < brokerBuffer+5; i++ {
+		broker.Publish(i)
+	}
+	asserts.Len(second, brokerBuffer, "A full subscriber should miss events instead of blocking the publisher")
+	unsubscribeSecond()
+	asserts.Equal(0, broker.Subscribers())
+}
+
+func TestSendMail(t *testing.T) {
+	asserts := assert.New(t)
+
+	os.Unsetenv("SMTP_HOST")
+	asserts.False(MailConfigured())
+	asserts.ErrorIs(SendMail("jake@jake.jake", "Hello", "Body"), ErrMailNotConfigured)
+
+	msg := string(formatMail("from@example.com", "jake@jake.jake", "Hello\r\nBcc: evil@example.com", "Line one\nLine two"))
+	asserts.Contains(msg, "To: jake@jake.jake\r\n")
+	asserts.Contains(msg, "Subject: HelloBcc: evil@example.com\r\n", "Header values should not be able to add headers")
+	asserts.True(strings.HasSuffix(msg, "\r\n\r\nLine one\nLine two"))
+}
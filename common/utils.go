@@ -3,9 +3,14 @@ package common
 
 import (
 	"crypto/rand"
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"math/big"
+	"net/http"
+	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -40,6 +45,43 @@ func RandInt() int {
 	return int(randNum.Int64())
 }
 
+// GetEnvBool reads a boolean config value from the environment, using fallback when unset or invalid.
+func GetEnvBool(key string, fallback bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// GetEnvInt reads an integer config value from the environment, using fallback when unset or invalid.
+func GetEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// GetEnvFloat reads a decimal config value from the environment, using fallback when unset or invalid.
+func GetEnvFloat(key string, fallback float64) float64 {
+	value, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// GetEnvDuration reads a duration config value such as "30s" from the environment,
+// using fallback when unset or invalid.
+func GetEnvDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
 // Keep this two config private, it should not expose to open source
 const JWTSecret = "A String Very Very Very Strong!!@##$!@#$"      // #nosec G101
 const RandomPassword = "A String Very Very Very Random!!@##$!@#4" // #nosec G101
@@ -48,6 +90,7 @@ const RandomPassword = "A String Very Very Very Random!!@##$!@#4" // #nosec G101
 func GenToken(id uint) string {
 	jwt_token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"id":  id,
+		"iat": time.Now().Unix(),
 		"exp": time.Now().Add(time.Hour * 24).Unix(),
 	})
 	// Sign and get the complete encoded token as a string
@@ -59,6 +102,28 @@ func GenToken(id uint) string {
 	return token
 }
 
+// VerifyToken checks the signature and expiry of a token made by GenToken and returns its
+// claims. Only HMAC signatures are accepted, whatever algorithm the token header names.
+func VerifyToken(tokenString string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return []byte(JWTSecret), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// SecureCompare reports whether two secrets such as HMAC signatures or reset tokens are
+// equal in constant time. Verifying tokens with == leaks through timing how much matched.
+func SecureCompare(given, expected string) bool {
+	return subtle.ConstantTimeCompare([]byte(given), []byte(expected)) == 1
+}
+
 // My own Error type that will help return my customized Error info
 //
 //	{"database": {"hello":"no such table", error: "not_exists"}}
@@ -66,6 +131,27 @@ type CommonError struct {
 	Errors map[string]interface{} `json:"errors"`
 }
 
+// validationReportedAs maps custom binding rules to the tag NewValidatorError reports them as.
+var validationReportedAs = map[string]string{}
+
+// MustRegisterValidation registers a custom binding rule for tag, panicking when it can't so a
+// broken rule fails at startup rather than on every request using it. A rule standing in for
+// a built-in one can keep its error key with reportAs, leave it empty to report tag.
+//
+//	common.MustRegisterValidation("required_description", requiredDescription, "required")
+func MustRegisterValidation(tag string, fn validator.Func, reportAs string) {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		panic(fmt.Sprintf("registering validation %q: binding engine is not a validator", tag))
+	}
+	if err := v.RegisterValidation(tag, fn); err != nil {
+		panic(fmt.Sprintf("registering validation %q: %v", tag, err))
+	}
+	if reportAs != "" {
+		validationReportedAs[tag] = reportAs
+	}
+}
+
 // To handle the error returned by c.Bind in gin framework
 // https://github.com/go-playground/validator/blob/v9/_examples/translations/main.go
 func NewValidatorError(err error) CommonError {
@@ -75,10 +161,14 @@ func NewValidatorError(err error) CommonError {
 	for _, v := range errs {
 		// can translate each error one at a time.
 		//fmt.Println("gg",v.NameNamespace)
+		tag := v.Tag()
+		if reportAs, ok := validationReportedAs[tag]; ok {
+			tag = reportAs
+		}
 		if v.Param() != "" {
-			res.Errors[v.Field()] = fmt.Sprintf("{%v: %v}", v.Tag(), v.Param())
+			res.Errors[v.Field()] = fmt.Sprintf("{%v: %v}", tag, v.Param())
 		} else {
-			res.Errors[v.Field()] = fmt.Sprintf("{key: %v}", v.Tag())
+			res.Errors[v.Field()] = fmt.Sprintf("{key: %v}", tag)
 		}
 
 	}
@@ -97,6 +187,73 @@ func NewError(key string, err error) CommonError {
 	return res
 }
 
+// Sentinel errors that RespondError maps to a status code. Wrap them to add detail,
+//
+//	fmt.Errorf("%w: article %s", common.ErrNotFound, slug)
+var (
+	ErrNotFound  = errors.New("not found")
+	ErrForbidden = errors.New("forbidden")
+	ErrConflict  = errors.New("conflict")
+)
+
+var sentinelStatus = []struct {
+	err    error
+	status int
+}{
+	{ErrNotFound, http.StatusNotFound},
+	{ErrForbidden, http.StatusForbidden},
+	{ErrConflict, http.StatusConflict},
+}
+
+// APIError carries the status code and error key a handler should answer with.
+// It matches the sentinel of its status, so errors.Is(err, ErrNotFound) holds for a 404 APIError.
+type APIError struct {
+	Status int
+	Key    string
+	Err    error
+}
+
+func NewAPIError(status int, key string, err error) *APIError {
+	return &APIError{Status: status, Key: key, Err: err}
+}
+
+func (e *APIError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+func (e *APIError) Is(target error) bool {
+	for _, sentinel := range sentinelStatus {
+		if target == sentinel.err {
+			return e.Status == sentinel.status
+		}
+	}
+	return false
+}
+
+// RespondError renders err as a CommonError body. An APIError keeps its status and key, a
+// wrapped sentinel answers with its status under the "error" key, and anything else is
+// treated as a database failure like the handlers always did.
+//
+//	{"errors": {"articles": "Invalid slug"}}
+func RespondError(c *gin.Context, err error) {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		c.JSON(apiErr.Status, NewError(apiErr.Key, apiErr.Err))
+		return
+	}
+	for _, sentinel := range sentinelStatus {
+		if errors.Is(err, sentinel.err) {
+			c.JSON(sentinel.status, NewError("error", err))
+			return
+		}
+	}
+	c.JSON(http.StatusUnprocessableEntity, NewError("database", err))
+}
+
 func normalizeSQLiteError(msg string) string {
 	normalized := strings.TrimPrefix(msg, "constraint failed: ")
 	normalized = strings.TrimPrefix(normalized, "SQL logic error: ")
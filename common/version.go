@@ -0,0 +1,37 @@
+package common
+
+import (
+	"net/http"
+	"runtime"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BuildVersion is the application build, injected at link time:
+//
+//	go build -ldflags "-X github.com/gothinkster/golang-gin-realworld-example-app/common.BuildVersion=v1.2.3"
+//
+// Builds without it report "dev".
+var BuildVersion string
+
+// SchemaVersion names the newest schema change. The schema is kept up to date with
+// AutoMigrate rather than numbered migrations, so bump it along with any model change.
+const SchemaVersion = "slug-history-table"
+
+// BuildInfo describes the running build for ops.
+func BuildInfo() map[string]string {
+	version := BuildVersion
+	if version == "" {
+		version = "dev"
+	}
+	return map[string]string{
+		"version": version,
+		"schema":  SchemaVersion,
+		"go":      runtime.Version(),
+	}
+}
+
+// VersionRetrieve answers GET /api/version with BuildInfo.
+func VersionRetrieve(c *gin.Context) {
+	c.JSON(http.StatusOK, BuildInfo())
+}
@@ -16,9 +16,17 @@ func Migrate(db *gorm.DB) {
 	users.AutoMigrate()
 	db.AutoMigrate(&articles.ArticleModel{})
 	db.AutoMigrate(&articles.TagModel{})
+	if err := articles.BackfillFavoritePrivacy(db); err != nil {
+		log.Println("failed to backfill favorite privacy:", err)
+	}
 	db.AutoMigrate(&articles.FavoriteModel{})
 	db.AutoMigrate(&articles.ArticleUserModel{})
 	db.AutoMigrate(&articles.CommentModel{})
+	db.AutoMigrate(&articles.ArticleRevisionModel{})
+	db.AutoMigrate(&articles.ReadModel{})
+	db.AutoMigrate(&articles.SlugHistoryModel{})
+	db.AutoMigrate(&articles.CommentFlagModel{})
+	db.AutoMigrate(&articles.TagSubscriptionModel{})
 }
 
 func main() {
@@ -37,19 +45,37 @@ func main() {
 	// Disable automatic redirect for trailing slashes
 	// This prevents POST body from being lost during redirects
 	r.RedirectTrailingSlash = false
+	r.Use(common.RequestIDMiddleware())
+	r.Use(common.CORSMiddleware())
+	r.GET("/sitemap.xml", common.PrivateModeMiddleware(), articles.Sitemap)
 
+	users.PasswordResetMailer = users.DefaultPasswordResetMailer()
+	if users.PasswordResetMailer == nil {
+		log.Println("SMTP is not configured, password reset is disabled")
+	}
 	v1 := r.Group("/api")
 	users.UsersRegister(v1.Group("/users"))
+	v1.GET("/version", common.VersionRetrieve)
+	users.TokenAnonymousRegister(v1.Group("/token"))
 	v1.Use(users.AuthMiddleware(false))
+	v1.Use(common.PrivateModeMiddleware())
+	v1.Use(users.LastActiveMiddleware())
 	articles.ArticlesAnonymousRegister(v1.Group("/articles"))
 	articles.TagsAnonymousRegister(v1.Group("/tags"))
+	articles.SchemaRegister(v1.Group("/schema"))
 	users.ProfileRetrieveRegister(v1.Group("/profiles"))
+	articles.ProfileArticlesRegister(v1.Group("/profiles"))
 
 	v1.Use(users.AuthMiddleware(true))
 	users.UserRegister(v1.Group("/user"))
+	articles.UserArticlesRegister(v1.Group("/user"))
 	users.ProfileRegister(v1.Group("/profiles"))
+	users.TokenRegister(v1.Group("/token"))
 
 	articles.ArticlesRegister(v1.Group("/articles"))
+	admin := v1.Group("/admin", users.AdminMiddleware())
+	articles.AdminRegister(admin)
+	users.AdminUsersRegister(admin)
 
 	testAuth := r.Group("/api/ping")
 
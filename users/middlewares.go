@@ -1,11 +1,11 @@
 package users
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/gothinkster/golang-gin-realworld-example-app/common"
 )
 
@@ -30,7 +30,7 @@ func extractToken(c *gin.Context) string {
 func UpdateContextUserModel(c *gin.Context, my_user_id uint) {
 	var myUserModel UserModel
 	if my_user_id != 0 {
-		db := common.GetDB()
+		db := common.MustGetDB()
 		db.First(&myUserModel, my_user_id)
 	}
 	c.Set("my_user_id", my_user_id)
@@ -52,24 +52,54 @@ func AuthMiddleware(auto401 bool) gin.HandlerFunc {
 			return
 		}
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// Validate the signing method
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, jwt.ErrSignatureInvalid
+		claims, err := common.VerifyToken(tokenString)
+		if err != nil {
+			if auto401 {
+				c.AbortWithStatus(http.StatusUnauthorized)
 			}
-			return []byte(common.JWTSecret), nil
-		})
+			return
+		}
 
-		if err != nil {
+		my_user_id := uint(claims["id"].(float64))
+		UpdateContextUserModel(c, my_user_id)
+		if c.MustGet("my_user_model").(UserModel).revokesToken(claims) {
+			UpdateContextUserModel(c, 0)
 			if auto401 {
 				c.AbortWithStatus(http.StatusUnauthorized)
 			}
 			return
 		}
+		if c.MustGet("my_user_model").(UserModel).Disabled {
+			c.AbortWithStatusJSON(http.StatusForbidden, common.NewError("user", errors.New("account disabled")))
+			return
+		}
+	}
+}
 
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			my_user_id := uint(claims["id"].(float64))
-			UpdateContextUserModel(c, my_user_id)
+// LastActiveMiddleware records the activity of authenticated users, put it after AuthMiddleware.
+//
+//	r.Use(AuthMiddleware(false), LastActiveMiddleware())
+func LastActiveMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if userID, ok := c.Get("my_user_id"); ok {
+			TouchLastActive(userID.(uint))
+		}
+	}
+}
+
+// AdminMiddleware only lets admins through, put it after AuthMiddleware.
+//
+//	AdminRegister(v1.Group("/admin", AdminMiddleware()))
+func AdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		myUserModel := c.MustGet("my_user_model").(UserModel)
+		if myUserModel.ID == 0 {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		if !myUserModel.Admin {
+			c.AbortWithStatusJSON(http.StatusForbidden, common.NewError("admin", errors.New("admin privileges required")))
+			return
 		}
 	}
 }
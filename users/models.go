@@ -1,8 +1,19 @@
 package users
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/gothinkster/golang-gin-realworld-example-app/common"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
@@ -20,6 +31,13 @@ type UserModel struct {
 	Bio          string  `gorm:"column:bio;size:1024"`
 	Image        *string `gorm:"column:image"`
 	PasswordHash string  `gorm:"column:password;not null"`
+	CreatedAt    time.Time
+	LastActiveAt *time.Time `gorm:"column:last_active_at"`
+	Admin        bool       `gorm:"column:admin;not null;default:false"`
+	Disabled     bool       `gorm:"column:disabled;not null;default:false"`
+
+	// PasswordChangedAt is set by a password reset, tokens issued before it are revoked
+	PasswordChangedAt *time.Time `gorm:"column:password_changed_at"`
 }
 
 // A hack way to save ManyToMany relationship,
@@ -41,12 +59,22 @@ type FollowModel struct {
 	FollowedByID uint
 }
 
+// PasswordResetModel is an outstanding password reset of a user. The token itself is only
+// ever emailed, the table keeps the HMAC of its secret part.
+type PasswordResetModel struct {
+	gorm.Model
+	UserID     uint      `gorm:"index"`
+	SecretHash string    `gorm:"not null"`
+	ExpiresAt  time.Time `gorm:"not null"`
+}
+
 // Migrate the schema of database if needed
 func AutoMigrate() {
-	db := common.GetDB()
+	db := common.MustGetDB()
 
 	db.AutoMigrate(&UserModel{})
 	db.AutoMigrate(&FollowModel{})
+	db.AutoMigrate(&PasswordResetModel{})
 }
 
 // What's bcrypt? https://en.wikipedia.org/wiki/Bcrypt
@@ -78,7 +106,7 @@ func (u *UserModel) checkPassword(password string) error {
 //
 //	userModel, err := FindOneUser(&UserModel{Username: "username0"})
 func FindOneUser(condition interface{}) (UserModel, error) {
-	db := common.GetDB()
+	db := common.MustGetDB()
 	var model UserModel
 	err := db.Where(condition).First(&model).Error
 	return model, err
@@ -88,7 +116,7 @@ func FindOneUser(condition interface{}) (UserModel, error) {
 //
 //	if err := SaveOne(&userModel); err != nil { ... }
 func SaveOne(data interface{}) error {
-	db := common.GetDB()
+	db := common.MustGetDB()
 	err := db.Save(data).Error
 	return err
 }
@@ -97,16 +125,93 @@ func SaveOne(data interface{}) error {
 //
 //	err := db.Model(userModel).Updates(UserModel{Username: "wangzitian0"}).Error
 func (model *UserModel) Update(data interface{}) error {
-	db := common.GetDB()
+	db := common.MustGetDB()
 	err := db.Model(model).Updates(data).Error
 	return err
 }
 
+// ErrUsernameTaken is returned when a user tries to take a username that belongs to someone else.
+var ErrUsernameTaken = errors.New("has already been taken")
+
+// You could update an UserModel like Update does, but a changed username is checked against
+// other users (case-insensitively) within the same transaction as the update.
+//
+//	err := userModel.UpdateWithUniqueUsername(UserModel{Username: "wangzitian0"})
+func (model *UserModel) UpdateWithUniqueUsername(data UserModel) error {
+	db := common.MustGetDB()
+	return db.Transaction(func(tx *gorm.DB) error {
+		if data.Username != "" && data.Username != model.Username {
+			var count int64
+			err := tx.Model(&UserModel{}).
+				Where("LOWER(username) = LOWER(?) AND id <> ?", data.Username, model.ID).
+				Count(&count).Error
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				return ErrUsernameTaken
+			}
+		}
+		return tx.Model(model).Updates(data).Error
+	})
+}
+
+// lastActiveTouches remembers when this process last wrote LastActiveAt per user,
+// so busy users don't cause a write on every request. Entries older than the interval
+// no longer hold anything back, they are swept out at most once per interval.
+var lastActiveTouches = struct {
+	sync.Mutex
+	at    map[uint]time.Time
+	swept time.Time
+}{at: make(map[uint]time.Time)}
+
+func lastActiveInterval() time.Duration {
+	return common.GetEnvDuration("LAST_ACTIVE_INTERVAL", 5*time.Minute)
+}
+
+// You could record that a user is active; it writes at most once per LAST_ACTIVE_INTERVAL.
+//
+//	TouchLastActive(userModel.ID)
+func TouchLastActive(userID uint) {
+	if userID == 0 {
+		return
+	}
+	interval := lastActiveInterval()
+	now := time.Now()
+	lastActiveTouches.Lock()
+	if last, ok := lastActiveTouches.at[userID]; ok && now.Sub(last) < interval {
+		lastActiveTouches.Unlock()
+		return
+	}
+	lastActiveTouches.at[userID] = now
+	if now.Sub(lastActiveTouches.swept) >= interval {
+		for id, last := range lastActiveTouches.at {
+			if now.Sub(last) >= interval {
+				delete(lastActiveTouches.at, id)
+			}
+		}
+		lastActiveTouches.swept = now
+	}
+	lastActiveTouches.Unlock()
+
+	db := common.MustGetDB()
+	db.Model(&UserModel{}).
+		Where("id = ? AND (last_active_at IS NULL OR last_active_at < ?)", userID, now.Add(-interval)).
+		UpdateColumn("last_active_at", now)
+}
+
+// SetUserDisabled suspends or reinstates an account, its data stays untouched.
+// AuthMiddleware rejects the tokens of disabled users.
+func SetUserDisabled(userID uint, disabled bool) error {
+	db := common.MustGetDB()
+	return db.Model(&UserModel{}).Where("id = ?", userID).UpdateColumn("disabled", disabled).Error
+}
+
 // You could add a following relationship as userModel1 following userModel2
 //
 //	err = userModel1.following(userModel2)
 func (u UserModel) following(v UserModel) error {
-	db := common.GetDB()
+	db := common.MustGetDB()
 	var follow FollowModel
 	err := db.FirstOrCreate(&follow, &FollowModel{
 		FollowingID:  v.ID,
@@ -119,7 +224,7 @@ func (u UserModel) following(v UserModel) error {
 //
 //	followingBool = myUserModel.isFollowing(self.UserModel)
 func (u UserModel) isFollowing(v UserModel) bool {
-	db := common.GetDB()
+	db := common.MustGetDB()
 	var follow FollowModel
 	db.Where(FollowModel{
 		FollowingID:  v.ID,
@@ -132,16 +237,56 @@ func (u UserModel) isFollowing(v UserModel) bool {
 //
 //	err = userModel1.unFollowing(userModel2)
 func (u UserModel) unFollowing(v UserModel) error {
-	db := common.GetDB()
+	db := common.MustGetDB()
 	err := db.Where("following_id = ? AND followed_by_id = ?", v.ID, u.ID).Delete(&FollowModel{}).Error
 	return err
 }
 
+// You could remove several following relationships of one follower in a single transaction.
+//
+//	err := BatchUnfollow(userModel.ID, []uint{2, 3})
+func BatchUnfollow(followerID uint, followingIDs []uint) error {
+	if len(followingIDs) == 0 {
+		return nil
+	}
+	db := common.MustGetDB()
+	return db.Transaction(func(tx *gorm.DB) error {
+		return tx.Where("followed_by_id = ? AND following_id IN ?", followerID, followingIDs).Delete(&FollowModel{}).Error
+	})
+}
+
+// You could add several following relationships of one follower in a single transaction,
+// existing follows and the follower itself are skipped.
+//
+//	err := BatchFollow(userModel.ID, []uint{2, 3})
+func BatchFollow(followerID uint, followingIDs []uint) error {
+	if len(followingIDs) == 0 {
+		return nil
+	}
+	db := common.MustGetDB()
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, followingID := range followingIDs {
+			if followingID == followerID {
+				continue
+			}
+			var follow FollowModel
+			err := tx.FirstOrCreate(&follow, &FollowModel{
+				FollowingID:  followingID,
+				FollowedByID: followerID,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 // You could get a following list of userModel
 //
 //	followings := userModel.GetFollowings()
 func (u UserModel) GetFollowings() []UserModel {
-	db := common.GetDB()
+	db := common.MustGetDB()
 	var follows []FollowModel
 	var followings []UserModel
 	db.Preload("Following").Where(FollowModel{
@@ -152,3 +297,210 @@ func (u UserModel) GetFollowings() []UserModel {
 	}
 	return followings
 }
+
+// ListFollowers returns a page of the users following userID, most recent follow first, with the total count.
+func ListFollowers(userID uint, limit, offset int) ([]UserModel, int, error) {
+	return listFollows("follow_models.followed_by_id", "follow_models.following_id", userID, limit, offset)
+}
+
+// ListFollowing returns a page of the users userID follows, most recent follow first, with the total count.
+func ListFollowing(userID uint, limit, offset int) ([]UserModel, int, error) {
+	return listFollows("follow_models.following_id", "follow_models.followed_by_id", userID, limit, offset)
+}
+
+// listFollows pages through the users on the listed side of the follows whose other side is userID.
+func listFollows(listedColumn, userColumn string, userID uint, limit, offset int) ([]UserModel, int, error) {
+	db := common.MustGetDB()
+	userModels := make([]UserModel, 0)
+	query := db.Model(&UserModel{}).
+		Joins("JOIN follow_models ON "+listedColumn+" = user_models.id AND follow_models.deleted_at IS NULL").
+		Where(userColumn+" = ?", userID)
+	var count int64
+	if err := query.Session(&gorm.Session{}).Count(&count).Error; err != nil {
+		return userModels, 0, err
+	}
+	err := query.Order("follow_models.created_at desc, follow_models.id desc").
+		Offset(offset).Limit(limit).
+		Find(&userModels).Error
+	return userModels, int(count), err
+}
+
+// FollowCounts holds how many users a user follows and is followed by.
+type FollowCounts struct {
+	Following int
+	Followers int
+}
+
+// You could get the follow counts of many users with two grouped queries instead of one per user.
+//
+//	counts := BatchGetFollowCounts([]uint{1, 2})
+func BatchGetFollowCounts(userIDs []uint) map[uint]FollowCounts {
+	countMap := make(map[uint]FollowCounts)
+	if len(userIDs) == 0 {
+		return countMap
+	}
+	db := common.MustGetDB()
+
+	type result struct {
+		UserID uint
+		Count  int
+	}
+	var following, followers []result
+	db.Model(&FollowModel{}).
+		Select("followed_by_id AS user_id, COUNT(*) AS count").
+		Where("followed_by_id IN ?", userIDs).
+		Group("followed_by_id").
+		Scan(&following)
+	db.Model(&FollowModel{}).
+		Select("following_id AS user_id, COUNT(*) AS count").
+		Where("following_id IN ?", userIDs).
+		Group("following_id").
+		Scan(&followers)
+
+	for _, r := range following {
+		counts := countMap[r.UserID]
+		counts.Following = r.Count
+		countMap[r.UserID] = counts
+	}
+	for _, r := range followers {
+		counts := countMap[r.UserID]
+		counts.Followers = r.Count
+		countMap[r.UserID] = counts
+	}
+	return countMap
+}
+
+// You could check which of many users the viewer is following with a single query.
+//
+//	status := BatchGetFollowingStatus(viewer.ID, []uint{1, 2})
+func BatchGetFollowingStatus(viewerID uint, userIDs []uint) map[uint]bool {
+	statusMap := make(map[uint]bool)
+	if len(userIDs) == 0 || viewerID == 0 {
+		return statusMap
+	}
+	db := common.MustGetDB()
+	var follows []FollowModel
+	db.Where("followed_by_id = ? AND following_id IN ?", viewerID, userIDs).Find(&follows)
+	for _, follow := range follows {
+		statusMap[follow.FollowingID] = true
+	}
+	return statusMap
+}
+
+// You could get the users that both viewer and target are following, as profiles seen by the viewer.
+//
+//	profiles, err := MutualFollows(viewer.ID, target.ID)
+func MutualFollows(viewerID, targetID uint) ([]ProfileResponse, error) {
+	db := common.MustGetDB()
+	profiles := []ProfileResponse{}
+	targetFollowings := db.Model(&FollowModel{}).Select("following_id").Where("followed_by_id = ?", targetID)
+	var userModels []UserModel
+	err := db.Where("id IN (?)", db.Model(&FollowModel{}).
+		Select("following_id").
+		Where("followed_by_id = ? AND following_id IN (?)", viewerID, targetFollowings)).
+		Order("id").
+		Find(&userModels).Error
+	if err != nil {
+		return profiles, err
+	}
+	var userIDs []uint
+	for _, userModel := range userModels {
+		userIDs = append(userIDs, userModel.ID)
+	}
+	followCounts := BatchGetFollowCounts(userIDs)
+	for _, userModel := range userModels {
+		serializer := ProfileSerializer{UserModel: userModel}
+		profiles = append(profiles, serializer.ResponseWithPreloaded(true, followCounts[userModel.ID]))
+	}
+	return profiles, nil
+}
+
+// ErrInvalidResetToken is returned for a password reset token that is malformed, unknown,
+// already used or expired.
+var ErrInvalidResetToken = errors.New("is invalid or has expired")
+
+// passwordResetTTL reads PASSWORD_RESET_TTL, how long a reset token stays valid, 1h by default.
+func passwordResetTTL() time.Duration {
+	return common.GetEnvDuration("PASSWORD_RESET_TTL", time.Hour)
+}
+
+// signResetSecret is the HMAC stored for the secret part of a reset token.
+func signResetSecret(secret string) string {
+	mac := hmac.New(sha256.New, []byte(common.JWTSecret))
+	mac.Write([]byte(secret))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// You could issue a password reset token for a user, it replaces the outstanding one if any.
+// The token reads "<reset id>.<secret>".
+//
+//	token, err := CreatePasswordReset(userModel.ID)
+func CreatePasswordReset(userID uint) (string, error) {
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(secretBytes)
+	reset := PasswordResetModel{UserID: userID, SecretHash: signResetSecret(secret), ExpiresAt: time.Now().Add(passwordResetTTL())}
+	db := common.MustGetDB()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&PasswordResetModel{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&reset).Error
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d.%s", reset.ID, secret), nil
+}
+
+// You could set a new password with a token from CreatePasswordReset, which spends it and
+// revokes the user's earlier JWTs. It returns the user whose password changed, or ErrInvalidResetToken.
+//
+//	userModel, err := ResetPassword(token, "password1")
+func ResetPassword(token, password string) (UserModel, error) {
+	var userModel UserModel
+	idPart, secret, ok := strings.Cut(token, ".")
+	id, err := strconv.ParseUint(idPart, 10, 32)
+	if !ok || err != nil || secret == "" {
+		return userModel, ErrInvalidResetToken
+	}
+	db := common.MustGetDB()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var reset PasswordResetModel
+		if err := tx.First(&reset, uint(id)).Error; err != nil {
+			return ErrInvalidResetToken
+		}
+		if !common.SecureCompare(signResetSecret(secret), reset.SecretHash) || time.Now().After(reset.ExpiresAt) {
+			return ErrInvalidResetToken
+		}
+		if err := tx.First(&userModel, reset.UserID).Error; err != nil || userModel.Disabled {
+			return ErrInvalidResetToken
+		}
+		if err := userModel.setPassword(password); err != nil {
+			return err
+		}
+		now := time.Now()
+		userModel.PasswordChangedAt = &now
+		err := tx.Model(&userModel).UpdateColumns(map[string]interface{}{
+			"password":            userModel.PasswordHash,
+			"password_changed_at": now,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userModel.ID).Delete(&PasswordResetModel{}).Error
+	})
+	return userModel, err
+}
+
+// revokesToken reports whether the token with these claims was issued before the user's last
+// password reset. Tokens without an issue time predate the check and count as revoked then.
+func (u UserModel) revokesToken(claims jwt.MapClaims) bool {
+	if u.PasswordChangedAt == nil {
+		return false
+	}
+	issuedAt, err := claims.GetIssuedAt()
+	return err != nil || issuedAt == nil || issuedAt.Unix() < u.PasswordChangedAt.Unix()
+}
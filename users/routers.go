@@ -4,13 +4,20 @@ import (
 	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/gothinkster/golang-gin-realworld-example-app/common"
+	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
 )
 
 func UsersRegister(router *gin.RouterGroup) {
 	router.POST("", UsersRegistration)
 	router.POST("/", UsersRegistration)
 	router.POST("/login", UsersLogin)
+	router.POST("/password-reset", UsersPasswordReset)
+	router.POST("/password-reset/confirm", UsersPasswordResetConfirm)
 }
 
 func UserRegister(router *gin.RouterGroup) {
@@ -20,15 +27,65 @@ func UserRegister(router *gin.RouterGroup) {
 	router.PUT("/", UserUpdate)
 }
 
+func TokenRegister(router *gin.RouterGroup) {
+	router.GET("/introspect", TokenIntrospect)
+}
+
+// TokenAnonymousRegister adds the token routes that work without a valid token, register
+// them before any auth middleware.
+func TokenAnonymousRegister(router *gin.RouterGroup) {
+	router.POST("/verify", TokenVerify)
+}
+
+// AdminUsersRegister adds the admin-only user routes, the group must be guarded by AdminMiddleware.
+func AdminUsersRegister(router *gin.RouterGroup) {
+	router.POST("/users/:username/disable", AdminUserDisable)
+	router.POST("/users/:username/enable", AdminUserEnable)
+}
+
 func ProfileRetrieveRegister(router *gin.RouterGroup) {
 	router.GET("/:username", ProfileRetrieve)
+	router.GET("/:username/activity", ProfileActivity)
+	router.GET("/:username/followers", ProfileFollowers)
+	router.GET("/:username/following", ProfileFollowing)
 }
 
 func ProfileRegister(router *gin.RouterGroup) {
 	router.POST("/:username/follow", ProfileFollow)
 	router.DELETE("/:username/follow", ProfileUnfollow)
+	router.GET("/:username/mutual", ProfileMutual)
+	router.POST("/follow/batch", ProfileBatchFollow)
+	router.POST("/unfollow/batch", ProfileBatchUnfollow)
+}
+
+func AdminUserDisable(c *gin.Context) {
+	setUserDisabled(c, true)
+}
+
+func AdminUserEnable(c *gin.Context) {
+	setUserDisabled(c, false)
+}
+
+// setUserDisabled suspends or reinstates the account named in the path.
+func setUserDisabled(c *gin.Context, disabled bool) {
+	username := c.Param("username")
+	userModel, err := FindOneUser(&UserModel{Username: username})
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("profile", errors.New("Invalid username")))
+		return
+	}
+	if err := SetUserDisabled(userModel.ID, disabled); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"user": gin.H{"username": userModel.Username, "disabled": disabled}})
 }
 
+// ProfileArticles renders a profile with the user's recent articles embedded for
+// GET /profiles/:username?includeArticles=true. The articles package sets it when
+// registering its profile routes, since users cannot import articles.
+var ProfileArticles func(c *gin.Context, userModel UserModel) (interface{}, error)
+
 func ProfileRetrieve(c *gin.Context) {
 	username := c.Param("username")
 	userModel, err := FindOneUser(&UserModel{Username: username})
@@ -36,10 +93,69 @@ func ProfileRetrieve(c *gin.Context) {
 		c.JSON(http.StatusNotFound, common.NewError("profile", errors.New("Invalid username")))
 		return
 	}
+	if c.Query("includeArticles") == "true" && ProfileArticles != nil {
+		profile, err := ProfileArticles(c, userModel)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"profile": profile})
+		return
+	}
 	profileSerializer := ProfileSerializer{c, userModel}
 	c.JSON(http.StatusOK, gin.H{"profile": profileSerializer.Response()})
 }
 
+func ProfileActivity(c *gin.Context) {
+	username := c.Param("username")
+	userModel, err := FindOneUser(&UserModel{Username: username})
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("profile", errors.New("Invalid username")))
+		return
+	}
+	serializer := ActivitySerializer{c, userModel}
+	c.JSON(http.StatusOK, gin.H{"activity": serializer.Response()})
+}
+
+func ProfileFollowers(c *gin.Context) {
+	profileFollowList(c, ListFollowers)
+}
+
+func ProfileFollowing(c *gin.Context) {
+	profileFollowList(c, ListFollowing)
+}
+
+// profileFollowList answers with the page of users list returns for the profile in the path.
+func profileFollowList(c *gin.Context, list func(userID uint, limit, offset int) ([]UserModel, int, error)) {
+	username := c.Param("username")
+	userModel, err := FindOneUser(&UserModel{Username: username})
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("profile", errors.New("Invalid username")))
+		return
+	}
+	limit, offset := parseLimitOffset(c.Query("limit"), c.Query("offset"))
+	userModels, count, err := list(userModel.ID, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	serializer := ProfilesSerializer{c, userModels}
+	c.JSON(http.StatusOK, gin.H{"profiles": serializer.Response(), "profilesCount": count})
+}
+
+// parseLimitOffset reads the paging query values, falling back to limit 20 and offset 0 when they are not numbers.
+func parseLimitOffset(limit, offset string) (int, int) {
+	offsetInt, err := strconv.Atoi(offset)
+	if err != nil {
+		offsetInt = 0
+	}
+	limitInt, err := strconv.Atoi(limit)
+	if err != nil {
+		limitInt = 20
+	}
+	return limitInt, offsetInt
+}
+
 func ProfileFollow(c *gin.Context) {
 	username := c.Param("username")
 	userModel, err := FindOneUser(&UserModel{Username: username})
@@ -75,6 +191,106 @@ func ProfileUnfollow(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"profile": serializer.Response()})
 }
 
+func ProfileMutual(c *gin.Context) {
+	username := c.Param("username")
+	userModel, err := FindOneUser(&UserModel{Username: username})
+	if err != nil {
+		c.JSON(http.StatusNotFound, common.NewError("profile", errors.New("Invalid username")))
+		return
+	}
+	myUserModel := c.MustGet("my_user_model").(UserModel)
+	profiles, err := MutualFollows(myUserModel.ID, userModel.ID)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"profiles": profiles})
+}
+
+// findUsersByUsernames splits usernames into found user models and unknown names,
+// keeping the request order.
+func findUsersByUsernames(usernames []string) ([]UserModel, []string, error) {
+	db := common.MustGetDB()
+	var userModels []UserModel
+	if err := db.Where("username IN ?", usernames).Find(&userModels).Error; err != nil {
+		return nil, nil, err
+	}
+	byName := make(map[string]UserModel)
+	for _, userModel := range userModels {
+		byName[userModel.Username] = userModel
+	}
+	found := []UserModel{}
+	unknown := []string{}
+	seen := make(map[string]bool)
+	for _, username := range usernames {
+		if seen[username] {
+			continue
+		}
+		seen[username] = true
+		if userModel, ok := byName[username]; ok {
+			found = append(found, userModel)
+		} else {
+			unknown = append(unknown, username)
+		}
+	}
+	return found, unknown, nil
+}
+
+func ProfileBatchFollow(c *gin.Context) {
+	validator := NewUsernamesValidator()
+	if err := validator.Bind(c); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		return
+	}
+	found, unknown, err := findUsersByUsernames(validator.Usernames)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	myUserModel := c.MustGet("my_user_model").(UserModel)
+	followed := []string{}
+	self := []string{}
+	var followingIDs []uint
+	for _, userModel := range found {
+		if userModel.ID == myUserModel.ID {
+			self = append(self, userModel.Username)
+			continue
+		}
+		followingIDs = append(followingIDs, userModel.ID)
+		followed = append(followed, userModel.Username)
+	}
+	if err := BatchFollow(myUserModel.ID, followingIDs); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"followed": followed, "unknown": unknown, "self": self})
+}
+
+func ProfileBatchUnfollow(c *gin.Context) {
+	validator := NewUsernamesValidator()
+	if err := validator.Bind(c); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		return
+	}
+	found, unknown, err := findUsersByUsernames(validator.Usernames)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	myUserModel := c.MustGet("my_user_model").(UserModel)
+	unfollowed := []string{}
+	var followingIDs []uint
+	for _, userModel := range found {
+		followingIDs = append(followingIDs, userModel.ID)
+		unfollowed = append(unfollowed, userModel.Username)
+	}
+	if err := BatchUnfollow(myUserModel.ID, followingIDs); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"unfollowed": unfollowed, "unknown": unknown})
+}
+
 func UsersRegistration(c *gin.Context) {
 	userModelValidator := NewUserModelValidator()
 	if err := userModelValidator.Bind(c); err != nil {
@@ -108,6 +324,78 @@ func UsersLogin(c *gin.Context) {
 		c.JSON(http.StatusUnauthorized, common.NewError("login", errors.New("Not Registered email or invalid password")))
 		return
 	}
+	if userModel.Disabled {
+		c.JSON(http.StatusForbidden, common.NewError("user", errors.New("account disabled")))
+		return
+	}
+	UpdateContextUserModel(c, userModel.ID)
+	serializer := UserSerializer{c}
+	c.JSON(http.StatusOK, gin.H{"user": serializer.Response()})
+}
+
+// PasswordResetMailer emails a password reset token to its user, main sets it with
+// DefaultPasswordResetMailer. Without one POST /users/password-reset answers 503.
+var PasswordResetMailer func(userModel UserModel, token string) error
+
+// DefaultPasswordResetMailer emails reset tokens with common.SendMail. It returns nil when
+// SMTP is not configured, reset tokens are never written anywhere but the user's inbox.
+func DefaultPasswordResetMailer() func(userModel UserModel, token string) error {
+	if common.MailConfigured() {
+		return mailPasswordReset
+	}
+	return nil
+}
+
+// mailPasswordReset emails the token, with a link to the site when SITE_BASE_URL is set.
+func mailPasswordReset(userModel UserModel, token string) error {
+	body := "Hello " + userModel.Username + ",\n\nUse this token to choose a new password: " + token + "\n"
+	if baseURL := strings.TrimRight(os.Getenv("SITE_BASE_URL"), "/"); baseURL != "" {
+		body += "\nOr follow " + baseURL + "/reset-password?token=" + url.QueryEscape(token) + "\n"
+	}
+	body += "\nThe token expires in " + passwordResetTTL().String() + ". Ignore this email if you did not ask for it.\n"
+	return common.SendMail(userModel.Email, "Reset your password", body)
+}
+
+func UsersPasswordReset(c *gin.Context) {
+	validator := NewPasswordResetValidator()
+	if err := validator.Bind(c); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		return
+	}
+	if PasswordResetMailer == nil {
+		c.JSON(http.StatusServiceUnavailable, common.NewError("passwordReset", errors.New("email delivery is not configured")))
+		return
+	}
+	// Unknown and disabled accounts get the same answer, so the endpoint cannot be used to probe for emails
+	userModel, err := FindOneUser(&UserModel{Email: validator.User.Email})
+	if err == nil && !userModel.Disabled {
+		token, err := CreatePasswordReset(userModel.ID)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+			return
+		}
+		if err := PasswordResetMailer(userModel, token); err != nil {
+			log.Printf("failed to send password reset to user %d: %v", userModel.ID, err)
+		}
+	}
+	c.JSON(http.StatusAccepted, gin.H{"passwordReset": gin.H{"email": validator.User.Email}})
+}
+
+func UsersPasswordResetConfirm(c *gin.Context) {
+	validator := NewPasswordResetConfirmValidator()
+	if err := validator.Bind(c); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		return
+	}
+	userModel, err := ResetPassword(validator.User.Token, validator.User.Password)
+	if errors.Is(err, ErrInvalidResetToken) {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("token", err))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
+		return
+	}
 	UpdateContextUserModel(c, userModel.ID)
 	serializer := UserSerializer{c}
 	c.JSON(http.StatusOK, gin.H{"user": serializer.Response()})
@@ -127,7 +415,11 @@ func UserUpdate(c *gin.Context) {
 	}
 
 	userModelValidator.userModel.ID = myUserModel.ID
-	if err := myUserModel.Update(userModelValidator.userModel); err != nil {
+	if err := myUserModel.UpdateWithUniqueUsername(userModelValidator.userModel); err != nil {
+		if errors.Is(err, ErrUsernameTaken) {
+			c.JSON(http.StatusConflict, common.NewError("username", err))
+			return
+		}
 		c.JSON(http.StatusUnprocessableEntity, common.NewError("database", err))
 		return
 	}
@@ -135,3 +427,50 @@ func UserUpdate(c *gin.Context) {
 	serializer := UserSerializer{c}
 	c.JSON(http.StatusOK, gin.H{"user": serializer.Response()})
 }
+
+// TokenIntrospect returns the decoded claims of the request token, never the token or secret itself.
+func TokenIntrospect(c *gin.Context) {
+	claims, err := common.VerifyToken(extractToken(c))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, common.NewError("token", errors.New("invalid token")))
+		return
+	}
+	response := gin.H{}
+	for _, key := range []string{"id", "exp", "iat", "iss"} {
+		if value, ok := claims[key]; ok {
+			response[key] = value
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"claims": response})
+}
+
+// TokenVerify tells whether the posted token is valid, answering 200 either way so
+// clients can check a token without triggering their 401 handling.
+func TokenVerify(c *gin.Context) {
+	validator := NewTokenVerifyValidator()
+	if err := validator.Bind(c); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.NewValidatorError(err))
+		return
+	}
+	claims, err := common.VerifyToken(validator.Token)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"valid": false})
+		return
+	}
+	// The signature alone is not enough, valid means the auth middleware would let it in
+	id, ok := claims["id"].(float64)
+	if !ok || id < 1 {
+		c.JSON(http.StatusOK, gin.H{"valid": false})
+		return
+	}
+	userModel, err := FindOneUser(&UserModel{ID: uint(id)})
+	if err != nil || userModel.Disabled || userModel.revokesToken(claims) {
+		c.JSON(http.StatusOK, gin.H{"valid": false})
+		return
+	}
+	response := gin.H{"valid": true}
+	if expiresAt, err := claims.GetExpirationTime(); err == nil && expiresAt != nil {
+		response["expiresAt"] = expiresAt.UTC().Format("2006-01-02T15:04:05.999Z")
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -13,30 +13,65 @@ type ProfileSerializer struct {
 
 // Declare your response schema here
 type ProfileResponse struct {
-	ID        uint   `json:"-"`
-	Username  string `json:"username"`
-	Bio       string `json:"bio"`
-	Image     string `json:"image"`
-	Following bool   `json:"following"`
+	ID             uint   `json:"-"`
+	Username       string `json:"username"`
+	Bio            string `json:"bio"`
+	Image          string `json:"image"`
+	Following      bool   `json:"following"`
+	FollowingCount int    `json:"followingCount"`
+	FollowersCount int    `json:"followersCount"`
 }
 
 // Put your response logic including wrap the userModel here.
 func (self *ProfileSerializer) Response() ProfileResponse {
 	myUserModel := self.C.MustGet("my_user_model").(UserModel)
+	followCounts := BatchGetFollowCounts([]uint{self.ID})
+	return self.ResponseWithPreloaded(myUserModel.isFollowing(self.UserModel), followCounts[self.ID])
+}
+
+// ResponseWithPreloaded creates response using batch-loaded follow data to avoid N+1 queries in lists.
+func (self *ProfileSerializer) ResponseWithPreloaded(following bool, counts FollowCounts) ProfileResponse {
 	image := ""
 	if self.Image != nil {
 		image = *self.Image
 	}
 	profile := ProfileResponse{
-		ID:        self.ID,
-		Username:  self.Username,
-		Bio:       self.Bio,
-		Image:     image,
-		Following: myUserModel.isFollowing(self.UserModel),
+		ID:             self.ID,
+		Username:       self.Username,
+		Bio:            self.Bio,
+		Image:          image,
+		Following:      following,
+		FollowingCount: counts.Following,
+		FollowersCount: counts.Followers,
 	}
 	return profile
 }
 
+// ProfilesSerializer renders a list of users with the follow data of all of them batch-loaded.
+type ProfilesSerializer struct {
+	C     *gin.Context
+	Users []UserModel
+}
+
+func (self *ProfilesSerializer) Response() []ProfileResponse {
+	response := make([]ProfileResponse, 0, len(self.Users))
+	if len(self.Users) == 0 {
+		return response
+	}
+	var userIDs []uint
+	for _, userModel := range self.Users {
+		userIDs = append(userIDs, userModel.ID)
+	}
+	myUserModel := self.C.MustGet("my_user_model").(UserModel)
+	followingStatus := BatchGetFollowingStatus(myUserModel.ID, userIDs)
+	followCounts := BatchGetFollowCounts(userIDs)
+	for _, userModel := range self.Users {
+		serializer := ProfileSerializer{self.C, userModel}
+		response = append(response, serializer.ResponseWithPreloaded(followingStatus[userModel.ID], followCounts[userModel.ID]))
+	}
+	return response
+}
+
 type UserSerializer struct {
 	c *gin.Context
 }
@@ -64,3 +99,26 @@ func (self *UserSerializer) Response() UserResponse {
 	}
 	return user
 }
+
+type ActivitySerializer struct {
+	C *gin.Context
+	UserModel
+}
+
+type ActivityResponse struct {
+	Username     string  `json:"username"`
+	JoinedAt     string  `json:"joinedAt"`
+	LastActiveAt *string `json:"lastActiveAt"`
+}
+
+func (self *ActivitySerializer) Response() ActivityResponse {
+	response := ActivityResponse{
+		Username: self.Username,
+		JoinedAt: self.CreatedAt.UTC().Format("2006-01-02T15:04:05.999Z"),
+	}
+	if self.LastActiveAt != nil {
+		lastActiveAt := self.LastActiveAt.UTC().Format("2006-01-02T15:04:05.999Z")
+		response.LastActiveAt = &lastActiveAt
+	}
+	return response
+}
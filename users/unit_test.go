@@ -2,13 +2,17 @@ package users
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/gothinkster/golang-gin-realworld-example-app/common"
 	"github.com/stretchr/testify/assert"
 	"gorm.io/gorm"
@@ -42,6 +46,8 @@ func userModelMocker(n int) []UserModel {
 		}
 		userModel.setPassword("password123")
 		test_db.Create(&userModel)
+		// Reload so timestamps match what later queries return
+		test_db.First(&userModel, userModel.ID)
 		ret = append(ret, userModel)
 	}
 	return ret
@@ -120,7 +126,7 @@ var unauthRequestTests = []struct {
 		"POST",
 		`{"user":{"username": "wangzitian0","email": "wzt@gg.cn","password": "jakejxke"}}`,
 		http.StatusCreated,
-		`{"user":{"username":"wangzitian0","email":"wzt@gg.cn","bio":"","image":"","token":"([a-zA-Z0-9-_.]{115})"}}`,
+		`{"user":{"username":"wangzitian0","email":"wzt@gg.cn","bio":"","image":"","token":"([a-zA-Z0-9-_.]{137})"}}`,
 		"valid data and should return StatusCreated",
 	},
 	{
@@ -169,7 +175,7 @@ var unauthRequestTests = []struct {
 		"POST",
 		`{"user":{"email": "user1@linkedin.com","password": "password123"}}`,
 		http.StatusOK,
-		`{"user":{"username":"user1","email":"user1@linkedin.com","bio":"bio1","image":"http://image/1.jpg","token":"([a-zA-Z0-9-_.]{115})"}}`,
+		`{"user":{"username":"user1","email":"user1@linkedin.com","bio":"bio1","image":"http://image/1.jpg","token":"([a-zA-Z0-9-_.]{137})"}}`,
 		"right info login should return user",
 	},
 	{
@@ -240,7 +246,7 @@ var unauthRequestTests = []struct {
 		"GET",
 		``,
 		http.StatusOK,
-		`{"user":{"username":"user1","email":"user1@linkedin.com","bio":"bio1","image":"http://image/1.jpg","token":"([a-zA-Z0-9-_.]{115})"}}`,
+		`{"user":{"username":"user1","email":"user1@linkedin.com","bio":"bio1","image":"http://image/1.jpg","token":"([a-zA-Z0-9-_.]{137})"}}`,
 		"request should return current user with token",
 	},
 
@@ -253,7 +259,7 @@ var unauthRequestTests = []struct {
 		"GET",
 		``,
 		http.StatusOK,
-		`{"profile":{"username":"user1","bio":"bio1","image":"http://image/1.jpg","following":false}}`,
+		`{"profile":{"username":"user1","bio":"bio1","image":"http://image/1.jpg","following":false,"followingCount":0,"followersCount":0}}`,
 		"anonymous request should return profile with following=false",
 	},
 	{
@@ -265,7 +271,7 @@ var unauthRequestTests = []struct {
 		"GET",
 		``,
 		http.StatusOK,
-		`{"profile":{"username":"user1","bio":"bio1","image":"http://image/1.jpg","following":false}}`,
+		`{"profile":{"username":"user1","bio":"bio1","image":"http://image/1.jpg","following":false,"followingCount":0,"followersCount":0}}`,
 		"request should return self profile",
 	},
 	{
@@ -276,7 +282,7 @@ var unauthRequestTests = []struct {
 		"GET",
 		``,
 		http.StatusOK,
-		`{"profile":{"username":"user1","bio":"bio1","image":"http://image/1.jpg","following":false}}`,
+		`{"profile":{"username":"user1","bio":"bio1","image":"http://image/1.jpg","following":false,"followingCount":0,"followersCount":0}}`,
 		"request should return correct other's profile",
 	},
 
@@ -301,7 +307,7 @@ var unauthRequestTests = []struct {
 		"PUT",
 		`{"user":{"username":"user123","password": "password126","email":"user123@linkedin.com","bio":"bio123","image":"http://hehe/123.jpg"}}`,
 		http.StatusOK,
-		`{"user":{"username":"user123","email":"user123@linkedin.com","bio":"bio123","image":"http://hehe/123.jpg","token":"([a-zA-Z0-9-_.]{115})"}}`,
+		`{"user":{"username":"user123","email":"user123@linkedin.com","bio":"bio123","image":"http://hehe/123.jpg","token":"([a-zA-Z0-9-_.]{137})"}}`,
 		"current user profile should be changed",
 	},
 	{
@@ -312,7 +318,7 @@ var unauthRequestTests = []struct {
 		"GET",
 		``,
 		http.StatusOK,
-		`{"profile":{"username":"user123","bio":"bio123","image":"http://hehe/123.jpg","following":false}}`,
+		`{"profile":{"username":"user123","bio":"bio123","image":"http://hehe/123.jpg","following":false,"followingCount":0,"followersCount":0}}`,
 		"request should return self profile after changed",
 	},
 	{
@@ -321,7 +327,7 @@ var unauthRequestTests = []struct {
 		"POST",
 		`{"user":{"email": "user123@linkedin.com","password": "password126"}}`,
 		http.StatusOK,
-		`{"user":{"username":"user123","email":"user123@linkedin.com","bio":"bio123","image":"http://hehe/123.jpg","token":"([a-zA-Z0-9-_.]{115})"}}`,
+		`{"user":{"username":"user123","email":"user123@linkedin.com","bio":"bio123","image":"http://hehe/123.jpg","token":"([a-zA-Z0-9-_.]{137})"}}`,
 		"user should login using new password after changed",
 	},
 	{
@@ -421,7 +427,7 @@ var unauthRequestTests = []struct {
 		"POST",
 		``,
 		http.StatusOK,
-		`{"profile":{"username":"user1","bio":"bio1","image":"http://image/1.jpg","following":true}}`,
+		`{"profile":{"username":"user1","bio":"bio1","image":"http://image/1.jpg","following":true,"followingCount":0,"followersCount":1}}`,
 		"user follow another should work",
 	},
 	{
@@ -432,7 +438,7 @@ var unauthRequestTests = []struct {
 		"GET",
 		``,
 		http.StatusOK,
-		`{"profile":{"username":"user1","bio":"bio1","image":"http://image/1.jpg","following":true}}`,
+		`{"profile":{"username":"user1","bio":"bio1","image":"http://image/1.jpg","following":true,"followingCount":0,"followersCount":1}}`,
 		"user follow another should make sure database changed",
 	},
 	{
@@ -443,7 +449,7 @@ var unauthRequestTests = []struct {
 		"DELETE",
 		``,
 		http.StatusOK,
-		`{"profile":{"username":"user1","bio":"bio1","image":"http://image/1.jpg","following":false}}`,
+		`{"profile":{"username":"user1","bio":"bio1","image":"http://image/1.jpg","following":false,"followingCount":0,"followersCount":0}}`,
 		"user cancel follow another should work",
 	},
 	{
@@ -454,7 +460,7 @@ var unauthRequestTests = []struct {
 		"GET",
 		``,
 		http.StatusOK,
-		`{"profile":{"username":"user1","bio":"bio1","image":"http://image/1.jpg","following":false}}`,
+		`{"profile":{"username":"user1","bio":"bio1","image":"http://image/1.jpg","following":false,"followingCount":0,"followersCount":0}}`,
 		"user cancel follow another should make sure database changed",
 	},
 }
@@ -543,6 +549,587 @@ func TestAuthMiddlewareNoToken(t *testing.T) {
 	asserts.Contains(w.Body.String(), `"user_id":0`, "User ID should be 0")
 }
 
+func TestMutualFollows(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := gin.New()
+	r.Use(AuthMiddleware(true))
+	ProfileRegister(r.Group("/profiles"))
+
+	resetDBWithMock()
+	mocked := userModelMocker(5)
+	viewer, target, common1, viewerOnly, targetOnly := mocked[0], mocked[1], mocked[2], mocked[3], mocked[4]
+	viewer.following(common1)
+	viewer.following(viewerOnly)
+	target.following(common1)
+	target.following(targetOnly)
+
+	profiles, err := MutualFollows(viewer.ID, target.ID)
+	asserts.NoError(err)
+	asserts.Len(profiles, 1, "Only the commonly followed user should be returned")
+	asserts.Equal(common1.Username, profiles[0].Username)
+	asserts.True(profiles[0].Following)
+
+	req, _ := http.NewRequest("GET", "/profiles/"+target.Username+"/mutual", nil)
+	common.HeaderTokenMock(req, viewer.ID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Contains(w.Body.String(), `"username":"`+common1.Username+`"`)
+	asserts.NotContains(w.Body.String(), `"username":"`+viewerOnly.Username+`"`)
+	asserts.NotContains(w.Body.String(), `"username":"`+targetOnly.Username+`"`)
+
+	req, _ = http.NewRequest("GET", "/profiles/"+target.Username+"/mutual", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusUnauthorized, w.Code, "Anonymous viewer should get 401")
+}
+
+func TestBatchFollow(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := gin.New()
+	r.Use(AuthMiddleware(true))
+	ProfileRegister(r.Group("/profiles"))
+
+	resetDBWithMock()
+	mocked := userModelMocker(3)
+	follower, target1, target2 := mocked[0], mocked[1], mocked[2]
+	follower.following(target2)
+
+	body := fmt.Sprintf(`{"usernames":["%s","%s","ghost-user","%s"]}`, target1.Username, target2.Username, follower.Username)
+	req, _ := http.NewRequest("POST", "/profiles/follow/batch", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	common.HeaderTokenMock(req, follower.ID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal(fmt.Sprintf(`{"followed":["%s","%s"],"self":["%s"],"unknown":["ghost-user"]}`,
+		target1.Username, target2.Username, follower.Username), w.Body.String())
+	asserts.True(follower.isFollowing(target1))
+	asserts.True(follower.isFollowing(target2))
+	asserts.False(follower.isFollowing(follower), "users should not follow themselves")
+	asserts.Equal(2, len(follower.GetFollowings()), "existing follows should not be duplicated")
+
+	req, _ = http.NewRequest("POST", "/profiles/follow/batch", bytes.NewBufferString(`{"usernames":[]}`))
+	req.Header.Set("Content-Type", "application/json")
+	common.HeaderTokenMock(req, follower.ID)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusUnprocessableEntity, w.Code, "Empty usernames should return 422")
+
+	asserts.NoError(BatchFollow(target1.ID, []uint{target1.ID, follower.ID}))
+	asserts.Equal(1, len(target1.GetFollowings()))
+}
+
+func TestBatchUnfollow(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := gin.New()
+	r.Use(AuthMiddleware(true))
+	ProfileRegister(r.Group("/profiles"))
+
+	resetDBWithMock()
+	mocked := userModelMocker(4)
+	follower, followed1, followed2, notFollowed := mocked[0], mocked[1], mocked[2], mocked[3]
+	follower.following(followed1)
+	follower.following(followed2)
+
+	body := fmt.Sprintf(`{"usernames":["%s","%s","ghost-user"]}`, followed1.Username, notFollowed.Username)
+	req, _ := http.NewRequest("POST", "/profiles/unfollow/batch", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	common.HeaderTokenMock(req, follower.ID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Contains(w.Body.String(), `"unknown":["ghost-user"]`)
+	asserts.False(follower.isFollowing(followed1), "followed1 should be unfollowed")
+	asserts.True(follower.isFollowing(followed2), "followed2 should be untouched")
+	asserts.False(follower.isFollowing(notFollowed), "notFollowed should stay not followed")
+
+	req, _ = http.NewRequest("POST", "/profiles/unfollow/batch", bytes.NewBufferString(`{"usernames":[]}`))
+	req.Header.Set("Content-Type", "application/json")
+	common.HeaderTokenMock(req, follower.ID)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusUnprocessableEntity, w.Code, "Empty usernames should return 422")
+
+	asserts.NoError(BatchUnfollow(follower.ID, []uint{followed2.ID}))
+	asserts.Equal(0, len(follower.GetFollowings()))
+}
+
+func TestProfileFollowLists(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := gin.New()
+	r.Use(AuthMiddleware(false))
+	ProfileRetrieveRegister(r.Group("/profiles"))
+
+	resetDBWithMock()
+	mocked := userModelMocker(5)
+	user, viewer, fan1, fan2, fan3 := mocked[0], mocked[1], mocked[2], mocked[3], mocked[4]
+	for _, fan := range []UserModel{fan1, fan2, fan3} {
+		fan.following(user)
+	}
+	user.following(fan2)
+	viewer.following(fan3)
+
+	followers, count, err := ListFollowers(user.ID, 2, 0)
+	asserts.NoError(err)
+	asserts.Equal(3, count)
+	asserts.Equal([]string{fan3.Username, fan2.Username}, []string{followers[0].Username, followers[1].Username})
+	followers, _, err = ListFollowers(user.ID, 2, 2)
+	asserts.NoError(err)
+	asserts.Len(followers, 1)
+	asserts.Equal(fan1.Username, followers[0].Username)
+
+	following, count, err := ListFollowing(user.ID, 20, 0)
+	asserts.NoError(err)
+	asserts.Equal(1, count)
+	asserts.Equal(fan2.Username, following[0].Username)
+
+	list := func(path string) map[string]bool {
+		req, _ := http.NewRequest("GET", path, nil)
+		common.HeaderTokenMock(req, viewer.ID)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		asserts.Equal(http.StatusOK, w.Code)
+		var response struct {
+			Profiles      []ProfileResponse `json:"profiles"`
+			ProfilesCount int               `json:"profilesCount"`
+		}
+		asserts.NoError(json.Unmarshal(w.Body.Bytes(), &response))
+		asserts.Equal(3, response.ProfilesCount)
+		flags := map[string]bool{}
+		for _, profile := range response.Profiles {
+			flags[profile.Username] = profile.Following
+		}
+		return flags
+	}
+	asserts.Equal(map[string]bool{fan3.Username: true, fan2.Username: false},
+		list("/profiles/"+user.Username+"/followers?limit=2"), "Flags should be the viewer's")
+	asserts.Equal(map[string]bool{fan1.Username: false},
+		list("/profiles/"+user.Username+"/followers?limit=2&offset=2"))
+
+	req, _ := http.NewRequest("GET", "/profiles/ghost-user/following", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusNotFound, w.Code)
+}
+
+func TestProfileFollowCounts(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := gin.New()
+	r.Use(AuthMiddleware(false))
+	ProfileRetrieveRegister(r.Group("/profiles"))
+
+	resetDBWithMock()
+	mocked := userModelMocker(4)
+	user, followed1, followed2, follower := mocked[0], mocked[1], mocked[2], mocked[3]
+	user.following(followed1)
+	user.following(followed2)
+	follower.following(user)
+
+	counts := BatchGetFollowCounts([]uint{user.ID, followed1.ID, follower.ID})
+	asserts.Equal(FollowCounts{Following: 2, Followers: 1}, counts[user.ID])
+	asserts.Equal(FollowCounts{Following: 0, Followers: 1}, counts[followed1.ID])
+	asserts.Equal(FollowCounts{Following: 1, Followers: 0}, counts[follower.ID])
+
+	status := BatchGetFollowingStatus(user.ID, []uint{followed1.ID, follower.ID})
+	asserts.True(status[followed1.ID])
+	asserts.False(status[follower.ID])
+
+	req, _ := http.NewRequest("GET", "/profiles/"+user.Username, nil)
+	common.HeaderTokenMock(req, follower.ID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Contains(w.Body.String(), `"following":true,"followingCount":2,"followersCount":1`)
+}
+
+func TestUserUpdateUsernameConflict(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := gin.New()
+	r.Use(AuthMiddleware(true))
+	UserRegister(r.Group("/user"))
+
+	resetDBWithMock()
+	mocked := userModelMocker(2)
+	user, other := mocked[0], mocked[1]
+
+	update := func(username string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"user":{"username":"%s"}}`, username)
+		req, _ := http.NewRequest("PUT", "/user", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		common.HeaderTokenMock(req, user.ID)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := update(strings.ToUpper(other.Username))
+	asserts.Equal(http.StatusConflict, w.Code, "Taking another user's username should return 409")
+	asserts.Equal(`{"errors":{"username":"has already been taken"}}`, w.Body.String())
+
+	w = update(user.Username)
+	asserts.Equal(http.StatusOK, w.Code, "Keeping the own username should succeed")
+
+	w = update("renamed" + user.Username)
+	asserts.Equal(http.StatusOK, w.Code)
+	renamed, err := FindOneUser(&UserModel{ID: user.ID})
+	asserts.NoError(err)
+	asserts.Equal("renamed"+user.Username, renamed.Username)
+}
+
+func TestTokenIntrospect(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := gin.New()
+	r.Use(AuthMiddleware(true))
+	TokenRegister(r.Group("/token"))
+
+	resetDBWithMock()
+	token := common.GenToken(1)
+	req, _ := http.NewRequest("GET", "/token/introspect", nil)
+	req.Header.Set("Authorization", "Token "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Regexp(`{"claims":{"exp":\d+,"iat":\d+,"id":1}}`, w.Body.String())
+	asserts.NotContains(w.Body.String(), token, "The token itself should not be echoed")
+	asserts.NotContains(w.Body.String(), common.JWTSecret)
+
+	req, _ = http.NewRequest("GET", "/token/introspect", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	asserts.Equal(http.StatusUnauthorized, w.Code, "Missing token should return 401")
+}
+
+func TestTokenVerify(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := gin.New()
+	TokenAnonymousRegister(r.Group("/token"))
+
+	verify := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/token/verify", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	member := userModelMocker(1)[0]
+	w := verify(fmt.Sprintf(`{"token":"%s"}`, common.GenToken(member.ID)))
+	asserts.Equal(http.StatusOK, w.Code)
+	var response struct {
+		Valid     bool   `json:"valid"`
+		ExpiresAt string `json:"expiresAt"`
+	}
+	asserts.NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	asserts.True(response.Valid)
+	expiresAt, err := time.Parse("2006-01-02T15:04:05.999Z", response.ExpiresAt)
+	asserts.NoError(err)
+	asserts.WithinDuration(time.Now().Add(24*time.Hour), expiresAt, time.Minute)
+
+	expired, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"id":  1,
+		"exp": time.Now().Add(-time.Hour).Unix(),
+	}).SignedString([]byte(common.JWTSecret))
+	w = verify(fmt.Sprintf(`{"token":"%s"}`, expired))
+	asserts.Equal(http.StatusOK, w.Code, "Expired tokens should not abort with 401")
+	asserts.Equal(`{"valid":false}`, w.Body.String())
+
+	w = verify(`{"token":"not-a-jwt"}`)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal(`{"valid":false}`, w.Body.String())
+
+	unsigned, _ := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{
+		"id":  member.ID,
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	w = verify(fmt.Sprintf(`{"token":"%s"}`, unsigned))
+	asserts.Equal(`{"valid":false}`, w.Body.String(), "Unsigned tokens should not be valid")
+
+	issuedBefore := common.GenToken(member.ID)
+	changedAt := time.Now().Add(time.Second)
+	test_db.Model(&member).Update("password_changed_at", changedAt)
+	w = verify(fmt.Sprintf(`{"token":"%s"}`, issuedBefore))
+	asserts.Equal(`{"valid":false}`, w.Body.String(), "Tokens issued before a password change should not be valid")
+	test_db.Model(&member).Update("password_changed_at", nil)
+
+	asserts.NoError(SetUserDisabled(member.ID, true))
+	w = verify(fmt.Sprintf(`{"token":"%s"}`, common.GenToken(member.ID)))
+	asserts.Equal(`{"valid":false}`, w.Body.String(), "Tokens of disabled users should not be valid")
+	asserts.NoError(SetUserDisabled(member.ID, false))
+	test_db.Delete(&member)
+	w = verify(fmt.Sprintf(`{"token":"%s"}`, common.GenToken(member.ID)))
+	asserts.Equal(`{"valid":false}`, w.Body.String(), "Tokens of deleted users should not be valid")
+
+	w = verify(`{}`)
+	asserts.Equal(http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestLastActive(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := gin.New()
+	r.Use(AuthMiddleware(false), LastActiveMiddleware())
+	ProfileRetrieveRegister(r.Group("/profiles"))
+
+	resetDBWithMock()
+	lastActiveTouches.Lock()
+	lastActiveTouches.at = make(map[uint]time.Time)
+	lastActiveTouches.Unlock()
+	user := userModelMocker(1)[0]
+
+	updates := 0
+	test_db.Callback().Update().Register("test:count_updates", func(*gorm.DB) { updates++ })
+	defer test_db.Callback().Update().Remove("test:count_updates")
+
+	get := func(authenticated bool) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/profiles/"+user.Username+"/activity", nil)
+		if authenticated {
+			common.HeaderTokenMock(req, user.ID)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get(false)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Regexp(`"joinedAt":"\d{4}-\d{2}-\d{2}T`, w.Body.String())
+	asserts.Contains(w.Body.String(), `"lastActiveAt":null`, "Anonymous requests should not touch anyone")
+	asserts.Equal(0, updates)
+
+	for i := 0; i < 5; i++ {
+		get(true)
+	}
+	asserts.Equal(1, updates, "Rapid requests should only write once per interval")
+	w = get(false)
+	asserts.Regexp(`"lastActiveAt":"\d{4}-\d{2}-\d{2}T`, w.Body.String())
+
+	os.Setenv("LAST_ACTIVE_INTERVAL", "0s")
+	defer os.Unsetenv("LAST_ACTIVE_INTERVAL")
+	get(true)
+	asserts.Equal(2, updates, "A zero interval should write on every request")
+
+	os.Setenv("LAST_ACTIVE_INTERVAL", "1m")
+	lastActiveTouches.Lock()
+	lastActiveTouches.at[user.ID+1000] = time.Now().Add(-time.Hour)
+	lastActiveTouches.swept = time.Time{}
+	lastActiveTouches.Unlock()
+	TouchLastActive(user.ID)
+	lastActiveTouches.Lock()
+	_, stale := lastActiveTouches.at[user.ID+1000]
+	_, fresh := lastActiveTouches.at[user.ID]
+	lastActiveTouches.Unlock()
+	asserts.False(stale, "Entries older than the interval should be evicted")
+	asserts.True(fresh)
+}
+
+func TestAdminMiddleware(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := gin.New()
+	r.Use(AuthMiddleware(false), AdminMiddleware())
+	r.GET("/admin", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	resetDBWithMock()
+	mocked := userModelMocker(2)
+	admin, member := mocked[0], mocked[1]
+	test_db.Model(&admin).Update("admin", true)
+
+	for _, testData := range []struct {
+		userID       uint
+		expectedCode int
+	}{{0, http.StatusUnauthorized}, {member.ID, http.StatusForbidden}, {admin.ID, http.StatusOK}} {
+		req, _ := http.NewRequest("GET", "/admin", nil)
+		if testData.userID != 0 {
+			common.HeaderTokenMock(req, testData.userID)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		asserts.Equal(testData.expectedCode, w.Code, fmt.Sprintf("user %d", testData.userID))
+	}
+}
+
+func TestAdminDisableUser(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := gin.New()
+	UsersRegister(r.Group("/users"))
+	r.Use(AuthMiddleware(true))
+	UserRegister(r.Group("/user"))
+	AdminUsersRegister(r.Group("/admin", AdminMiddleware()))
+
+	resetDBWithMock()
+	mocked := userModelMocker(2)
+	admin, member := mocked[0], mocked[1]
+	test_db.Model(&admin).Update("admin", true)
+
+	request := func(method, path string, userID uint) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		common.HeaderTokenMock(req, userID)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	asserts.Equal(http.StatusOK, request("GET", "/user", member.ID).Code)
+
+	w := request("POST", "/admin/users/"+member.Username+"/disable", admin.ID)
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Equal(fmt.Sprintf(`{"user":{"disabled":true,"username":"%s"}}`, member.Username), w.Body.String())
+	w = request("GET", "/user", member.ID)
+	asserts.Equal(http.StatusForbidden, w.Code, "Disabled users' tokens should be rejected")
+	asserts.Equal(`{"errors":{"user":"account disabled"}}`, w.Body.String())
+	userModel, _ := FindOneUser(&UserModel{Username: member.Username})
+	asserts.Equal(member.Email, userModel.Email, "Disabling should keep the account data")
+	login := func() *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"user":{"email":"%s","password":"password123"}}`, member.Email)
+		req, _ := http.NewRequest("POST", "/users/login", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	w = login()
+	asserts.Equal(http.StatusForbidden, w.Code, "Disabled users should not get a new token")
+	asserts.Equal(`{"errors":{"user":"account disabled"}}`, w.Body.String())
+
+	asserts.Equal(http.StatusOK, request("POST", "/admin/users/"+member.Username+"/enable", admin.ID).Code)
+	asserts.Equal(http.StatusOK, request("GET", "/user", member.ID).Code)
+	asserts.Equal(http.StatusOK, login().Code)
+
+	asserts.Equal(http.StatusForbidden, request("POST", "/admin/users/"+admin.Username+"/disable", member.ID).Code)
+	asserts.Equal(http.StatusNotFound, request("POST", "/admin/users/ghost-user/disable", admin.ID).Code)
+}
+
+func TestPasswordReset(t *testing.T) {
+	asserts := assert.New(t)
+
+	r := gin.New()
+	UsersRegister(r.Group("/users"))
+	TokenAnonymousRegister(r.Group("/token"))
+	r.Use(AuthMiddleware(true))
+	UserRegister(r.Group("/user"))
+	resetDBWithMock()
+	member := userModelMocker(1)[0]
+	// A session opened before the reset, a second is the resolution of the iat claim
+	stolen, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"id":  member.ID,
+		"iat": time.Now().Add(-time.Minute).Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte(common.JWTSecret))
+	retrieve := func(token string) int {
+		req, _ := http.NewRequest("GET", "/user", nil)
+		req.Header.Set("Authorization", "Token "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	asserts.Equal(http.StatusOK, retrieve(stolen))
+
+	request := func(path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	resetFor := func(email string) *httptest.ResponseRecorder {
+		return request("/users/password-reset", fmt.Sprintf(`{"user":{"email":"%s"}}`, email))
+	}
+	confirm := func(token, password string) *httptest.ResponseRecorder {
+		return request("/users/password-reset/confirm", fmt.Sprintf(`{"user":{"token":"%s","password":"%s"}}`, token, password))
+	}
+
+	asserts.Equal(http.StatusServiceUnavailable, resetFor(member.Email).Code, "Resets need a mailer to deliver the token")
+
+	sent := map[string]string{}
+	PasswordResetMailer = func(userModel UserModel, token string) error {
+		sent[userModel.Email] = token
+		return nil
+	}
+	defer func() { PasswordResetMailer = nil }()
+
+	asserts.Equal(http.StatusUnprocessableEntity, resetFor("not-an-email").Code)
+	w := resetFor("nobody@example.com")
+	asserts.Equal(http.StatusAccepted, w.Code, "Unknown emails should get the same answer")
+	asserts.Empty(sent)
+
+	asserts.Equal(http.StatusAccepted, resetFor(member.Email).Code)
+	replaced := sent[member.Email]
+	asserts.Equal(http.StatusAccepted, resetFor(member.Email).Code)
+	token := sent[member.Email]
+	asserts.NotEqual(replaced, token)
+	asserts.Equal(http.StatusUnprocessableEntity, confirm(replaced, "newpassword1").Code, "A newer token should replace the outstanding one")
+
+	id, _, _ := strings.Cut(token, ".")
+	w = confirm(id+".forged", "newpassword1")
+	asserts.Equal(http.StatusUnprocessableEntity, w.Code)
+	asserts.Equal(`{"errors":{"token":"is invalid or has expired"}}`, w.Body.String())
+	asserts.Equal(http.StatusUnprocessableEntity, confirm("malformed", "newpassword1").Code)
+	asserts.Equal(http.StatusUnprocessableEntity, confirm(token, "short").Code)
+
+	w = confirm(token, "newpassword1")
+	asserts.Equal(http.StatusOK, w.Code)
+	asserts.Contains(w.Body.String(), member.Email)
+	var confirmed struct {
+		User struct {
+			Token string `json:"token"`
+		} `json:"user"`
+	}
+	asserts.NoError(json.Unmarshal(w.Body.Bytes(), &confirmed))
+	asserts.Equal(http.StatusOK, retrieve(confirmed.User.Token), "The token handed out by the reset should work")
+	asserts.Equal(http.StatusUnauthorized, retrieve(stolen), "Sessions opened before the reset should be revoked")
+	w = request("/token/verify", fmt.Sprintf(`{"token":"%s"}`, stolen))
+	asserts.Equal(`{"valid":false}`, w.Body.String())
+	userModel, _ := FindOneUser(&UserModel{ID: member.ID})
+	asserts.NoError(userModel.checkPassword("newpassword1"))
+	asserts.Error(userModel.checkPassword("password123"))
+	asserts.Equal(http.StatusUnprocessableEntity, confirm(token, "newpassword2").Code, "A token should only be used once")
+
+	asserts.Equal(http.StatusAccepted, resetFor(member.Email).Code)
+	test_db.Model(&PasswordResetModel{}).Where("user_id = ?", member.ID).Update("expires_at", time.Now().Add(-time.Minute))
+	asserts.Equal(http.StatusUnprocessableEntity, confirm(sent[member.Email], "newpassword2").Code, "Expired tokens should be refused")
+}
+
+func TestDefaultPasswordResetMailer(t *testing.T) {
+	asserts := assert.New(t)
+
+	os.Unsetenv("SMTP_HOST")
+	mode := gin.Mode()
+	defer gin.SetMode(mode)
+	gin.SetMode(gin.DebugMode)
+	asserts.Nil(DefaultPasswordResetMailer(), "Reset tokens should not be logged without SMTP, even in development")
+	gin.SetMode(gin.ReleaseMode)
+	asserts.Nil(DefaultPasswordResetMailer())
+
+	os.Setenv("SMTP_HOST", "smtp.invalid")
+	defer os.Unsetenv("SMTP_HOST")
+	asserts.NotNil(DefaultPasswordResetMailer())
+}
+
+func TestModelWithoutDB(t *testing.T) {
+	asserts := assert.New(t)
+
+	db := common.DB
+	common.DB = nil
+	defer func() { common.DB = db }()
+
+	asserts.PanicsWithError(common.ErrDBNotInitialized.Error(), func() {
+		FindOneUser(&UserModel{Username: "nobody"})
+	}, "Model helpers should name the missing initialization instead of dereferencing nil")
+}
+
 // This is a hack way to add test database for each case, as whole test will just share one database.
 // You can read TestWithoutAuth's comment to know how to not share database each case.
 func TestMain(m *testing.M) {
@@ -83,3 +83,61 @@ func NewLoginValidator() LoginValidator {
 	loginValidator := LoginValidator{}
 	return loginValidator
 }
+
+// UsernamesValidator binds a list of usernames for the batch profile endpoints.
+type UsernamesValidator struct {
+	Usernames []string `form:"usernames" json:"usernames" binding:"required,min=1,max=100"`
+}
+
+func (self *UsernamesValidator) Bind(c *gin.Context) error {
+	return common.Bind(c, self)
+}
+
+func NewUsernamesValidator() UsernamesValidator {
+	return UsernamesValidator{}
+}
+
+// TokenVerifyValidator binds the token POST /token/verify checks.
+type TokenVerifyValidator struct {
+	Token string `form:"token" json:"token" binding:"required"`
+}
+
+func (self *TokenVerifyValidator) Bind(c *gin.Context) error {
+	return common.Bind(c, self)
+}
+
+func NewTokenVerifyValidator() TokenVerifyValidator {
+	return TokenVerifyValidator{}
+}
+
+// PasswordResetValidator binds the email POST /users/password-reset sends a reset token to.
+type PasswordResetValidator struct {
+	User struct {
+		Email string `form:"email" json:"email" binding:"required,email"`
+	} `json:"user"`
+}
+
+func (self *PasswordResetValidator) Bind(c *gin.Context) error {
+	return common.Bind(c, self)
+}
+
+func NewPasswordResetValidator() PasswordResetValidator {
+	return PasswordResetValidator{}
+}
+
+// PasswordResetConfirmValidator binds the reset token and the new password of
+// POST /users/password-reset/confirm.
+type PasswordResetConfirmValidator struct {
+	User struct {
+		Token    string `form:"token" json:"token" binding:"required"`
+		Password string `form:"password" json:"password" binding:"required,min=8,max=255"`
+	} `json:"user"`
+}
+
+func (self *PasswordResetConfirmValidator) Bind(c *gin.Context) error {
+	return common.Bind(c, self)
+}
+
+func NewPasswordResetConfirmValidator() PasswordResetConfirmValidator {
+	return PasswordResetConfirmValidator{}
+}